@@ -0,0 +1,25 @@
+package browser
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Open attempts to open the given URL in the default web browser.
+func Open(url string) error {
+	t, err := findTool()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(t.name, append(t.args, url)...)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("open URL with %s: %w", t.name, err)
+	}
+	return nil
+}
+
+// A tool describes an external program used to open a URL.
+type tool struct {
+	name string   // the program that opens the URL
+	args []string // arguments preceding the URL
+}
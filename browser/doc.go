@@ -0,0 +1,5 @@
+// Package browser provides a way to open a URL in the default web browser.
+//
+// The URL is opened by running an external program, which must be installed:
+// On macOS, open; on Linux, xdg-open; on Windows, rundll32.
+package browser
@@ -0,0 +1,13 @@
+package browser
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func findTool() (tool, error) {
+	if _, err := exec.LookPath("xdg-open"); err != nil {
+		return tool{}, errors.New("no browser tool found (tried xdg-open)")
+	}
+	return tool{name: "xdg-open"}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !linux && !windows
+
+package browser
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func findTool() (tool, error) {
+	return tool{}, fmt.Errorf("browser on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,5 @@
+package browser
+
+func findTool() (tool, error) {
+	return tool{name: "rundll32", args: []string{"url.dll,FileProtocolHandler"}}, nil
+}
@@ -1,13 +1,5 @@
 package clipboard
 
-import (
-	"os/exec"
-	"strings"
-)
-
-// WriteString attempts to copy the given string to the system clipboard.
-func WriteString(s string) error {
-	cmd := exec.Command("pbcopy")
-	cmd.Stdin = strings.NewReader(s)
-	return cmd.Run()
+func findTool() (tool, error) {
+	return tool{name: "pbcopy", reader: "pbpaste"}, nil
 }
@@ -3,17 +3,36 @@ package clipboard
 import (
 	"errors"
 	"os"
-	"os/exec"
-	"strings"
 )
 
-// WriteString attempts to copy the given string to the system clipboard.
-func WriteString(s string) error {
-	// We can't call xsel if there isn't a DISPLAY set, since it won't work.
+// findTool selects a clipboard tool based on the display server in use.
+// Wayland is preferred if it is available, then X11.
+func findTool() (tool, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		t, err := firstTool(tool{
+			name:   "wl-copy",
+			reader: "wl-paste", read: []string{"--no-newline"},
+		})
+		if err == nil {
+			return t, nil
+		}
+		// Fall through and try X11, since XWayland may be available.
+	}
+
+	// We can't use the X11 tools if there isn't a DISPLAY set, since they
+	// won't work.
 	if os.Getenv("DISPLAY") == "" {
-		return errors.New("unable to copy to clipboard (no DISPLAY)")
+		return tool{}, errors.New("unable to access clipboard (no DISPLAY or WAYLAND_DISPLAY)")
 	}
-	cmd := exec.Command("xsel", "--clipboard")
-	cmd.Stdin = strings.NewReader(s)
-	return cmd.Run()
+	return firstTool(tool{
+		name:   "xclip",
+		write:  []string{"-selection", "clipboard"},
+		reader: "xclip",
+		read:   []string{"-selection", "clipboard", "-o"},
+	}, tool{
+		name:   "xsel",
+		write:  []string{"--clipboard", "--input"},
+		reader: "xsel",
+		read:   []string{"--clipboard", "--output"},
+	})
 }
@@ -0,0 +1,13 @@
+//go:build !darwin && !linux && !windows
+
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func findTool() (tool, error) {
+	return tool{}, fmt.Errorf("clipboard on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
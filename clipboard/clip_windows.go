@@ -0,0 +1,6 @@
+package clipboard
+
+// N.B. Reading the clipboard is not supported on Windows, since there is no
+// standard tool that reports its contents verbatim.
+
+func findTool() (tool, error) { return firstTool(tool{name: "clip.exe"}) }
@@ -0,0 +1,92 @@
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// WriteString attempts to copy the given string to the system clipboard.
+func WriteString(s string) error {
+	t, err := findTool()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(t.name, t.write...)
+	cmd.Stdin = strings.NewReader(s)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("copy to clipboard with %s: %w", t.name, err)
+	}
+	return nil
+}
+
+// ReadString attempts to read the contents of the system clipboard.  If the
+// clipboard cannot be read on this platform, it reports an error that wraps
+// [errors.ErrUnsupported].
+func ReadString() (string, error) {
+	t, err := findTool()
+	if err != nil {
+		return "", err
+	} else if t.reader == "" {
+		return "", fmt.Errorf("reading the clipboard with %s: %w", t.name, errors.ErrUnsupported)
+	}
+	out, err := exec.Command(t.reader, t.read...).Output()
+	if err != nil {
+		return "", fmt.Errorf("read clipboard with %s: %w", t.reader, err)
+	}
+	return string(out), nil
+}
+
+// Clear clears the system clipboard if it still contains s, so that a value
+// the user copied in the meantime is not clobbered. If the clipboard cannot be
+// read on this platform, Clear clears it unconditionally.
+func Clear(s string) error {
+	cur, err := ReadString()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return WriteString("")
+	} else if err != nil {
+		return err
+	} else if cur != s {
+		return nil // the user copied something else; leave it alone
+	}
+	return WriteString("")
+}
+
+// WriteStringWithTimeout copies s to the system clipboard, and arranges for
+// the clipboard to be cleared by Clear(s) after d has elapsed.  If d <= 0, it
+// is equivalent to WriteString.
+//
+// The clearing is done asynchronously, so it only takes effect if the calling
+// process is still running when d elapses.
+func WriteStringWithTimeout(s string, d time.Duration) error {
+	if err := WriteString(s); err != nil {
+		return err
+	}
+	if d > 0 {
+		time.AfterFunc(d, func() { Clear(s) })
+	}
+	return nil
+}
+
+// A tool describes an external program used to access the clipboard.
+type tool struct {
+	name   string   // the program that writes the clipboard
+	write  []string // arguments to copy stdin to the clipboard
+	reader string   // the program that reads the clipboard ("" if unsupported)
+	read   []string // arguments to print the clipboard to stdout
+}
+
+// firstTool returns the first of the candidate tools whose programs are
+// installed. If none are installed, it reports an error naming them.
+func firstTool(tools ...tool) (tool, error) {
+	var names []string
+	for _, t := range tools {
+		if _, err := exec.LookPath(t.name); err == nil {
+			return t, nil
+		}
+		names = append(names, t.name)
+	}
+	return tool{}, fmt.Errorf("no clipboard tool found (tried %s)", strings.Join(names, ", "))
+}
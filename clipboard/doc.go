@@ -1,2 +1,6 @@
 // Package clipboard provides basic access to the system clipboard.
+//
+// The clipboard is accessed by running an external program, which must be
+// installed: On macOS, pbcopy and pbpaste; on Linux, wl-copy and wl-paste for
+// Wayland, or xclip or xsel for X11; on Windows, clip.exe.
 package clipboard
@@ -11,12 +11,30 @@ import (
 	"github.com/creachadair/command"
 	"github.com/creachadair/keyfish/kfdb"
 	"github.com/creachadair/keyfish/kflib"
+	"golang.org/x/term"
 )
 
+// PFileKeyEnv is the name of the environment variable that holds the key used
+// to decrypt an encrypted passphrase file.
+const PFileKeyEnv = "KEYFISH_PFILE_KEY"
+
 // Settings are shared settings used by kf subcommands.
 type Settings struct {
 	DBPath string // path of database file (overrides KEYFISH_DB)
 	PFile  string // path of passphrase file
+	Quiet  bool   // suppress informational messages
+
+	// Interactive, if true, means a query that matches several records offers
+	// a menu of the candidates when stdin and stdout are terminals.
+	Interactive bool
+
+	// NoHistory, if true, means that record accesses are not added to the
+	// access history (see NoteAccess).
+	NoHistory bool
+
+	// Keychain, if true, means the passphrase is read from the login keychain
+	// if it is stored there. This is only supported on macOS.
+	Keychain bool
 }
 
 // LoadDB opens the database specified by the DBPath setting. If the database
@@ -26,6 +44,13 @@ func LoadDB(env *command.Env) (*kfdb.Store, error) {
 	return st, err
 }
 
+// LoadDBWithPassphrase is as LoadDB, but also returns the passphrase used to
+// open the database.
+func LoadDBWithPassphrase(env *command.Env) (*kfdb.Store, string, error) {
+	st, _, pp, err := openDBInternal(env)
+	return st, pp, err
+}
+
 // WatchDB opens a watcher for the database specified by the DBPath setting.
 // If the database does not exist, WatchDB reports an error.
 func WatchDB(env *command.Env) (*kflib.DBWatcher, error) {
@@ -41,20 +66,67 @@ func SaveDB(env *command.Env, s *kfdb.Store) error {
 	if err := kflib.SaveDB(s, DBPath(env)); err != nil {
 		return err
 	}
-	fmt.Fprintln(env, "<saved>")
+	Infof(env, "<saved>\n")
 	return nil
 }
 
+// Infof writes an informational message to env, unless the Quiet setting is
+// true. Messages written to env go to stderr, so that stdout carries only the
+// values the user asked for. Such values, prompts, and errors should not use
+// Infof.
+func Infof(env *command.Env, msg string, args ...any) {
+	if !env.Config.(*Settings).Quiet {
+		fmt.Fprintf(env, msg, args...)
+	}
+}
+
+// FindRecord finds the unique record in db matching query, as
+// kflib.FindRecord. If the query matches several records, the Interactive
+// setting is true, and stdin and stdout are both terminals, FindRecord lets
+// the user choose one of the candidates instead of reporting an error.
+func FindRecord(env *command.Env, db *kfdb.DB, query string, all bool) (kflib.FindResult, error) {
+	return FindRecordMin(env, db, query, all, kflib.MatchFuzzy)
+}
+
+// FindRecordMin is as FindRecord, but selects only a record matched with at
+// least the quality of minQuality, as kflib.FindRecordMin.
+func FindRecordMin(env *command.Env, db *kfdb.DB, query string, all bool, minQuality kflib.MatchQuality) (kflib.FindResult, error) {
+	res, err := kflib.FindRecordMin(db, query, all, minQuality)
+	var merr *kflib.MultipleMatchError
+	if err == nil || !env.Config.(*Settings).Interactive || !errors.As(err, &merr) ||
+		!term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return res, err
+	}
+	fr, err := kflib.PickRecord(merr.Found)
+	if err != nil {
+		return kflib.FindResult{}, err
+	}
+	return kflib.FindResult{Tag: merr.Tag, Index: fr.Index, Record: fr.Record}, nil
+}
+
 // DBPath returns the database path associated with env, or "".
+// The path is expanded as described by ExpandPath.
 func DBPath(env *command.Env) string {
-	set := env.Config.(*Settings)
-	if tail, ok := strings.CutPrefix(set.DBPath, "$0"); ok {
+	return ExpandPath(env.Config.(*Settings).DBPath)
+}
+
+// PFilePath returns the passphrase file path associated with env, or "".
+// The path is expanded as described by ExpandPath.
+func PFilePath(env *command.Env) string {
+	return ExpandPath(env.Config.(*Settings).PFile)
+}
+
+// ExpandPath expands a path given in the settings. A leading "$0" is replaced
+// by the directory containing the running program. After that, references to
+// environment variables are replaced by their values, as by [os.ExpandEnv].
+func ExpandPath(path string) string {
+	if tail, ok := strings.CutPrefix(path, "$0"); ok {
 		ep, err := os.Executable()
 		if err == nil {
-			return filepath.Join(filepath.Dir(ep), tail)
+			return filepath.Join(filepath.Dir(ep), os.ExpandEnv(tail))
 		}
 	}
-	return set.DBPath
+	return os.ExpandEnv(path)
 }
 
 func openDBInternal(env *command.Env) (_ *kfdb.Store, path, pp string, err error) {
@@ -64,12 +136,19 @@ func openDBInternal(env *command.Env) (_ *kfdb.Store, path, pp string, err error
 	}
 
 	set := env.Config.(*Settings)
-	if set.PFile != "" {
-		var data []byte
-		data, err = os.ReadFile(set.PFile)
-		pp = strings.TrimSpace(string(data))
+	if pfile := PFilePath(env); pfile != "" {
+		pp, err = kflib.ReadPFile(pfile, os.Getenv(PFileKeyEnv))
+		if errors.Is(err, kflib.ErrNoPFileKey) {
+			err = fmt.Errorf("%w (set %s)", err, PFileKeyEnv)
+		}
 	} else {
-		pp, err = kflib.GetPassphrase("Passphrase: ")
+		var ok bool
+		if set.Keychain {
+			pp, ok, err = keychainPassphrase(path)
+		}
+		if err == nil && !ok {
+			pp, err = kflib.GetPassphrase("Passphrase: ")
+		}
 	}
 	if err != nil {
 		return nil, "", "", fmt.Errorf("read passphrase: %w", err)
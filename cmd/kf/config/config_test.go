@@ -0,0 +1,116 @@
+package config_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/keyfish/cmd/kf/config"
+	gocmp "github.com/google/go-cmp/cmp"
+)
+
+func TestHistory(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache) // for platforms that ignore XDG_CACHE_HOME
+
+	set := &config.Settings{DBPath: filepath.Join(t.TempDir(), "test.db")}
+	env := (&command.C{Name: "test"}).NewEnv(set)
+
+	check := func(want ...string) {
+		t.Helper()
+		got, err := config.RecentLabels(env)
+		if err != nil {
+			t.Fatalf("RecentLabels: unexpected error: %v", err)
+		}
+		if diff := gocmp.Diff(got, want); diff != "" {
+			t.Errorf("RecentLabels (-got, +want):\n%s", diff)
+		}
+	}
+	note := func(label string) {
+		t.Helper()
+		if err := config.NoteAccess(env, label); err != nil {
+			t.Fatalf("NoteAccess(%q): unexpected error: %v", label, err)
+		}
+	}
+
+	check() // no history yet
+	note("a")
+	note("b")
+	note("")  // ignored
+	note("a") // moves to the front
+	check("a", "b")
+
+	set.NoHistory = true
+	note("c")
+	check("a", "b")
+	set.NoHistory = false
+
+	// The history file is private to the user.
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatalf("UserCacheDir: %v", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "keyfish", "recent-*"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("Find history file: got %q, %v", paths, err)
+	}
+	if fi, err := os.Stat(paths[0]); err != nil {
+		t.Fatalf("Stat history: %v", err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("History mode: got %v, want 0600", fi.Mode().Perm())
+	}
+
+	// The history is bounded.
+	var want []string
+	for i := range config.MaxHistory + 5 {
+		note(fmt.Sprint("r", i))
+		want = append([]string{fmt.Sprint("r", i)}, want...)
+	}
+	check(want[:config.MaxHistory]...)
+
+	if err := config.ClearHistory(env); err != nil {
+		t.Fatalf("ClearHistory: unexpected error: %v", err)
+	}
+	check()
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/user")
+	t.Setenv("XDG_DATA_HOME", "/home/user/.local/share")
+	t.Setenv("NAME", "main")
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Executable: %v", err)
+	}
+	bin := filepath.Dir(exe)
+
+	tests := []struct {
+		input, want string
+	}{
+		{"", ""},
+		{"/plain/path.kdb", "/plain/path.kdb"},
+		{"$HOME/keyfish.kdb", "/home/user/keyfish.kdb"},
+		{"${XDG_DATA_HOME}/keyfish/$NAME.kdb", "/home/user/.local/share/keyfish/main.kdb"},
+		{"$0/keyfish.kdb", filepath.Join(bin, "keyfish.kdb")},
+		{"$0/$NAME.kdb", filepath.Join(bin, "main.kdb")},
+		{"/data/$0.kdb", "/data/.kdb"}, // "$0" is special only as a prefix
+	}
+	for _, tc := range tests {
+		if got := config.ExpandPath(tc.input); got != tc.want {
+			t.Errorf("ExpandPath(%q): got %q, want %q", tc.input, got, tc.want)
+		}
+	}
+
+	set := &config.Settings{DBPath: "$HOME/db.kdb", PFile: "$HOME/pfile"}
+	env := (&command.C{Name: "test"}).NewEnv(set)
+	if got, want := config.DBPath(env), "/home/user/db.kdb"; got != want {
+		t.Errorf("DBPath: got %q, want %q", got, want)
+	}
+	if got, want := config.PFilePath(env), "/home/user/pfile"; got != want {
+		t.Errorf("PFilePath: got %q, want %q", got, want)
+	}
+}
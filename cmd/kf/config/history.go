@@ -0,0 +1,106 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/atomicfile"
+	"github.com/creachadair/command"
+)
+
+// MaxHistory is the maximum number of labels kept in the access history.
+const MaxHistory = 20
+
+// historyPath returns the path of the access history file for the database
+// associated with env. History is kept in the user cache directory rather
+// than next to the database, in a separate file for each database path.
+func historyPath(env *command.Env) (string, error) {
+	dbPath := DBPath(env)
+	if dbPath == "" {
+		return "", errors.New("no database path specified (set --db or KEYFISH_DB)")
+	}
+	if abs, err := filepath.Abs(dbPath); err == nil {
+		dbPath = abs
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(dbPath))
+	return filepath.Join(dir, "keyfish", "recent-"+hex.EncodeToString(sum[:8])), nil
+}
+
+// RecentLabels returns the labels of the records most recently accessed in
+// the database associated with env, most recent first. If there is no
+// history, it returns nil without error.
+func RecentLabels(env *command.Env) ([]string, error) {
+	path, err := historyPath(env)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(strings.Split(string(data), "\n"), func(s string) bool {
+		return s == ""
+	}), nil
+}
+
+// NoteAccess records that the record with the given label was accessed, by
+// moving label to the front of the access history for the database associated
+// with env. The history holds only labels, at most MaxHistory of them, and
+// the file is readable only by its owner. NoteAccess does nothing if the
+// NoHistory setting is true or label is empty.
+func NoteAccess(env *command.Env, label string) error {
+	if env.Config.(*Settings).NoHistory || label == "" {
+		return nil
+	}
+	labels, err := RecentLabels(env)
+	if err != nil {
+		return err
+	}
+	labels = slices.DeleteFunc(labels, func(s string) bool { return s == label })
+	labels = slices.Insert(labels, 0, label)
+	if len(labels) > MaxHistory {
+		labels = labels[:MaxHistory]
+	}
+	return writeHistory(env, labels)
+}
+
+// ClearHistory discards the access history for the database associated with
+// env, if it exists.
+func ClearHistory(env *command.Env) error {
+	path, err := historyPath(env)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeHistory replaces the access history for the database associated with
+// env with labels.
+func writeHistory(env *command.Env, labels []string) error {
+	path, err := historyPath(env)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return atomicfile.Tx(path, 0600, func(f *atomicfile.File) error {
+		_, err := f.Write([]byte(strings.Join(labels, "\n") + "\n"))
+		return err
+	})
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// errItemNotFound is the exit status reported by the security tool when the
+// requested keychain item does not exist.
+const errItemNotFound = 44
+
+// keychainService returns the service name under which database passphrases
+// are stored in the keychain. It may be set by KEYFISH_KEYCHAIN_SERVICE.
+func keychainService() string {
+	return cmp.Or(os.Getenv("KEYFISH_KEYCHAIN_SERVICE"), "keyfish")
+}
+
+// keychainAccount returns the account name under which the passphrase for the
+// database at path is stored in the keychain.
+func keychainAccount(path string) (string, error) {
+	return filepath.Abs(path)
+}
+
+// keychainPassphrase reads the passphrase for the database at path from the
+// login keychain. If no passphrase is stored, it returns "", false, nil.
+func keychainPassphrase(path string) (string, bool, error) {
+	acct, err := keychainAccount(path)
+	if err != nil {
+		return "", false, err
+	}
+	out, err := exec.Command("security", "find-generic-password",
+		"-s", keychainService(), "-a", acct, "-w").Output()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() == errItemNotFound {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("read keychain: %w", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), true, nil
+}
+
+// StoreKeychainPassphrase stores passphrase in the login keychain as the
+// passphrase for the database at path, replacing any existing value.
+func StoreKeychainPassphrase(path, passphrase string) error {
+	acct, err := keychainAccount(path)
+	if err != nil {
+		return err
+	}
+	svc := keychainService()
+	if strings.ContainsAny(acct+svc, "\"\\\n") {
+		return fmt.Errorf("invalid keychain account %q or service %q", acct, svc)
+	}
+
+	// The command is sent on stdin, and the passphrase is hex-encoded, so that
+	// the passphrase does not appear in the argument list of the process, and
+	// does not need to be quoted.
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -X %s\n",
+		svc, acct, hex.EncodeToString([]byte(passphrase))))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("write keychain: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
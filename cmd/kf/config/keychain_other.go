@@ -0,0 +1,13 @@
+//go:build !darwin
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func keychainPassphrase(string) (string, bool, error) {
+	return "", false, fmt.Errorf("keychain on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
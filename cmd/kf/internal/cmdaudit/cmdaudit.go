@@ -0,0 +1,83 @@
+// Package cmdaudit implements the "kf audit" subcommand.
+package cmdaudit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/flax"
+	"github.com/creachadair/keyfish/cmd/kf/config"
+	"github.com/creachadair/keyfish/kflib"
+)
+
+var Command = &command.C{
+	Name: "audit",
+	Help: `Report records with weak or missing credentials.
+
+Scan all the unarchived records in the database and report:
+
+ - Records with no stored password and no usable hashpass config.
+ - Stored passwords that are reused by more than one record.
+ - Records whose hashpass configs generate the same password.
+ - Stored passwords shorter than --min-length.
+ - Records with a username and host, but no OTP config.
+ - Stored passwords older than the rotate-after interval of the record.
+
+Records are reported by label. Passwords are never printed.`,
+
+	SetFlags: command.Flags(flax.MustBind, &auditFlags),
+	Run:      command.Adapt(runAudit),
+}
+
+var auditFlags struct {
+	MinLength int  `flag:"min-length,default=12,Report stored passwords shorter than this"`
+	JSON      bool `flag:"json,Write the report as JSON"`
+}
+
+// runAudit implements the "audit" subcommand.
+func runAudit(env *command.Env) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	rep := kflib.Audit(s.DB(), auditFlags.MinLength)
+	if auditFlags.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	if rep.IsEmpty() {
+		fmt.Println("No problems found")
+		return nil
+	}
+	printGroup("No password or hashpass config", rep.NoPassword)
+	if len(rep.Reused) != 0 {
+		fmt.Printf("Reused passwords (%d groups):\n", len(rep.Reused))
+		for _, g := range rep.Reused {
+			fmt.Printf("  %d records: %s\n", len(g), strings.Join(g, ", "))
+		}
+	}
+	if len(rep.SameHashpass) != 0 {
+		fmt.Printf("Same hashpass config (%d groups):\n", len(rep.SameHashpass))
+		for _, g := range rep.SameHashpass {
+			fmt.Printf("  %d records: %s\n", len(g), strings.Join(g, ", "))
+		}
+	}
+	printGroup(fmt.Sprintf("Passwords shorter than %d", auditFlags.MinLength), rep.Short)
+	printGroup("Logins without OTP", rep.NoOTP)
+	printGroup("Passwords due for rotation", rep.Expired)
+	return nil
+}
+
+func printGroup(title string, labels []string) {
+	if len(labels) == 0 {
+		return
+	}
+	fmt.Printf("%s (%d):\n", title, len(labels))
+	for _, label := range labels {
+		fmt.Printf("  %s\n", label)
+	}
+}
@@ -2,84 +2,264 @@
 package cmdcli
 
 import (
+	"bufio"
 	"cmp"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"os/exec"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
+	"unicode/utf8"
 
 	"github.com/creachadair/command"
 	"github.com/creachadair/flax"
-	"github.com/creachadair/keyfish/clipboard"
+	"github.com/creachadair/keyfish/browser"
 	"github.com/creachadair/keyfish/cmd/kf/config"
 	"github.com/creachadair/keyfish/kfdb"
 	"github.com/creachadair/keyfish/kflib"
-	"github.com/creachadair/keyfish/wordhash"
 	"github.com/creachadair/mds/value"
+	"github.com/creachadair/otp/otpauth"
+	"golang.org/x/term"
 )
 
 var Commands = []*command.C{
 	{
-		Name:     "list",
-		Usage:    "[query]",
-		Help:     "List the entries in the database.",
+		Name:  "list",
+		Usage: "[query]",
+		Help: `List the entries in the database.
+
+If a query is given, only matching records are listed. With --regex, the
+query is a regular expression, and the records listed are those whose
+label, title, or any hostname or alias it matches.
+
+With --expiring, list only records whose stored password is older than
+the rotate-after interval set on the record.`,
 		SetFlags: command.Flags(flax.MustBind, &listFlags),
 		Run:      command.Adapt(runList),
 	},
 	{
-		Name:     "print",
-		Usage:    "<query>",
-		Help:     "Print the password for the specified query.",
+		Name: "tags",
+		Help: `List the tags used in the database, with the number of records for each.
+
+Archived records are not counted unless -a is set.
+Use "record tag" and "record untag" to change the tags of a record.`,
+		SetFlags: command.Flags(flax.MustBind, &tagsFlags),
+		Run:      command.Adapt(runTags),
+	},
+	{
+		Name:  "print",
+		Usage: "<query>",
+		Help: `Print the password for the specified query.
+
+With --length, generate a hashpass of the specified length instead of
+the configured length. With --tag, use the specified hashpass tag; this
+takes precedence over a tag given in the query as "tag@label". These
+flags have no effect on stored passwords.
+
+With --field, use the value of the named field instead of the password:
+username, email, addr:<n>, host, title, notes, password, otp, or
+detail:<label>. The -d flag is shorthand for --field=detail:<label>.
+
+With --no-newline (-n), the output does not end with a newline, so that
+it can be piped to another program without trailing whitespace. If --otp
+is also set, the code still follows the password, separated by a space.`,
 		SetFlags: command.Flags(flax.MustBind, &pwFlags),
 		Run:      command.Adapt(runPW),
 	},
 	{
-		Name:     "copy",
-		Usage:    "<query>",
-		Help:     "Copy the password for the specified query to the clipboard.",
+		Name:  "copy",
+		Usage: "<query>",
+		Help: `Copy the password for the specified query to the clipboard.
+
+With --clear-after, wait for the specified duration and then clear the
+clipboard, unless its contents were changed in the meantime.
+
+With --show-login, also print the username and first host of the record,
+as user@host, to stderr, to confirm which account the password is for.
+
+With --length, generate a hashpass of the specified length instead of
+the configured length. With --tag, use the specified hashpass tag; this
+takes precedence over a tag given in the query as "tag@label". These
+flags have no effect on stored passwords.
+
+With --field, use the value of the named field instead of the password:
+username, email, addr:<n>, host, title, notes, password, otp, or
+detail:<label>. The -d flag is shorthand for --field=detail:<label>.`,
 		SetFlags: command.Flags(flax.MustBind, &pwFlags),
 		Run:      command.Adapt(runPW),
 	},
 	{
 		Name:  "otp",
-		Usage: "<query>",
+		Usage: "<query>\n--check <query> <code>",
 		Help: `Print a TOTP code for the specified query.
 
 If the specified query does not match a record with an OTP code,
 an error is reported. If a tag is set on the query, and the record
 has a detail whose contents are an OTP URL, that URL is used to
-generate a code instead of the base record's code.`,
+generate a code instead of the base record's code.
+
+With --window N, print the codes for the N time steps before and after
+the current one as well, with the time range in which each is valid,
+and mark the current code. This is useful when a code is about to
+expire, or to diagnose clock skew.
+
+With --check, compare the given code to the codes for nearby time steps,
+and report how far the local clock is from the clock that generated the
+code. The search covers --window steps in each direction (default 10).`,
 		SetFlags: command.Flags(flax.MustBind, &otpFlags),
 		Run:      command.Adapt(runOTP),
+
+		Commands: []*command.C{{
+			Name:  "export",
+			Usage: "[--all | <query>]",
+			Help: `Export the OTP configuration of a record as an otpauth URL.
+
+This command prints OTP secrets in plain text, so that they can be moved
+to another authenticator app. Be careful where the output goes!
+You will be asked to confirm before anything is printed, unless --force
+is set.
+
+With --all, export the OTP configurations of all unarchived records.
+With --qr, render each URL as a QR code in the terminal, for scanning
+by a phone. This requires the qrencode program to be installed.`,
+			SetFlags: command.Flags(flax.MustBind, &otpExportFlags),
+			Run:      command.Adapt(runOTPExport),
+		}, {
+			Name:  "url",
+			Usage: "<query>",
+			Help: `Print the otpauth URL for the OTP configuration of a record.
+
+The URL is printed alone on a line, in the form expected by authenticator
+apps and QR code generators. If a tag is set on the query, and the record
+has a detail whose contents are an OTP URL, that URL is printed instead.
+An empty issuer or account name is filled in from the record, and the
+algorithm, digits, and period from the database defaults.
+
+The URL contains the OTP secret. You will be asked to confirm before it
+is printed, unless --force is set.`,
+			SetFlags: command.Flags(flax.MustBind, &otpURLFlags),
+			Run:      command.Adapt(runOTPURL),
+		}},
+	},
+	{
+		Name: "recent",
+		Help: `List the labels of recently accessed records, most recent first.
+
+The print, copy, and otp commands remember the labels of the records they
+access, in a history file kept in the user cache directory separate from
+the database. The history holds only labels, never passwords or other
+secrets. Use the global --no-history flag to leave the history unchanged,
+or --clear to discard it.`,
+		SetFlags: command.Flags(flax.MustBind, &recentFlags),
+		Run:      command.Adapt(runRecent),
+	},
+	{
+		Name:  "login",
+		Usage: "<query>",
+		Help: `Print the username for the specified query.
+
+With --copy, copy the username to the clipboard instead, and print a
+non-cryptographic digest of it as a human-readable checksum.
+With --email, use the first e-mail address of the record if it does
+not have a username.`,
+		SetFlags: command.Flags(flax.MustBind, &loginFlags),
+		Run:      command.Adapt(runLogin),
+	},
+	{
+		Name:  "email",
+		Usage: "<query>",
+		Help: `Print the e-mail address for the specified query.
+
+This is the first e-mail address of the matching record, or if it has
+none, the default e-mail address of the database. Use "record add" with
+--email-tag to give a record a plus-addressed e-mail address.`,
+		Run: command.Adapt(runEmail),
+	},
+	{
+		Name:  "open",
+		Usage: "<query>",
+		Help: `Open the site for the specified query in a web browser.
+
+The site is https://<host> for a host of the matching record.
+If the record has more than one host, you will be asked to choose
+one of them, unless --first is set to open the first host.`,
+		SetFlags: command.Flags(flax.MustBind, &openFlags),
+		Run:      command.Adapt(runOpen),
 	},
 	{
 		Name:  "random",
 		Usage: "[flags] <length>",
 		Help: `Generate a cryptographically random password.
 
-By default, a password is output as ASCII letters and digits.
+` + genHelp + `
+
+With --set, the password is also stored on the record matching the
+given query, in addition to printing or copying it.
+
+With --preview, print the specified number of sample passwords with the
+current settings, each generated independently, and exit. This cannot
+be combined with --set or --copy.`,
+		SetFlags: command.Flags(flax.MustBind, &genFlags, &randFlags),
+		Run:      command.Adapt(runRandom),
+	},
+	{
+		Name:  "gen",
+		Usage: "[flags] <length>",
+		Help: `Generate a cryptographically random password without a database.
+
+This is like "random", but it never reads or writes the database, so it
+works even if no database exists.
+
+` + genHelp,
+		SetFlags: command.Flags(flax.MustBind, &genFlags),
+		Run:      command.Adapt(runGen),
+	},
+}
+
+// genHelp describes the password generation flags shared by the "random" and
+// "gen" subcommands.
+const genHelp = `By default, a password is output as ASCII letters and digits.
 Use --no-digits to exclude digits, --symbols to include punctuation.
+Use --symbol-set to choose which punctuation to include (implies --symbols).
+Use --each-class to ensure at least one character of each selected type.
 Use --words to choose words from a word list instead.
 Use --sep to choose the word separator when --words is set.
+Use --wordlist to choose words from a file instead of the built-in list.
+With --words, use --append-digits to add a group of random digits,
+and --title-case to capitalize one of the words.
+Use --pronounceable to generate alternating consonants and vowels.
 
 Output is written to stdout, or use --copy to send it to the
 clipboard. When --copy is set, a non-cryptographic digest of the
 generated value is printed to stdout as a human-readable checksum.
+Use --clear-after to clear the clipboard again after a delay.
 
-With --set, the password is also stored on the record matching the
-given query, in addition to printing or copying it.`,
-		SetFlags: command.Flags(flax.MustBind, &randFlags),
-		Run:      command.Adapt(runRandom),
-	},
-}
+With --show-entropy, an estimate of the entropy of the generated
+password in bits is printed to stderr.`
 
 var listFlags struct {
 	Arch  bool `flag:"a,Include archived entries in the output"`
 	NArch bool `flag:"n,Exclude unarchived entries from the output"`
+	Plain bool `flag:"plain,Do not color or fit the output to the terminal"`
+	Regex bool `flag:"regex,Treat the query as a regular expression"`
+	Exp   bool `flag:"expiring,List only records whose password is due for rotation"`
 }
 
+// ANSI escape sequences used to color list output. They are added after the
+// columns are padded, so they do not affect alignment.
+const (
+	ansiNormal = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiOTP    = "\x1b[36m"
+)
+
 // runList implements the "list" subcommand.
 func runList(env *command.Env, optQuery ...string) error {
 	var query string // everything
@@ -95,93 +275,218 @@ func runList(env *command.Env, optQuery ...string) error {
 	}
 	db := s.DB()
 
-	fr := kflib.FindRecords(db.Records, query)
+	var fr []kflib.FoundRecord
+	if listFlags.Regex {
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return env.Usagef("invalid regexp: %v", err)
+		}
+		for i, r := range db.Records {
+			if regexpMatch(re, r) {
+				fr = append(fr, kflib.FoundRecord{Index: i, Record: r})
+			}
+		}
+	} else {
+		fr = kflib.FindRecords(db.Records, query)
+	}
 	slices.SortFunc(fr, func(a, b kflib.FoundRecord) int {
 		return cmp.Compare(a.Record.Label, b.Record.Label)
 	})
+	now := time.Now()
+	fr = slices.DeleteFunc(fr, func(r kflib.FoundRecord) bool {
+		if listFlags.Exp && !kflib.PasswordExpired(r.Record, now) {
+			return true
+		} else if r.Record.Archived {
+			return !(listFlags.Arch || listFlags.NArch)
+		}
+		return listFlags.NArch
+	})
 
-	tw := tabwriter.NewWriter(os.Stdout, 4, 0, 1, ' ', 0)
+	// When writing to a terminal, fit titles to its width, mark records that
+	// have OTP configs, and (unless NO_COLOR is set) dim archived records.
+	var width int
+	var color bool
+	if fd := int(os.Stdout.Fd()); !listFlags.Plain && term.IsTerminal(fd) {
+		width, _, _ = term.GetSize(fd)
+		color = os.Getenv("NO_COLOR") == ""
+	}
+	labelWidth := 3
+	for _, r := range fr {
+		labelWidth = max(labelWidth, utf8.RuneCountInString(r.Record.Label))
+	}
+	titleWidth := width - (labelWidth + 1) - 4 // columns: label, tag, title
+
+	w := bufio.NewWriter(os.Stdout)
 	for _, r := range fr {
-		if r.Record.Archived {
-			if !(listFlags.Arch || listFlags.NArch) {
-				continue
-			}
-		} else if listFlags.NArch {
-			continue
-		}
 		tag := value.Cond(r.Record.Archived, "*", "-")
 		title := r.Record.Title
 		if title == "" && len(r.Record.Hosts) != 0 {
 			title = r.Record.Hosts[0]
 		}
-		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Record.Label, tag, title)
+		if width > 0 {
+			var mark string
+			if r.Record.OTP != nil {
+				mark = value.Cond(color, " "+ansiOTP+"[otp]"+ansiNormal, " [otp]")
+			}
+			title = truncate(title, titleWidth-len(" [otp]")) + mark
+		}
+		fmt.Fprintln(w, listLine(r.Record.Label, tag, title, labelWidth, color, r.Record.Archived))
+	}
+	return w.Flush()
+}
+
+// listLine formats a line of list output, with the label padded to
+// labelWidth. If color is true, the line is colored (dim if archived).
+func listLine(label, tag, title string, labelWidth int, color, archived bool) string {
+	line := fmt.Sprintf("%-*s %-3s %s", labelWidth, label, tag, title)
+	if color {
+		return value.Cond(archived, ansiDim, ansiNormal) + line + ansiNormal
+	}
+	return line
+}
+
+// regexpMatch reports whether re matches the label, title, or any host or
+// alias of r.
+func regexpMatch(re *regexp.Regexp, r *kfdb.Record) bool {
+	return re.MatchString(r.Label) || re.MatchString(r.Title) ||
+		slices.ContainsFunc(r.Hosts, re.MatchString) || slices.ContainsFunc(r.Aliases, re.MatchString)
+}
+
+// truncate returns s truncated to at most n runes, with an ellipsis marking
+// the truncation. If n <= 0, s is returned unmodified.
+func truncate(s string, n int) string {
+	rs := []rune(s)
+	if n <= 0 || len(rs) <= n {
+		return s
+	}
+	return string(rs[:n-1]) + "…"
+}
+
+var tagsFlags struct {
+	Arch bool `flag:"a,Include archived entries in the counts"`
+}
+
+// runTags implements the "tags" subcommand.
+func runTags(env *command.Env) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	count := make(map[string]int)
+	for _, r := range s.DB().Records {
+		if r.Archived && !tagsFlags.Arch {
+			continue
+		}
+		for _, t := range r.Tags {
+			count[t]++
+		}
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 4, 0, 1, ' ', 0)
+	for _, t := range slices.Sorted(maps.Keys(count)) {
+		fmt.Fprintf(tw, "%s\t%d\n", t, count[t])
 	}
 	return tw.Flush()
 }
 
 var pwFlags struct {
-	OTP    bool   `flag:"otp,Also generate a TOTP code if available"`
-	Detail string `flag:"d,Use the value of the specified detail"`
+	OTP        bool          `flag:"otp,Also generate a TOTP code if available"`
+	Detail     string        `flag:"d,Use the value of the specified detail"`
+	Field      string        `flag:"field,Use the value of the specified field"`
+	ClearAfter time.Duration `flag:"clear-after,Clear the clipboard after this long (copy only)"`
+	Length     int           `flag:"length,Override the hashpass length (hashpass records only)"`
+	Tag        string        `flag:"tag,Override the hashpass tag (hashpass records only)"`
+	NoNewline  bool          `flag:"no-newline,Do not print a trailing newline"`
+	N          bool          `flag:"n,Shorthand for --no-newline"`
+	ShowLogin  bool          `flag:"show-login,Also print the username and host of the record (copy only)"`
 }
 
 // runPW implements the "print" and "copy" subcommands.
 func runPW(env *command.Env, query string) error {
+	if pwFlags.Length < 0 {
+		return env.Usagef("invalid --length %d", pwFlags.Length)
+	} else if pwFlags.Detail != "" && pwFlags.Field != "" {
+		return env.Usagef("-d and --field are mutually exclusive")
+	}
 	s, err := config.LoadDB(env)
 	if err != nil {
 		return err
 	}
-	res, err := kflib.FindRecord(s.DB(), query, false)
+	res, err := config.FindRecord(env, s.DB(), query, false)
 	if err != nil {
 		return err
 	}
 
 	var pw string
 	if pwFlags.Detail != "" {
-		dv := slices.IndexFunc(res.Record.Details, func(d *kfdb.Detail) bool {
-			return strings.EqualFold(d.Label, pwFlags.Detail)
-		})
-		if dv < 0 {
-			return fmt.Errorf("no detail matching %q", pwFlags.Detail)
+		pwFlags.Field = "detail:" + pwFlags.Detail
+	}
+	if pwFlags.Field != "" {
+		pw, _, err = kflib.RecordField(s.DB(), res.Record, cmp.Or(pwFlags.Tag, res.Tag), pwFlags.Field)
+		if err != nil {
+			return err
 		}
-		pw = res.Record.Details[dv].Value
 	} else if res.Record.Password != "" {
 		pw = res.Record.Password
-	} else if pw, err = kflib.GenerateHashpass(s.DB(), res.Record, res.Tag); err != nil {
+	} else if pw, err = kflib.GenerateHashpassLength(s.DB(), res.Record, cmp.Or(pwFlags.Tag, res.Tag), pwFlags.Length); err != nil {
 		return err
 	}
+	var copied string
 	if env.Command.Name == "copy" {
-		if err := clipboard.WriteString(pw); err != nil {
-			return fmt.Errorf("copying password: %w", err)
+		digest, err := copyValue("password", pw)
+		if err != nil {
+			return err
 		}
-		pw = wordhash.New(pw)
+		copied, pw = pw, digest
 	}
 	fmt.Print(pw)
 
+	var otpErr error
 	if pwFlags.OTP {
 		otpURL := getOTPCode(res.Record, res.Tag)
 		if otpURL != nil {
-			otp, err := kflib.GenerateOTP(res.Record.OTP, 0)
+			otp, err := kflib.GenerateOTPWithDefaults(s.DB(), otpURL, 0)
 			if err != nil {
-				otp = "<invalid-otp>"
+				otp, otpErr = "<invalid-otp>", err
 			}
 			fmt.Print(" ", otp)
 		}
 	}
-	fmt.Println()
+	if !pwFlags.NoNewline && !pwFlags.N {
+		fmt.Println()
+	}
+	if copied != "" && pwFlags.ShowLogin {
+		fmt.Fprintf(env, "Login: %s\n", loginLabel(res.Record))
+	}
+	if otpErr != nil {
+		fmt.Fprintf(env, "Warning: %v\n", otpErr)
+	}
+	noteAccess(env, res.Record)
+	if copied != "" {
+		return clearClipboardAfter(env, copied, pwFlags.ClearAfter)
+	}
 	return nil
 }
 
 var otpFlags struct {
-	Shift int `flag:"s,Shift the time step forward by s"`
+	Shift  int  `flag:"s,Shift the time step forward by s"`
+	Window int  `flag:"window,Also print the codes for N steps before and after"`
+	Check  bool `flag:"check,Check a code and report the clock skew"`
 }
 
 // runOTP implements the "otp" subcommand.
-func runOTP(env *command.Env, query string) error {
+func runOTP(env *command.Env, query string, rest ...string) error {
+	if !otpFlags.Check && len(rest) != 0 {
+		return env.Usagef("unexpected arguments after query: %q", rest)
+	} else if otpFlags.Check && len(rest) == 0 {
+		return env.Usagef("--check requires a query and a code")
+	} else if otpFlags.Check && len(rest) > 1 {
+		return env.Usagef("extra arguments after code: %q", rest[1:])
+	}
 	s, err := config.LoadDB(env)
 	if err != nil {
 		return err
 	}
-	res, err := kflib.FindRecord(s.DB(), query, false)
+	res, err := config.FindRecord(env, s.DB(), query, false)
 	if err != nil {
 		return err
 	}
@@ -189,29 +494,311 @@ func runOTP(env *command.Env, query string) error {
 	if otpURL == nil {
 		return fmt.Errorf("no OTP config for %q", res.Record.Label)
 	}
-	otp, err := kflib.GenerateOTP(otpURL, otpFlags.Shift)
+	if otpFlags.Window < 0 {
+		return env.Usagef("window must be non-negative")
+	} else if otpFlags.Check {
+		return checkOTP(s.DB(), otpURL, rest[0], cmp.Or(otpFlags.Window, 10))
+	} else if otpFlags.Window == 0 {
+		otp, err := kflib.GenerateOTPWithDefaults(s.DB(), otpURL, otpFlags.Shift)
+		if err != nil {
+			return err
+		}
+		fmt.Println(otp)
+		noteAccess(env, res.Record)
+		return nil
+	}
+
+	period := int64(kflib.OTPWithDefaults(s.DB(), otpURL).Period)
+	cur := time.Now().Unix() / period
+	for off := -otpFlags.Window; off <= otpFlags.Window; off++ {
+		step := otpFlags.Shift + off
+		otp, err := kflib.GenerateOTPWithDefaults(s.DB(), otpURL, step)
+		if err != nil {
+			return err
+		}
+		start := time.Unix((cur+int64(step))*period, 0)
+		end := start.Add(time.Duration(period) * time.Second)
+		fmt.Printf("%+3d  %s–%s  %s%s\n", step, start.Format(time.TimeOnly), end.Format(time.TimeOnly),
+			otp, value.Cond(step == 0, "  (current)", ""))
+	}
+	noteAccess(env, res.Record)
+	return nil
+}
+
+// checkOTP reports the time step offset at which otpURL generates code, if
+// one within window steps of the current time does.
+func checkOTP(db *kfdb.DB, otpURL *otpauth.URL, code string, window int) error {
+	off, ok, err := kflib.MatchOTP(db, otpURL, code, window)
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("no match within ±%d steps", window)
+	}
+	if off == 0 {
+		fmt.Println("Code matches the current time step; no clock skew detected")
+		return nil
+	}
+	period := kflib.OTPWithDefaults(db, otpURL).Period
+	skew := time.Duration(off*period) * time.Second
+	fmt.Printf("Code matches time step %+d: the local clock is about %v %s\n",
+		off, skew.Abs(), value.Cond(off < 0, "fast", "slow"))
+	return nil
+}
+
+var recentFlags struct {
+	Clear bool `flag:"clear,Discard the access history"`
+}
+
+// runRecent implements the "recent" subcommand.
+func runRecent(env *command.Env) error {
+	if recentFlags.Clear {
+		if err := config.ClearHistory(env); err != nil {
+			return err
+		}
+		config.Infof(env, "<cleared history>\n")
+		return nil
+	}
+	labels, err := config.RecentLabels(env)
 	if err != nil {
 		return err
 	}
-	fmt.Println(otp)
+	for _, label := range labels {
+		fmt.Println(label)
+	}
 	return nil
 }
 
+var otpExportFlags struct {
+	All   bool `flag:"all,Export OTP configs for all records"`
+	QR    bool `flag:"qr,Render the URL as a QR code"`
+	Force bool `flag:"force,Do not ask for confirmation"`
+}
+
+// runOTPExport implements the "otp export" subcommand.
+func runOTPExport(env *command.Env, optQuery ...string) error {
+	if otpExportFlags.All != (len(optQuery) == 0) {
+		return env.Usagef("provide either a single query or --all")
+	} else if len(optQuery) > 1 {
+		return env.Usagef("extra arguments after query: %q", optQuery[1:])
+	}
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+
+	var recs []*kfdb.Record
+	if otpExportFlags.All {
+		for _, r := range s.DB().Records {
+			if r.OTP != nil && !r.Archived {
+				recs = append(recs, r)
+			}
+		}
+	} else {
+		res, err := config.FindRecord(env, s.DB(), optQuery[0], false)
+		if err != nil {
+			return err
+		} else if res.Record.OTP == nil {
+			return fmt.Errorf("no OTP config for %q", res.Record.Label)
+		}
+		recs = append(recs, res.Record)
+	}
+	if len(recs) == 0 {
+		return errors.New("no records have OTP configs")
+	}
+
+	if !otpExportFlags.Force {
+		ok, err := kflib.Confirm(fmt.Sprintf("Print OTP secrets for %d records?", len(recs)))
+		if err != nil {
+			return err
+		} else if !ok {
+			return errors.New("export cancelled")
+		}
+	}
+	for _, r := range recs {
+		fmt.Printf("%s: %s\n", r.Label, r.OTP)
+		if otpExportFlags.QR {
+			cmd := exec.CommandContext(env.Context(), "qrencode", "-t", "ANSIUTF8")
+			cmd.Stdin = strings.NewReader(r.OTP.String())
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("render QR code: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+var otpURLFlags struct {
+	Force bool `flag:"force,Do not ask for confirmation"`
+}
+
+// runOTPURL implements the "otp url" subcommand.
+func runOTPURL(env *command.Env, query string) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := config.FindRecord(env, s.DB(), query, false)
+	if err != nil {
+		return err
+	}
+	otpURL := getOTPCode(res.Record, res.Tag)
+	if otpURL == nil {
+		return fmt.Errorf("no OTP config for %q", res.Record.Label)
+	}
+	if !otpURLFlags.Force {
+		ok, err := kflib.Confirm(fmt.Sprintf("Print the OTP secret for %q?", res.Record.Label))
+		if err != nil {
+			return err
+		} else if !ok {
+			return errors.New("export cancelled")
+		}
+	}
+	fmt.Println(kflib.ExportOTP(s.DB(), res.Record, otpURL))
+	return nil
+}
+
+var loginFlags struct {
+	Copy       bool          `flag:"copy,Copy the username to the clipboard"`
+	Email      bool          `flag:"email,Use the first e-mail address if there is no username"`
+	ClearAfter time.Duration `flag:"clear-after,Clear the clipboard after this long (with --copy)"`
+}
+
+// runLogin implements the "login" subcommand.
+func runLogin(env *command.Env, query string) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := config.FindRecord(env, s.DB(), query, false)
+	if err != nil {
+		return err
+	}
+	login := res.Record.Username
+	if login == "" && loginFlags.Email && len(res.Record.Addrs) != 0 {
+		login = res.Record.Addrs[0]
+	}
+	if login == "" {
+		if loginFlags.Email {
+			return fmt.Errorf("record %q has no username or e-mail address", res.Record.Label)
+		}
+		return fmt.Errorf("record %q has no username", res.Record.Label)
+	}
+
+	if loginFlags.Copy {
+		digest, err := copyValue("username", login)
+		if err != nil {
+			return err
+		}
+		fmt.Println(digest)
+		return clearClipboardAfter(env, login, loginFlags.ClearAfter)
+	}
+	fmt.Println(login)
+	return nil
+}
+
+// runEmail implements the "email" subcommand.
+func runEmail(env *command.Env, query string) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := config.FindRecord(env, s.DB(), query, false)
+	if err != nil {
+		return err
+	}
+	addr, err := kflib.RecordAddress(s.DB(), res.Record)
+	if err != nil {
+		return err
+	}
+	fmt.Println(addr)
+	return nil
+}
+
+var openFlags struct {
+	First bool `flag:"first,Open the first host without asking"`
+}
+
+// runOpen implements the "open" subcommand.
+func runOpen(env *command.Env, query string) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := config.FindRecord(env, s.DB(), query, false)
+	if err != nil {
+		return err
+	}
+	hosts := res.Record.Hosts
+	if len(hosts) == 0 {
+		return fmt.Errorf("record %q has no hosts", res.Record.Label)
+	}
+	host := hosts[0]
+	if len(hosts) > 1 && !openFlags.First {
+		for i, h := range hosts {
+			fmt.Fprintf(env, "%d. %s\n", i+1, h)
+		}
+		fmt.Fprint(env, "Open which host? ")
+		ln, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read response: %w", err)
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(ln))
+		if err != nil || v < 1 || v > len(hosts) {
+			return fmt.Errorf("invalid selection %q", strings.TrimSpace(ln))
+		}
+		host = hosts[v-1]
+	}
+	return browser.Open("https://" + host)
+}
+
+// genFlags are the flags shared by the "random" and "gen" subcommands.
+var genFlags struct {
+	Words      bool          `flag:"words,Generate words instead of characters"`
+	Pronounce  bool          `flag:"pronounceable,Generate a pronounceable password"`
+	Copy       bool          `flag:"copy,Copy the generated password to the clipboard"`
+	NoDigit    bool          `flag:"no-digits,Omit digits from the generated password"`
+	Symbols    bool          `flag:"symbols,Include punctuation in the generated password"`
+	SymSet     string        `flag:"symbol-set,Use these punctuation symbols (implies --symbols)"`
+	WordList   string        `flag:"wordlist,Read the word list for --words from this file"`
+	AppDigits  int           `flag:"append-digits,Append this many digits to --words"`
+	TitleCase  bool          `flag:"title-case,Capitalize one word with --words"`
+	EachClass  bool          `flag:"each-class,Include at least one character of each type"`
+	WordSep    string        `flag:"sep,default='-',Word separator"`
+	Entropy    bool          `flag:"show-entropy,Print the estimated entropy to stderr"`
+	CheckPwned bool          `flag:"check-pwned,Check the password against known breaches (uses the network)"`
+	ClearAfter time.Duration `flag:"clear-after,Clear the clipboard after this long (with --copy)"`
+}
+
 var randFlags struct {
-	Words   bool   `flag:"words,Generate words instead of characters"`
-	Copy    bool   `flag:"copy,Copy the generated password to the clipboard"`
-	NoDigit bool   `flag:"no-digits,Omit digits from the generated password"`
-	Symbols bool   `flag:"symbols,Include punctuation in the generated password"`
-	WordSep string `flag:"sep,default='-',Word separator"`
 	Set     string `flag:"set,Store the generated password in this record"`
+	Preview int    `flag:"preview,Print this many sample passwords and exit"`
 }
 
+// runRandom implements the "random" subcommand.
 func runRandom(env *command.Env, length string) error {
-	n, err := strconv.Atoi(length)
+	n, err := parseGenFlags(env, length)
 	if err != nil {
-		return fmt.Errorf("invalid length: %w", err)
-	} else if n <= 0 {
-		return env.Usagef("the length (-n) must be positive")
+		return err
+	}
+	if randFlags.Preview < 0 {
+		return env.Usagef("invalid --preview %d", randFlags.Preview)
+	} else if randFlags.Preview > 0 {
+		if randFlags.Set != "" || genFlags.Copy {
+			return env.Usagef("--preview cannot be combined with --set or --copy")
+		}
+		for i := range randFlags.Preview {
+			pw, bits, err := generatePassword(env, n)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				printEntropy(env, bits)
+			}
+			fmt.Println(pw)
+		}
+		return nil
 	}
 
 	var s *kfdb.Store
@@ -222,40 +809,164 @@ func runRandom(env *command.Env, length string) error {
 		if err != nil {
 			return err
 		}
-		fr, err := kflib.FindRecord(s.DB(), randFlags.Set, false)
+		fr, err := config.FindRecordMin(env, s.DB(), randFlags.Set, false, kflib.MatchSubstring)
 		if err != nil {
 			return err
 		}
 		r = fr.Record
 	}
 
+	pw, bits, err := generatePassword(env, n)
+	if err != nil {
+		return err
+	}
+	printEntropy(env, bits)
+	if r != nil {
+		kflib.SetPassword(r, pw, time.Now())
+		config.Infof(env, "Setting password on record %q\n", r.Label)
+		if err := config.SaveDB(env, s); err != nil {
+			return err
+		}
+	}
+	return outputPassword(env, pw)
+}
+
+// runGen implements the "gen" subcommand.
+func runGen(env *command.Env, length string) error {
+	n, err := parseGenFlags(env, length)
+	if err != nil {
+		return err
+	}
+	pw, bits, err := generatePassword(env, n)
+	if err != nil {
+		return err
+	}
+	printEntropy(env, bits)
+	return outputPassword(env, pw)
+}
+
+// parseGenFlags parses the requested password length and checks genFlags.
+func parseGenFlags(env *command.Env, length string) (int, error) {
+	n, err := strconv.Atoi(length)
+	if err != nil {
+		return 0, fmt.Errorf("invalid length: %w", err)
+	} else if n <= 0 {
+		return 0, env.Usagef("the length (-n) must be positive")
+	}
+
+	// The character options do not apply to words or pronounceable passwords.
+	var mode string
+	switch {
+	case genFlags.Words && genFlags.Pronounce:
+		return 0, env.Usagef("--words and --pronounceable are mutually exclusive")
+	case genFlags.Words:
+		mode = "--words"
+	case genFlags.Pronounce:
+		mode = "--pronounceable"
+	}
+	if mode != "" {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"--each-class", genFlags.EachClass},
+			{"--symbol-set", genFlags.SymSet != ""},
+			{"--symbols", genFlags.Symbols},
+			{"--no-digits", genFlags.NoDigit},
+		} {
+			if f.set {
+				return 0, env.Usagef("%s cannot be used with %s", f.name, mode)
+			}
+		}
+	}
+
+	// The word options apply only to words.
+	if !genFlags.Words {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"--append-digits", genFlags.AppDigits != 0},
+			{"--title-case", genFlags.TitleCase},
+			{"--wordlist", genFlags.WordList != ""},
+		} {
+			if f.set {
+				return 0, env.Usagef("%s can only be used with --words", f.name)
+			}
+		}
+	}
+	if genFlags.SymSet != "" {
+		if err := kflib.CheckSymbols(genFlags.SymSet); err != nil {
+			return 0, env.Usagef("invalid symbol set: %v", err)
+		}
+	}
+	if genFlags.WordList != "" {
+		if err := kflib.LoadWordList(genFlags.WordList); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// generatePassword generates a random password of length n as specified by
+// genFlags, and returns it along with an estimate of its entropy in bits.
+// It does not access the database.
+func generatePassword(env *command.Env, n int) (string, float64, error) {
 	var pw string
-	if randFlags.Words {
-		pw = kflib.RandomWords(n, randFlags.WordSep)
+	var bits float64
+	if genFlags.Words {
+		opts := kflib.WordOptions{
+			Digits:    genFlags.AppDigits,
+			TitleCase: genFlags.TitleCase,
+		}
+		pw = kflib.RandomWordsWith(n, genFlags.WordSep, opts)
+		bits = kflib.WordsEntropyWith(n, opts)
+	} else if genFlags.Pronounce {
+		pw = kflib.RandomPronounceable(n)
+		bits = kflib.PronounceableEntropy(n)
 	} else {
 		cs := kflib.Letters
-		if !randFlags.NoDigit {
+		if !genFlags.NoDigit {
 			cs |= kflib.Digits
 		}
-		if randFlags.Symbols {
+		if genFlags.Symbols || genFlags.SymSet != "" {
 			cs |= kflib.Symbols
 		}
-		pw = kflib.RandomChars(n, cs)
+		if genFlags.EachClass {
+			pw, _ = kflib.RandomCharsEnsure(n, cs, genFlags.SymSet) // checked above
+		} else {
+			pw, _ = kflib.RandomCharsCustom(n, cs, genFlags.SymSet) // checked above
+		}
+		bits, _ = kflib.CharsEntropyCustom(n, cs, genFlags.SymSet) // checked above
 	}
-
-	if r != nil {
-		r.Password = pw
-		fmt.Fprintf(env, "Setting password on record %q\n", r.Label)
-		if err := config.SaveDB(env, s); err != nil {
-			return err
+	if genFlags.CheckPwned {
+		n, err := kflib.CheckPwnedPassword(env.Context(), pw)
+		if err != nil {
+			return "", 0, err
+		} else if n != 0 {
+			return "", 0, fmt.Errorf("generated password was seen %d times in breach data, not used", n)
 		}
 	}
+	return pw, bits, nil
+}
 
-	if randFlags.Copy {
-		if err := clipboard.WriteString(pw); err != nil {
-			return fmt.Errorf("copying password: %w", err)
+// printEntropy prints the estimated entropy bits if genFlags.Entropy is set.
+func printEntropy(env *command.Env, bits float64) {
+	if genFlags.Entropy {
+		fmt.Fprintf(env, "Estimated entropy: %.1f bits\n", bits)
+	}
+}
+
+// outputPassword prints pw, or copies it to the clipboard if genFlags.Copy is
+// set.
+func outputPassword(env *command.Env, pw string) error {
+	if genFlags.Copy {
+		digest, err := copyValue("password", pw)
+		if err != nil {
+			return err
 		}
-		pw = wordhash.New(pw)
+		fmt.Println(digest)
+		return clearClipboardAfter(env, pw, genFlags.ClearAfter)
 	}
 
 	fmt.Println(pw)
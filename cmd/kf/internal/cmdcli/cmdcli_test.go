@@ -0,0 +1,152 @@
+package cmdcli
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/keyfish/kfdb"
+	"github.com/creachadair/keyfish/wordhash"
+	"github.com/creachadair/mds/mtest"
+)
+
+func TestCopyValue(t *testing.T) {
+	const secret = "correct-horse-battery-staple"
+
+	t.Run("OK", func(t *testing.T) {
+		var got string
+		mtest.Swap(t, &writeClipboard, func(s string) error { got = s; return nil })
+
+		digest, err := copyValue("password", secret)
+		if err != nil {
+			t.Fatalf("copyValue: unexpected error: %v", err)
+		}
+		if got != secret {
+			t.Errorf("Clipboard: got %q, want %q", got, secret)
+		}
+		if want := wordhash.New(secret); digest != want {
+			t.Errorf("Digest: got %q, want %q", digest, want)
+		}
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		errFail := errors.New("no clipboard for you")
+		mtest.Swap(t, &writeClipboard, func(string) error { return errFail })
+
+		digest, err := copyValue("password", secret)
+		if !errors.Is(err, errFail) {
+			t.Fatalf("copyValue: got %v, want %v", err, errFail)
+		}
+		if digest != "" {
+			t.Errorf("Digest: got %q, want empty after a failed copy", digest)
+		}
+		if msg := err.Error(); strings.Contains(msg, secret) {
+			t.Errorf("Error leaks the copied value: %q", msg)
+		} else if !strings.Contains(msg, "password was not copied") {
+			t.Errorf("Error %q does not report the failed copy", msg)
+		}
+	})
+}
+
+func TestLoginLabel(t *testing.T) {
+	tests := []struct {
+		rec  *kfdb.Record
+		want string
+	}{
+		{&kfdb.Record{Username: "alice", Hosts: kfdb.Strings{"a.com", "b.com"}}, "alice@a.com"},
+		{&kfdb.Record{Username: "alice"}, "alice"},
+		{&kfdb.Record{Hosts: kfdb.Strings{"a.com"}}, "(no username)@a.com"},
+		{&kfdb.Record{Label: "x"}, "(no username or host)"},
+	}
+	for _, tc := range tests {
+		if got := loginLabel(tc.rec); got != tc.want {
+			t.Errorf("loginLabel(%+v): got %q, want %q", tc.rec, got, tc.want)
+		}
+	}
+}
+
+func TestRegexpMatch(t *testing.T) {
+	r := &kfdb.Record{
+		Label:   "mail",
+		Title:   "Example Mail",
+		Hosts:   kfdb.Strings{"mail.example.com"},
+		Aliases: kfdb.Strings{"webmail.example.net"},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`^mail$`, true},
+		{`Example`, true},
+		{`\.example\.com$`, true},
+		{`^webmail\.`, true},
+		{`example\.net`, true},
+		{`^nonesuch`, false},
+	}
+	for _, tc := range tests {
+		if got := regexpMatch(regexp.MustCompile(tc.expr), r); got != tc.want {
+			t.Errorf("regexpMatch(%q): got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseGenFlags(t *testing.T) {
+	saved := genFlags
+	t.Cleanup(func() { genFlags = saved })
+	env := (&command.C{Name: "gen"}).NewEnv(nil)
+
+	tests := []struct {
+		desc string
+		set  func()
+		ok   bool
+	}{
+		{"defaults", func() {}, true},
+		{"words", func() { genFlags.Words = true }, true},
+		{"symbols", func() { genFlags.Symbols, genFlags.EachClass = true, true }, true},
+		{"words and pronounceable", func() { genFlags.Words, genFlags.Pronounce = true, true }, false},
+		{"words and each-class", func() { genFlags.Words, genFlags.EachClass = true, true }, false},
+		{"words and symbol-set", func() { genFlags.Words, genFlags.SymSet = true, "!@" }, false},
+		{"pronounceable and symbols", func() { genFlags.Pronounce, genFlags.Symbols = true, true }, false},
+		{"pronounceable and no-digits", func() { genFlags.Pronounce, genFlags.NoDigit = true, true }, false},
+		{"words and title-case", func() { genFlags.Words, genFlags.TitleCase, genFlags.AppDigits = true, true, 2 }, true},
+		{"append-digits without words", func() { genFlags.AppDigits = 2 }, false},
+		{"title-case without words", func() { genFlags.TitleCase = true }, false},
+		{"pronounceable and title-case", func() { genFlags.Pronounce, genFlags.TitleCase = true, true }, false},
+		{"wordlist without words", func() { genFlags.WordList = "words.txt" }, false},
+	}
+	for _, tc := range tests {
+		genFlags = saved
+		tc.set()
+		_, err := parseGenFlags(env, "12")
+		if got := err == nil; got != tc.ok {
+			t.Errorf("parseGenFlags (%s): got error %v, want ok=%v", tc.desc, err, tc.ok)
+		}
+	}
+}
+
+func TestListLine(t *testing.T) {
+	// The title column must begin at the same visible position whether or not
+	// the line is colored or archived, and with an OTP mark.
+	strip := strings.NewReplacer(ansiNormal, "", ansiDim, "", ansiOTP, "")
+	tests := []struct {
+		label, tag, title string
+		color, archived   bool
+	}{
+		{"label", "-", "Title", false, false},
+		{"label", "-", "Title", true, false},
+		{"lbl", "*", "Title", true, true},
+		{"longlabel", "-", "Title " + ansiOTP + "[otp]" + ansiNormal, true, false},
+		{"x", "-", "Title [otp]", false, false},
+	}
+	for _, tc := range tests {
+		got := listLine(tc.label, tc.tag, tc.title, 10, tc.color, tc.archived)
+		if pos := strings.Index(strip.Replace(got), "Title"); pos != 15 {
+			t.Errorf("listLine(%q, %q, %q): title at column %d, want 15", tc.label, tc.tag, tc.title, pos)
+		}
+		if tc.color != strings.HasPrefix(got, "\x1b[") {
+			t.Errorf("listLine(%q): color is %v, got %q", tc.label, tc.color, got)
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package cmdcli
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
+	"github.com/creachadair/command"
+	"github.com/creachadair/keyfish/clipboard"
+	"github.com/creachadair/keyfish/cmd/kf/config"
 	"github.com/creachadair/keyfish/kfdb"
+	"github.com/creachadair/keyfish/wordhash"
 	"github.com/creachadair/otp/otpauth"
 )
 
@@ -21,3 +27,62 @@ func getOTPCode(rec *kfdb.Record, tag string) *otpauth.URL {
 	}
 	return rec.OTP
 }
+
+// loginLabel returns a description of the account of rec, as user@host, or
+// whichever of those rec has.
+func loginLabel(rec *kfdb.Record) string {
+	var host string
+	if len(rec.Hosts) != 0 {
+		host = rec.Hosts[0]
+	}
+	switch {
+	case rec.Username != "" && host != "":
+		return rec.Username + "@" + host
+	case rec.Username != "":
+		return rec.Username
+	case host != "":
+		return "(no username)@" + host
+	default:
+		return "(no username or host)"
+	}
+}
+
+// noteAccess adds rec to the access history. The history is a convenience,
+// so a failure to update it is reported but does not fail the command.
+func noteAccess(env *command.Env, rec *kfdb.Record) {
+	if err := config.NoteAccess(env, rec.Label); err != nil {
+		config.Infof(env, "Warning: updating history: %v\n", err)
+	}
+}
+
+// writeClipboard copies a string to the system clipboard. It is a variable so
+// that tests can simulate clipboard failures.
+var writeClipboard = clipboard.WriteString
+
+// copyValue copies s to the clipboard and returns a non-cryptographic digest
+// of s the caller can show to confirm the copy. If the copy fails, copyValue
+// reports an error describing what (e.g., "password") was not copied; the
+// error never includes s itself, and no digest is returned.
+func copyValue(what, s string) (string, error) {
+	if err := writeClipboard(s); err != nil {
+		return "", fmt.Errorf("copy failed, %s was not copied to the clipboard: %w", what, err)
+	}
+	return wordhash.New(s), nil
+}
+
+// clearClipboardAfter waits for d to elapse and then clears s from the
+// clipboard, if it has not since been replaced. If d <= 0 it does nothing.
+func clearClipboardAfter(env *command.Env, s string, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	config.Infof(env, "Clearing clipboard in %v\n", d)
+	select {
+	case <-env.Context().Done():
+	case <-time.After(d):
+	}
+	if err := clipboard.Clear(s); err != nil {
+		return fmt.Errorf("clearing clipboard: %w", err)
+	}
+	return nil
+}
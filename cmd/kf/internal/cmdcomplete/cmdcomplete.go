@@ -0,0 +1,167 @@
+// Package cmdcomplete implements shell completion for the kf command.
+package cmdcomplete
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/keyfish/cmd/kf/config"
+)
+
+var Commands = []*command.C{
+	{
+		Name:  "completion",
+		Usage: "bash|zsh|fish",
+		Help: `Print a shell completion script for the specified shell.
+
+To enable completion, evaluate the output in your shell profile, e.g.,
+
+  eval "$(kf completion bash)"           # bash
+  eval "$(kf completion zsh)"            # zsh
+  kf completion fish | source            # fish
+
+Command names are always completed. Record labels are completed only when
+the passphrase can be read without a prompt, that is, when --kf.pfile is
+set on the command line being completed.`,
+		Run: command.Adapt(runCompletion),
+	},
+	{
+		Name:        "__complete",
+		Usage:       "<word> ...",
+		Help:        "Print completion candidates for a partial command line.",
+		Unlisted:    true,
+		CustomFlags: true,
+		Run:         command.Adapt(runComplete),
+	},
+}
+
+// runCompletion implements the "completion" subcommand.
+func runCompletion(env *command.Env, shell string) error {
+	script, ok := scripts[shell]
+	if !ok {
+		return env.Usagef("unsupported shell %q", shell)
+	}
+	fmt.Print(script)
+	return nil
+}
+
+var scripts = map[string]string{
+	"bash": `_kf_complete() {
+  local IFS=$'\n'
+  COMPREPLY=($(kf __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _kf_complete kf
+`,
+	"zsh": `_kf_complete() {
+  local -a cands
+  cands=("${(@f)$(kf __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+  compadd -a cands
+}
+compdef _kf_complete kf
+`,
+	"fish": `function __kf_complete
+  set -l words (commandline -opc)[2..-1] (commandline -ct)
+  kf __complete $words 2>/dev/null
+end
+complete -c kf -f -a '(__kf_complete)'
+`,
+}
+
+// runComplete implements the hidden "__complete" subcommand. The arguments
+// are the words of the command line after the program name, of which the last
+// is the (possibly empty) word being completed. Errors are not reported, so
+// that a failure does not disrupt the user's shell.
+func runComplete(env *command.Env, words ...string) error {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	partial := words[len(words)-1]
+
+	// Apply global flags, so that we can find the database.
+	set := *env.Config.(*config.Settings)
+	var args []string
+	for i := 0; i < len(words)-1; i++ {
+		w := words[i]
+		name, val, hasVal := strings.Cut(strings.TrimLeft(w, "-"), "=")
+		if !strings.HasPrefix(w, "-") {
+			args = append(args, w)
+			continue
+		} else if (name == "db" || name == "kf.pfile") && !hasVal && i+1 < len(words)-1 {
+			i++
+			val = words[i]
+		}
+		switch name {
+		case "db":
+			set.DBPath = val
+		case "kf.pfile":
+			set.PFile = val
+		}
+	}
+	if strings.HasPrefix(partial, "-") {
+		return nil // flags are not completed
+	}
+
+	// Complete command names, if the partial word can be one.
+	root := env
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	cmd := root.Command
+	for _, arg := range args {
+		next := findCommand(cmd, arg)
+		if next == nil {
+			cmd = nil
+			break
+		}
+		cmd = next
+	}
+	if cmd != nil {
+		for _, sub := range cmd.Commands {
+			if !sub.Unlisted && strings.HasPrefix(sub.Name, partial) {
+				fmt.Println(sub.Name)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return nil // only commands are valid here
+	}
+
+	// Complete record labels, if the database can be opened without a prompt.
+	if set.PFile == "" {
+		return nil
+	}
+	env.Config = &set
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return nil
+	}
+	tag, query, ok := strings.Cut(partial, "@")
+	if !ok {
+		tag, query = "", partial
+	} else {
+		tag += "@"
+	}
+	var labels []string
+	for _, r := range s.DB().Records {
+		if !r.Archived && r.Label != "" && strings.HasPrefix(r.Label, query) {
+			labels = append(labels, tag+r.Label)
+		}
+	}
+	slices.Sort(labels)
+	for _, label := range labels {
+		fmt.Println(label)
+	}
+	return nil
+}
+
+// findCommand returns the subcommand of c with the given name, or nil.
+func findCommand(c *command.C, name string) *command.C {
+	for _, sub := range c.Commands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@
 package cmddb
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -28,11 +29,40 @@ var Command = &command.C{
 			Help: "Change the access key on the database.",
 			Run:  command.Adapt(runDBChangeKey),
 		},
+		{
+			Name: "compact",
+			Help: `Rewrite the database in canonical form with a fresh data key.
+
+Records are sorted by label and normalized, and empty fields are dropped.
+The passphrase is not changed. Use change-key to change the passphrase.`,
+			Run: command.Adapt(runDBCompact),
+		},
 		{
 			Name: "edit",
 			Help: "Edit the full content of the database.",
 			Run:  command.Adapt(runDBEdit),
 		},
+		{
+			Name:  "create-pfile",
+			Usage: "<pfile-path>",
+			Help: `Create an encrypted passphrase file for the database.
+
+The file is encrypted with a key taken from the KEYFISH_PFILE_KEY
+environment variable, which must be set. The passphrase is checked
+against the database before it is written. An encrypted passphrase
+file is read in the same way as a plain one, provided the same key
+is set in the environment.`,
+			Run: command.Adapt(runDBCreatePFile),
+		},
+		{
+			Name: "schema",
+			Help: `Print a JSON Schema for the plaintext database format.
+
+The schema describes the JSON encoding of the database inside the
+encrypted store. Records in this format can be added with "kf import".
+This command does not require a database.`,
+			Run: command.Adapt(runDBSchema),
+		},
 	},
 }
 
@@ -52,7 +82,7 @@ func runDBCreate(env *command.Env, dbPath string) error {
 	if err := kflib.SaveDB(s, dbPath); err != nil {
 		return err
 	}
-	fmt.Fprintf(env, "Created database %q\n", dbPath)
+	config.Infof(env, "Created database %q\n", dbPath)
 	return nil
 }
 
@@ -66,14 +96,41 @@ func runDBChangeKey(env *command.Env) error {
 	if err != nil {
 		return err
 	}
-	s2, err := kfdb.New(newpp, s.DB())
+	s2, err := kflib.ChangePassphrase(s, newpp)
 	if err != nil {
 		return err
 	}
 	if err := config.SaveDB(env, s2); err != nil {
 		return err
 	}
-	fmt.Fprintf(env, "Access key updated for %q\n", config.DBPath(env))
+	config.Infof(env, "Access key updated for %q\n", config.DBPath(env))
+	return nil
+}
+
+// runDBCompact implements the "db compact" subcommand.
+func runDBCompact(env *command.Env) error {
+	s, pp, err := config.LoadDBWithPassphrase(env)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	dbPath := config.DBPath(env)
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+	s2, err := kflib.CompactDB(s, pp)
+	if err != nil {
+		return err
+	}
+	if err := config.SaveDB(env, s2); err != nil {
+		return err
+	}
+	fi2, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+	config.Infof(env, "Compacted %q\n", dbPath)
+	config.Infof(env, "  size: %d bytes before, %d bytes after\n", fi.Size(), fi2.Size())
 	return nil
 }
 
@@ -85,15 +142,49 @@ func runDBEdit(env *command.Env) error {
 	}
 	repl, err := kflib.Edit(env.Context(), s.DB())
 	if errors.Is(err, kflib.ErrNoChange) {
-		fmt.Fprintln(env, "No change")
+		config.Infof(env, "No change\n")
 		return nil
 	} else if err != nil {
 		return err
 	}
+	if errs := kflib.ValidateDB(repl); len(errs) != 0 {
+		return fmt.Errorf("invalid database (edit not applied):\n%w", errors.Join(errs...))
+	}
 	*s.DB() = *repl
 	if err := config.SaveDB(env, s); err != nil {
 		return err
 	}
-	fmt.Fprintf(env, "Edit applied to %q\n", config.DBPath(env))
+	config.Infof(env, "Edit applied to %q\n", config.DBPath(env))
+	return nil
+}
+
+// runDBCreatePFile implements the "db create-pfile" subcommand.
+func runDBCreatePFile(env *command.Env, pfPath string) error {
+	key := os.Getenv(config.PFileKeyEnv)
+	if key == "" {
+		return env.Usagef("you must set %s to create a passphrase file", config.PFileKeyEnv)
+	}
+	dbPath := config.DBPath(env)
+	if dbPath == "" {
+		return env.Usagef("no database path specified (set --db or KEYFISH_DB)")
+	}
+	pp, err := kflib.GetPassphrase("Passphrase: ")
+	if err != nil {
+		return fmt.Errorf("read passphrase: %w", err)
+	}
+	if _, err := kflib.OpenDBWithPassphrase(dbPath, pp); err != nil {
+		return err
+	}
+	if err := kflib.WritePFile(pfPath, pp, key); err != nil {
+		return err
+	}
+	config.Infof(env, "Wrote encrypted passphrase file %q\n", pfPath)
 	return nil
 }
+
+// runDBSchema implements the "db schema" subcommand.
+func runDBSchema(env *command.Env) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(kfdb.JSONSchema())
+}
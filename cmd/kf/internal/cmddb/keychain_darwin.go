@@ -0,0 +1,40 @@
+package cmddb
+
+import (
+	"fmt"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/keyfish/cmd/kf/config"
+	"github.com/creachadair/keyfish/kflib"
+)
+
+func init() {
+	Command.Commands = append(Command.Commands, &command.C{
+		Name: "keychain-store",
+		Help: `Store the database passphrase in the login keychain.
+
+The passphrase is checked against the database before it is stored.
+Use the --db-from-keychain flag to read the passphrase from the keychain.`,
+		Run: command.Adapt(runDBKeychainStore),
+	})
+}
+
+// runDBKeychainStore implements the "db keychain-store" subcommand.
+func runDBKeychainStore(env *command.Env) error {
+	path := config.DBPath(env)
+	if path == "" {
+		return env.Usagef("no database path specified (set --db or KEYFISH_DB)")
+	}
+	pp, err := kflib.GetPassphrase("Passphrase: ")
+	if err != nil {
+		return fmt.Errorf("read passphrase: %w", err)
+	}
+	if _, err := kflib.OpenDBWithPassphrase(path, pp); err != nil {
+		return err
+	}
+	if err := config.StoreKeychainPassphrase(path, pp); err != nil {
+		return err
+	}
+	config.Infof(env, "Stored passphrase for %q in the keychain\n", path)
+	return nil
+}
@@ -3,6 +3,7 @@ package cmddebug
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,9 +11,9 @@ import (
 	"github.com/creachadair/command"
 	"github.com/creachadair/flax"
 	"github.com/creachadair/keyfish/cmd/kf/config"
+	"github.com/creachadair/keyfish/cmd/kf/internal/cmdhashpass"
 	"github.com/creachadair/keyfish/kfdb"
 	"github.com/creachadair/keyfish/kflib"
-	"github.com/creachadair/mds/value"
 	"github.com/creachadair/otp/otpauth"
 )
 
@@ -38,17 +39,7 @@ Use "@" to refer to the path set via the --db flag.`,
 			Help:  "Import a plaintext JSON into a database, replacing its contents.",
 			Run:   command.Adapt(runDebugImport),
 		},
-		{
-			Name:  "hashpass",
-			Usage: "[flags] [salt]@seed",
-			Help: `Generate an HKDF based hashed password.
-
-The seed is the non-secret generator seed. If provided, the salt is
-mixed in to the HKDF as additional context. The user is prompted for
-the HKDF secret. The output is written as a single line to stdout.`,
-			SetFlags: command.Flags(flax.MustBind, &hpFlags),
-			Run:      command.Adapt(runDebugHashpass),
-		},
+		cmdhashpass.Command, // alias for the top-level command
 		{
 			Name:     "totp",
 			Usage:    "[flags] <otp-secret>",
@@ -77,6 +68,10 @@ func runDebugImport(env *command.Env, dbPath, jsonPath string) error {
 	var db kfdb.DB
 	if err := json.Unmarshal(data, &db); err != nil {
 		return fmt.Errorf("parse JSON: %w", err)
+	} else if err := kfdb.Migrate(&db); err != nil {
+		return err
+	} else if errs := kflib.ValidateDB(&db); len(errs) != 0 {
+		return fmt.Errorf("invalid database in %q:\n%w", jsonPath, errors.Join(errs...))
 	}
 	dp := getDBPath(env, dbPath)
 	s, err := kflib.OpenDB(dp)
@@ -87,39 +82,7 @@ func runDebugImport(env *command.Env, dbPath, jsonPath string) error {
 	if err := kflib.SaveDB(s, dp); err != nil {
 		return err
 	}
-	fmt.Fprintf(env, "Imported %q into %q\n", jsonPath, dp)
-	return nil
-}
-
-var hpFlags struct {
-	Length  int  `flag:"n,The length of the password to generate"`
-	NoDigit bool `flag:"no-digits,Omit digits from the generated password"`
-	Symbols bool `flag:"symbols,Include punctuation in the generated password"`
-	Confirm bool `flag:"c,Confirm passphrase"`
-}
-
-// runDebugHashpass implements the "debug hashpass" subcommand.
-func runDebugHashpass(env *command.Env, input string) error {
-	if hpFlags.Length <= 0 {
-		return env.Usagef("the length (-n) must be positive")
-	}
-
-	salt, seed, ok := strings.Cut(input, "@")
-	if !ok {
-		salt, seed = "", input
-	}
-	pp, err := value.Cond(hpFlags.Confirm, kflib.ConfirmPassphrase, kflib.GetPassphrase)("Passphrase: ")
-	if err != nil {
-		return err
-	}
-	cs := kflib.Letters
-	if !hpFlags.NoDigit {
-		cs |= kflib.Digits
-	}
-	if hpFlags.Symbols {
-		cs |= kflib.Symbols
-	}
-	fmt.Println(kflib.HashedChars(hpFlags.Length, cs, pp, seed, salt))
+	config.Infof(env, "Imported %q into %q\n", jsonPath, dp)
 	return nil
 }
 
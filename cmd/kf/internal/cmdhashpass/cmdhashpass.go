@@ -0,0 +1,119 @@
+// Package cmdhashpass implements the "kf hashpass" subcommand.
+package cmdhashpass
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/flax"
+	"github.com/creachadair/keyfish/kflib"
+	"github.com/creachadair/mds/value"
+)
+
+var Command = &command.C{
+	Name:  "hashpass",
+	Usage: "[flags] [salt]@seed",
+	Help: `Generate an HKDF based hashed password.
+
+The seed is the non-secret generator seed. If provided, the salt is
+mixed in to the HKDF as additional context. The user is prompted for
+the HKDF secret. The output is written as a single line to stdout.
+
+To supply the secret without a prompt, use --secret-file to read it from
+a file, or --secret-cmd to read it from the output of a shell command.
+Leading and trailing whitespace are removed. The secret file must not be
+readable by other users.
+
+With --show-entropy, an estimate of the entropy of the generated
+password in bits is printed to stderr.`,
+	SetFlags: command.Flags(flax.MustBind, &hpFlags),
+	Run:      command.Adapt(runHashpass),
+}
+
+var hpFlags struct {
+	Length  int    `flag:"n,The length of the password to generate"`
+	NoDigit bool   `flag:"no-digits,Omit digits from the generated password"`
+	Symbols bool   `flag:"symbols,Include punctuation in the generated password"`
+	SymSet  string `flag:"symbol-set,Use these punctuation symbols (implies --symbols)"`
+	Confirm bool   `flag:"c,Confirm passphrase"`
+	Entropy bool   `flag:"show-entropy,Print the estimated entropy to stderr"`
+	SecFile string `flag:"secret-file,Read the passphrase from this file instead of prompting"`
+	SecCmd  string `flag:"secret-cmd,Read the passphrase from the output of this shell command"`
+}
+
+// runHashpass implements the "hashpass" subcommand.
+func runHashpass(env *command.Env, input string) error {
+	if hpFlags.Length <= 0 {
+		return env.Usagef("the length (-n) must be positive")
+	}
+
+	if hpFlags.SymSet != "" {
+		if err := kflib.CheckSymbols(hpFlags.SymSet); err != nil {
+			return env.Usagef("invalid symbol set: %v", err)
+		}
+	}
+
+	salt, seed, ok := strings.Cut(input, "@")
+	if !ok {
+		salt, seed = "", input
+	}
+	pp, err := hashpassSecret(env)
+	if err != nil {
+		return err
+	}
+	cs := kflib.Letters
+	if !hpFlags.NoDigit {
+		cs |= kflib.Digits
+	}
+	if hpFlags.Symbols || hpFlags.SymSet != "" {
+		cs |= kflib.Symbols
+	}
+	pw, err := kflib.HashedCharsCustom(hpFlags.Length, cs, hpFlags.SymSet, pp, seed, salt)
+	if err != nil {
+		return err
+	}
+	if hpFlags.Entropy {
+		bits, _ := kflib.CharsEntropyCustom(hpFlags.Length, cs, hpFlags.SymSet)
+		fmt.Fprintf(env, "Estimated entropy: %.1f bits\n", bits)
+	}
+	fmt.Println(pw)
+	return nil
+}
+
+// hashpassSecret returns the hashpass passphrase from the --secret-file or
+// --secret-cmd flags if either is set, or otherwise by prompting the user.
+// Leading and trailing whitespace are trimmed from a file or command output.
+func hashpassSecret(env *command.Env) (string, error) {
+	switch {
+	case hpFlags.SecFile != "" && hpFlags.SecCmd != "":
+		return "", env.Usagef("--secret-file and --secret-cmd are mutually exclusive")
+
+	case hpFlags.SecFile != "":
+		fi, err := os.Stat(hpFlags.SecFile)
+		if err != nil {
+			return "", fmt.Errorf("read passphrase: %w", err)
+		} else if fi.Mode().Perm()&0004 != 0 {
+			return "", fmt.Errorf("passphrase file %q is world-readable", hpFlags.SecFile)
+		}
+		data, err := os.ReadFile(hpFlags.SecFile)
+		if err != nil {
+			return "", fmt.Errorf("read passphrase: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+
+	case hpFlags.SecCmd != "":
+		cmd := exec.CommandContext(env.Context(), "sh", "-c", hpFlags.SecCmd)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("read passphrase: %w", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+
+	default:
+		return value.Cond(hpFlags.Confirm, kflib.ConfirmPassphrase, kflib.GetPassphrase)("Passphrase: ")
+	}
+}
@@ -0,0 +1,118 @@
+// Package cmdimport implements the "kf import" subcommand.
+package cmdimport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/flax"
+	"github.com/creachadair/keyfish/cmd/kf/config"
+	"github.com/creachadair/keyfish/kfdb"
+	"github.com/creachadair/keyfish/kflib"
+)
+
+var Command = &command.C{
+	Name:  "import",
+	Usage: "--format=<format> <file>\n--format=otpauth-uri [<uri>...]",
+	Help: `Import records from another password manager or a plaintext file.
+
+The input file is read in the specified --format, one of:
+
+  ` + strings.Join(kflib.CSVFormats(), "\n  ") + `
+  json
+  yaml
+  otpauth-uri
+
+With --format=json or --format=yaml, the input is an array of records in
+the plaintext database format (see "kf db schema"). Records from other
+formats, and records without a label, are assigned a label derived from
+their title. Records whose label is already used in the database, or by an
+earlier record of the input, are skipped. If any record to be added is
+invalid, nothing is imported.
+
+With --format=otpauth-uri, the arguments are otpauth:// URLs for OTP
+configurations. If there are no arguments, URLs are read from stdin, one
+per line. A URL whose issuer matches the label or title of an existing
+record without an OTP config is attached to that record. Otherwise, a
+new record is created, labelled by the issuer (or account) name.`,
+
+	SetFlags: command.Flags(flax.MustBind, &importFlags),
+	Run:      command.Adapt(runImport),
+}
+
+var importFlags struct {
+	Format string `flag:"format,Input file format (required)"`
+}
+
+// runImport implements the "import" subcommand.
+func runImport(env *command.Env, args ...string) error {
+	if importFlags.Format == "" {
+		return env.Usagef("you must specify an input --format")
+	} else if importFlags.Format == "otpauth-uri" {
+		return runImportOTP(env, args)
+	} else if len(args) != 1 {
+		return env.Usagef("you must specify a single input file")
+	}
+	path := args[0]
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var recs []*kfdb.Record
+	switch importFlags.Format {
+	case "json":
+		recs, err = kflib.ReadRecordsJSON(f)
+	case "yaml":
+		recs, err = kflib.ReadRecordsYAML(f)
+	default:
+		recs, err = kflib.ReadCSV(f, importFlags.Format)
+	}
+	if err != nil {
+		return fmt.Errorf("read %q: %w", path, err)
+	}
+
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	added, skipped, err := kflib.ImportRecords(s.DB(), recs)
+	if err != nil {
+		return fmt.Errorf("invalid records in %q:\n%w", path, err)
+	} else if added != 0 {
+		if err := config.SaveDB(env, s); err != nil {
+			return err
+		}
+	}
+	config.Infof(env, "Imported %d records, skipped %d with duplicate labels\n", added, skipped)
+	return nil
+}
+
+// runImportOTP implements the "import" subcommand for otpauth URLs.
+func runImportOTP(env *command.Env, args []string) error {
+	var in io.Reader = os.Stdin
+	if len(args) != 0 {
+		in = strings.NewReader(strings.Join(args, "\n"))
+	}
+	urls, err := kflib.ReadOTPURLs(in)
+	if err != nil {
+		return err
+	}
+
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	created, attached, skipped := kflib.AddOTPs(s.DB(), urls)
+	if created+attached != 0 {
+		if err := config.SaveDB(env, s); err != nil {
+			return err
+		}
+	}
+	config.Infof(env, "Created %d records, attached %d OTP configs, skipped %d with duplicate labels\n",
+		created, attached, skipped)
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package cmdinfo implements the "kf info" subcommand.
+package cmdinfo
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/keyfish/cmd/kf/config"
+	"github.com/creachadair/keyfish/kfdb"
+	"github.com/creachadair/keyfish/kfstore"
+	"github.com/creachadair/mds/value"
+)
+
+var Command = &command.C{
+	Name: "info",
+	Help: `Print a summary of the database.
+
+Open the database and print non-secret metadata about it: its path and
+modification time, format and schema version, which defaults are set,
+and the number of records of various kinds. Secrets are never printed.`,
+
+	Run: command.Adapt(runInfo),
+}
+
+// runInfo implements the "info" subcommand.
+func runInfo(env *command.Env) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	path := config.DBPath(env)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	db := s.DB()
+	var c counts
+	for _, r := range db.Records {
+		c.add(r)
+	}
+	d := value.At(db.Defaults)
+
+	tw := tabwriter.NewWriter(os.Stdout, 4, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Path:\t%s\n", path)
+	fmt.Fprintf(tw, "Modified:\t%s\n", fi.ModTime().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Format:\t%s\n", kfstore.Format)
+	fmt.Fprintf(tw, "Schema version:\t%d\n", db.SchemaVersion)
+	fmt.Fprintf(tw, "Defaults:\t%s\n", value.Cond(db.Defaults != nil, "set", "not set"))
+	fmt.Fprintf(tw, "  Hashpass:\t%s\n", value.Cond(d.Hashpass != nil, "set", "not set"))
+	fmt.Fprintf(tw, "  Web config:\t%s\n", value.Cond(d.Web != nil, "set", "not set"))
+	fmt.Fprintf(tw, "  OTP:\t%s\n", value.Cond(d.OTP != nil, "set", "not set"))
+	fmt.Fprintf(tw, "  Templates:\t%d\n", len(d.Templates))
+	fmt.Fprintf(tw, "Records:\t%d (%d active, %d archived)\n", len(db.Records), c.Active, c.Archived)
+	fmt.Fprintf(tw, "  With password:\t%d\n", c.Password)
+	fmt.Fprintf(tw, "  With hashpass:\t%d\n", c.Hashpass)
+	fmt.Fprintf(tw, "  With OTP:\t%d\n", c.OTP)
+	return tw.Flush()
+}
+
+// counts records the number of records of various kinds.
+type counts struct {
+	Active, Archived        int
+	Password, Hashpass, OTP int
+}
+
+func (c *counts) add(r *kfdb.Record) {
+	if r.Archived {
+		c.Archived++
+	} else {
+		c.Active++
+	}
+	if r.Password != "" {
+		c.Password++
+	}
+	if r.Hashpass != nil {
+		c.Hashpass++
+	}
+	if r.OTP != nil {
+		c.OTP++
+	}
+}
@@ -2,16 +2,25 @@
 package cmdrecord
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/creachadair/command"
 	"github.com/creachadair/flax"
 	"github.com/creachadair/keyfish/cmd/kf/config"
 	"github.com/creachadair/keyfish/kfdb"
 	"github.com/creachadair/keyfish/kflib"
+	"github.com/creachadair/keyfish/wordhash"
+	"github.com/creachadair/mds/value"
+	"github.com/creachadair/otp/otpauth"
 	yaml "gopkg.in/yaml.v3"
 )
 
@@ -21,16 +30,41 @@ var Command = &command.C{
 
 	Commands: []*command.C{
 		{
-			Name:     "add",
-			Usage:    "<label>",
-			Help:     "Add a new record with the specified label.",
+			Name:  "add",
+			Usage: "<label>",
+			Help: `Add a new record with the specified label.
+
+With --template, the tags and details of the named template are added to
+the new record before it is opened in the editor (if --edit is set).
+Templates are stored in the "templates" field of the database defaults;
+use "kf db edit" to define them.
+
+With --email-tag, add an e-mail address generated from the "email" field
+of the database defaults, with the given plus-address tag inserted, e.g.,
+--email-tag=shop for user@example.com gives user+shop@example.com.`,
 			SetFlags: command.Flags(flax.MustBind, &addFlags),
 			Run:      command.Adapt(runRecordAdd),
 		},
 		{
-			Name:     "show",
-			Usage:    "<query>",
-			Help:     "Print the config record for the specified query.",
+			Name:  "clone",
+			Usage: "<query> <new-label>",
+			Help: `Add a new record with the specified label, copied from an existing one.
+
+The hosts, hashpass settings, details, and other fields of the matching
+record are copied. The stored password, OTP configuration, recovery codes,
+and attachments are not. If the record has hashpass settings, the new
+record uses its label as the hashpass seed, so that it does not generate
+the same password as the original.`,
+			Run: command.Adapt(runRecordClone),
+		},
+		{
+			Name:  "show",
+			Usage: "<query>",
+			Help: `Print the config record for the specified query.
+
+With --effective, also print the hashpass settings used to generate a
+password for the record, after merging the record's hashpass config with
+the database defaults. The secret key is never included.`,
 			SetFlags: command.Flags(flax.MustBind, &showFlags),
 			Run:      command.Adapt(runRecordShow),
 		},
@@ -40,6 +74,26 @@ var Command = &command.C{
 			Help:  "Edit the record matching the specified query.",
 			Run:   command.Adapt(runRecordEdit),
 		},
+		{
+			Name:  "set",
+			Usage: "<query> <field>=<value> ...",
+			Help: `Set fields of the record matching the specified query.
+
+Each argument after the query has the form field=value.
+The fields that can be set are:
+
+  title     -- replace the title
+  username  -- replace the username
+  notes     -- replace the notes
+  host      -- add a hostname
+  alias     -- add an alternate hostname
+  addr      -- add an e-mail address
+  detail    -- add or replace a detail, with value "label:text"
+  code      -- add a recovery code
+
+The changes are applied in order, and saved only if all are valid.`,
+			Run: command.Adapt(runRecordSet),
+		},
 		{
 			Name:  "archive",
 			Usage: "<query> ...",
@@ -52,6 +106,89 @@ var Command = &command.C{
 			Help:  "Unarchive the specified records.",
 			Run:   command.Adapt(runRecordArchive),
 		},
+		{
+			Name:  "codes",
+			Usage: "<query>",
+			Help: `List the unused recovery codes of the specified record.
+
+With --all, also list the codes that have been used, marked as such.
+With --use, print the next unused code, and mark it as used.
+
+Use "record set <query> code=<code>" to add recovery codes.`,
+			SetFlags: command.Flags(flax.MustBind, &codesFlags),
+			Run:      command.Adapt(runRecordCodes),
+		},
+		{
+			Name:  "tag",
+			Usage: "<query> <tag> ...",
+			Help: `Add tags to the specified record.
+
+Tags are converted to lower case, and duplicates are ignored.`,
+			Run: command.Adapt(runRecordTag),
+		},
+		{
+			Name:  "untag",
+			Usage: "<query> <tag> ...",
+			Help:  "Remove tags from the specified record.",
+			Run:   command.Adapt(runRecordTag),
+		},
+		{
+			Name:  "attach",
+			Usage: "<query> <file>",
+			Help: fmt.Sprintf(`Attach a file to the specified record.
+
+The attachment is named by the base name of the file, unless --name is set.
+An existing attachment with the same name is replaced. Attachments are
+stored in the database, and may be at most %d bytes.`, kflib.MaxAttachmentSize),
+			SetFlags: command.Flags(flax.MustBind, &attachFlags),
+			Run:      command.Adapt(runRecordAttach),
+		},
+		{
+			Name:  "extract",
+			Usage: "<query> [<name> [<output>]]",
+			Help: `Extract an attachment from the specified record.
+
+The content of the named attachment is written to the output file, which
+must not already exist, or to stdout if no output is given. Without a name,
+list the attachments of the record.`,
+			Run: command.Adapt(runRecordExtract),
+		},
+		{
+			Name:  "fav",
+			Usage: "<query> ...",
+			Help:  "Mark the specified records as favorites.",
+			Run:   command.Adapt(runRecordFavorite),
+		},
+		{
+			Name:  "unfav",
+			Usage: "<query> ...",
+			Help:  "Unmark the specified records as favorites.",
+			Run:   command.Adapt(runRecordFavorite),
+		},
+		{
+			Name:  "check",
+			Usage: "<query> ...",
+			Help: `Check the passwords of the specified records against known breaches.
+
+Each password is checked with the HaveIBeenPwned password range API.
+This requires network access. Only the first 5 hex digits of the SHA-1
+digest of each password are sent; the password itself and its full
+digest are never transmitted.`,
+			Run: command.Adapt(runRecordCheck),
+		},
+		{
+			Name:  "verify",
+			Usage: "<query>",
+			Help: `Check a password against the verifier of the specified record.
+
+A verifier is a bcrypt hash of a password that is not itself stored in the
+database, for secrets you want to practice or confirm without keeping them.
+With --set, prompt for a new password and store its verifier, replacing any
+existing verifier. Otherwise, prompt for a password and report whether it
+matches the verifier.`,
+			SetFlags: command.Flags(flax.MustBind, &verifyFlags),
+			Run:      command.Adapt(runRecordVerify),
+		},
 	},
 }
 
@@ -59,17 +196,60 @@ var addFlags struct {
 	Title    string `flag:"title,Specify the title of the record"`
 	Username string `flag:"username,Specify the username for the record"`
 	EMail    string `flag:"email,Specify an e-mail for the record"`
+	EMailTag string `flag:"email-tag,Add the default e-mail address with this plus-address tag"`
 	Host     string `flag:"host,Specify a hostname for the record"`
+	OTP      string `flag:"otp,Specify an OTP secret (base32) or otpauth:// URL"`
+	Password string `flag:"password,Specify a password for the record"`
+	Generate genLen `flag:"generate,Generate a random password (--generate=n for length n)"`
+	Symbols  bool   `flag:"symbols,Include punctuation in a --generate password"`
+	NoDigit  bool   `flag:"no-digits,Omit digits from a --generate password"`
 	Edit     bool   `flag:"edit,Open the new record in an editor"`
+	Template string `flag:"template,Pre-populate the record from the named template"`
+}
+
+// genLen is a flag.Value for a password length that may also be set as a
+// boolean flag, in which case the default length is used.
+type genLen int
+
+const defaultGenLen = 16
+
+func (g genLen) String() string { return strconv.Itoa(int(g)) }
+
+func (g *genLen) IsBoolFlag() bool { return true }
+
+func (g *genLen) Set(s string) error {
+	if v, err := strconv.ParseBool(s); err == nil {
+		*g = genLen(value.Cond(v, defaultGenLen, 0))
+		return nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid length %q", s)
+	} else if v <= 0 {
+		return errors.New("length must be positive")
+	}
+	*g = genLen(v)
+	return nil
 }
 
 // runRecordAdd implements the "record add" subcommand.
 func runRecordAdd(env *command.Env, label string) error {
+	if addFlags.Password != "" && addFlags.Generate != 0 {
+		return env.Usagef("--password and --generate are mutually exclusive")
+	}
 	s, err := config.LoadDB(env)
 	if err != nil {
 		return err
 	}
 	db := s.DB()
+	var otpURL *otpauth.URL
+	if addFlags.OTP != "" {
+		u, err := kflib.ParseOTP(db, addFlags.OTP, label, label)
+		if err != nil {
+			return err
+		}
+		otpURL = u
+	}
 	if r, err := kflib.FindRecord(db, label, true); err == nil && r.Record.Label == label {
 		return fmt.Errorf("label %q already exists", label)
 	}
@@ -78,13 +258,38 @@ func runRecordAdd(env *command.Env, label string) error {
 		Label:    label,
 		Title:    addFlags.Title,
 		Username: addFlags.Username,
+		OTP:      otpURL,
+	}
+	kflib.SetPassword(nr, addFlags.Password, time.Now())
+	if addFlags.Generate != 0 {
+		cs := kflib.Letters
+		if !addFlags.NoDigit {
+			cs |= kflib.Digits
+		}
+		if addFlags.Symbols {
+			cs |= kflib.Symbols
+		}
+		kflib.SetPassword(nr, kflib.RandomChars(int(addFlags.Generate), cs), time.Now())
+		config.Infof(env, "Generated password %s\n", wordhash.New(nr.Password))
 	}
 	if addFlags.EMail != "" {
 		nr.Addrs = append(nr.Addrs, addFlags.EMail)
 	}
+	if addFlags.EMailTag != "" {
+		addr, err := kflib.TaggedAddress(db, addFlags.EMailTag)
+		if err != nil {
+			return err
+		}
+		nr.Addrs = append(nr.Addrs, addr)
+	}
 	if addFlags.Host != "" {
 		nr.Hosts = append(nr.Hosts, addFlags.Host)
 	}
+	if addFlags.Template != "" {
+		if err := kflib.ApplyTemplate(db, nr, addFlags.Template); err != nil {
+			return err
+		}
+	}
 	if addFlags.Edit {
 		nr, err = kflib.Edit(env.Context(), nr)
 		if err != nil && !errors.Is(err, kflib.ErrNoChange) {
@@ -95,13 +300,40 @@ func runRecordAdd(env *command.Env, label string) error {
 	if err := config.SaveDB(env, s); err != nil {
 		return err
 	}
-	fmt.Fprintf(env, "Created new record %q\n", label)
+	config.Infof(env, "Created new record %q\n", label)
 	return nil
 }
 
 var showFlags struct {
 	All  bool `flag:"a,Show all fields including secrets"`
 	YAML bool `flag:"yaml,Show value as YAML instead of JSON"`
+	Eff  bool `flag:"effective,Show the effective hashpass settings"`
+}
+
+// runRecordClone implements the "record clone" subcommand.
+func runRecordClone(env *command.Env, query, label string) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	db := s.DB()
+	res, err := kflib.FindRecordMin(db, query, true, kflib.MatchSubstring)
+	if err != nil {
+		return err
+	}
+	if r, err := kflib.FindRecord(db, label, true); err == nil && r.Record.Label == label {
+		return fmt.Errorf("label %q already exists", label)
+	}
+	nr, err := kflib.CloneRecord(res.Record, label)
+	if err != nil {
+		return err
+	}
+	db.Records = append(db.Records, nr)
+	if err := config.SaveDB(env, s); err != nil {
+		return err
+	}
+	config.Infof(env, "Created new record %q from %q\n", label, res.Record.Label)
+	return nil
 }
 
 // runRecordShow implements the "record show" subcommand.
@@ -115,10 +347,24 @@ func runRecordShow(env *command.Env, query string) error {
 		return err
 	}
 	rec := res.Record
+
+	// Resolve the hashpass settings before redacting the secret key, which
+	// the resolution requires.
+	var hps *kflib.HashpassSettings
+	var hpErr string
+	if showFlags.Eff {
+		hps, err = kflib.EffectiveHashpass(s.DB(), rec, res.Tag)
+		if err != nil {
+			hpErr = err.Error()
+		}
+	}
 	if !showFlags.All {
 		if rec.Password != "" {
 			rec.Password = "(hidden)"
 		}
+		if rec.PasswordHash != "" {
+			rec.PasswordHash = "(hidden)"
+		}
 		if rec.Hashpass != nil && rec.Hashpass.SecretKey != "" {
 			rec.Hashpass.SecretKey = "(hidden)"
 		}
@@ -131,6 +377,12 @@ func runRecordShow(env *command.Env, query string) error {
 				d.Value = "(hidden)"
 			}
 		}
+		for _, c := range rec.RecoveryCodes {
+			c.Code = "(hidden)"
+		}
+		for _, a := range rec.Attachments {
+			a.Content = nil
+		}
 	}
 
 	var encode func(any) error
@@ -144,13 +396,17 @@ func runRecordShow(env *command.Env, query string) error {
 		encode = enc.Encode
 	}
 	encode(struct {
-		Q string       `json:"query" yaml:"query"`
-		I int          `json:"index" yaml:"index"`
-		R *kfdb.Record `json:"record" yaml:"record"`
+		Q string                  `json:"query" yaml:"query"`
+		I int                     `json:"index" yaml:"index"`
+		R *kfdb.Record            `json:"record" yaml:"record"`
+		H *kflib.HashpassSettings `json:"hashpass,omitempty" yaml:"hashpass,omitempty"`
+		E string                  `json:"hashpassError,omitempty" yaml:"hashpass-error,omitempty"`
 	}{
 		Q: query,
 		I: res.Index,
 		R: rec,
+		H: hps,
+		E: hpErr,
 	})
 	return nil
 }
@@ -161,22 +417,60 @@ func runRecordEdit(env *command.Env, query string) error {
 	if err != nil {
 		return err
 	}
-	res, err := kflib.FindRecord(s.DB(), query, true)
+	res, err := kflib.FindRecordMin(s.DB(), query, true, kflib.MatchSubstring)
 	if err != nil {
 		return err
 	}
 	repl, err := kflib.Edit(env.Context(), res.Record)
 	if errors.Is(err, kflib.ErrNoChange) {
-		fmt.Fprintln(env, "No change")
+		config.Infof(env, "No change\n")
 		return nil
 	} else if err != nil {
 		return err
 	}
+	if repl.Password != res.Record.Password && value.At(repl.PasswordSetAt).Equal(value.At(res.Record.PasswordSetAt)) {
+		kflib.SetPassword(repl, repl.Password, time.Now()) // the password changed
+	}
 	s.DB().Records[res.Index] = repl
 	if err := config.SaveDB(env, s); err != nil {
 		return err
 	}
-	fmt.Fprintf(env, "Record edit applied to %q\n", config.DBPath(env))
+	config.Infof(env, "Record edit applied to %q\n", config.DBPath(env))
+	return nil
+}
+
+// runRecordSet implements the "record set" subcommand.
+func runRecordSet(env *command.Env, query string, fields ...string) error {
+	if len(fields) == 0 {
+		return env.Usagef("at least one field=value is required")
+	}
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := kflib.FindRecordMin(s.DB(), query, true, kflib.MatchSubstring)
+	if err != nil {
+		return err
+	}
+	var changes []string
+	for _, arg := range fields {
+		field, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return env.Usagef("invalid argument %q, want field=value", arg)
+		}
+		msg, err := kflib.SetField(res.Record, field, value)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, msg)
+	}
+	if err := config.SaveDB(env, s); err != nil {
+		return err
+	}
+	config.Infof(env, "Updated record %q:\n", res.Record.Label)
+	for _, msg := range changes {
+		config.Infof(env, "  %s\n", msg)
+	}
 	return nil
 }
 
@@ -194,7 +488,7 @@ func runRecordArchive(env *command.Env, queries ...string) error {
 	db := s.DB()
 
 	for _, query := range queries {
-		res, err := kflib.FindRecord(db, query, !doArchive)
+		res, err := kflib.FindRecordMin(db, query, !doArchive, kflib.MatchSubstring)
 		if err != nil {
 			return err
 		} else if res.Record.Archived == doArchive {
@@ -204,3 +498,260 @@ func runRecordArchive(env *command.Env, queries ...string) error {
 	}
 	return config.SaveDB(env, s)
 }
+
+var codesFlags struct {
+	All bool `flag:"all,List used codes as well as unused ones"`
+	Use bool `flag:"use,Print the next unused code and mark it used"`
+}
+
+// runRecordCodes implements the "record codes" subcommand.
+func runRecordCodes(env *command.Env, query string) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := kflib.FindRecordMin(s.DB(), query, true, value.Cond(codesFlags.Use, kflib.MatchSubstring, kflib.MatchFuzzy))
+	if err != nil {
+		return err
+	}
+	codes := res.Record.RecoveryCodes
+	if codesFlags.Use {
+		i := slices.IndexFunc(codes, func(c *kfdb.RecoveryCode) bool { return !c.Used })
+		if i < 0 {
+			return fmt.Errorf("record %q has no unused recovery codes", res.Record.Label)
+		}
+		codes[i].Used = true
+		if err := config.SaveDB(env, s); err != nil {
+			return err
+		}
+		fmt.Println(codes[i].Code)
+		config.Infof(env, "%d recovery codes remaining\n", res.Record.RemainingCodes())
+		return nil
+	}
+	for _, c := range codes {
+		if !c.Used {
+			fmt.Println(c.Code)
+		} else if codesFlags.All {
+			fmt.Println(c.Code, "(used)")
+		}
+	}
+	config.Infof(env, "%d of %d recovery codes remaining\n", res.Record.RemainingCodes(), len(codes))
+	return nil
+}
+
+// runRecordTag implements the "tag" and "untag" subcommands.
+func runRecordTag(env *command.Env, query string, tags ...string) error {
+	if len(tags) == 0 {
+		return env.Usagef("at least one tag is required")
+	}
+	doTag := env.Command.Name == "tag"
+
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := kflib.FindRecordMin(s.DB(), query, true, kflib.MatchSubstring)
+	if err != nil {
+		return err
+	}
+	rec := res.Record
+	before := slices.Clone(rec.Tags)
+	if doTag {
+		rec.Tags = append(rec.Tags, tags...)
+		rec.Normalize()
+	} else {
+		rec.Normalize()
+		for _, t := range tags {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if i := slices.Index(rec.Tags, t); i >= 0 {
+				rec.Tags = slices.Delete(rec.Tags, i, i+1)
+			} else {
+				return fmt.Errorf("record %q does not have tag %q", rec.Label, t)
+			}
+		}
+	}
+	if slices.Equal(before, rec.Tags) {
+		config.Infof(env, "No change\n")
+		return nil
+	}
+	if err := config.SaveDB(env, s); err != nil {
+		return err
+	}
+	config.Infof(env, "Tags for record %q: %s\n", rec.Label, strings.Join(rec.Tags, ", "))
+	return nil
+}
+
+var attachFlags struct {
+	Name string `flag:"name,Name of the attachment (default: base name of file)"`
+}
+
+// runRecordAttach implements the "record attach" subcommand.
+func runRecordAttach(env *command.Env, query, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := kflib.FindRecordMin(s.DB(), query, true, kflib.MatchSubstring)
+	if err != nil {
+		return err
+	}
+	name := cmp.Or(attachFlags.Name, filepath.Base(path))
+	replaced, err := kflib.Attach(res.Record, name, data)
+	if err != nil {
+		return err
+	}
+	if err := config.SaveDB(env, s); err != nil {
+		return err
+	}
+	config.Infof(env, "%s attachment %q (%d bytes) on record %q\n",
+		value.Cond(replaced, "Replaced", "Added"), name, len(data), res.Record.Label)
+	return nil
+}
+
+// runRecordExtract implements the "record extract" subcommand.
+func runRecordExtract(env *command.Env, query string, rest ...string) error {
+	if len(rest) > 2 {
+		return env.Usagef("extra arguments after output: %q", rest[2:])
+	}
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := kflib.FindRecord(s.DB(), query, true)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		for _, a := range res.Record.Attachments {
+			fmt.Printf("%s\t%s\t%d\n", a.Name, a.Type, len(a.Content))
+		}
+		return nil
+	}
+	att := res.Record.FindAttachment(rest[0])
+	if att == nil {
+		return fmt.Errorf("record %q has no attachment %q", res.Record.Label, rest[0])
+	}
+	if len(rest) == 1 {
+		_, err := os.Stdout.Write(att.Content)
+		return err
+	}
+	f, err := os.OpenFile(rest[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(att.Content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	config.Infof(env, "Wrote %d bytes to %q\n", len(att.Content), rest[1])
+	return nil
+}
+
+// runRecordFavorite implements the "fav" and "unfav" subcommands.
+func runRecordFavorite(env *command.Env, queries ...string) error {
+	if len(queries) == 0 {
+		return env.Usagef("at least one query is required")
+	}
+	doFav := env.Command.Name == "fav"
+
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	db := s.DB()
+
+	for _, query := range queries {
+		res, err := kflib.FindRecordMin(db, query, true, kflib.MatchSubstring)
+		if err != nil {
+			return err
+		} else if res.Record.Favorite == doFav {
+			return fmt.Errorf("record %q is already %s", res.Record.Label,
+				value.Cond(doFav, "a favorite", "not a favorite"))
+		}
+		res.Record.Favorite = doFav
+	}
+	return config.SaveDB(env, s)
+}
+
+// runRecordCheck implements the "record check" subcommand.
+func runRecordCheck(env *command.Env, query string, more ...string) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	db := s.DB()
+	var nfound int
+	for _, q := range append([]string{query}, more...) {
+		res, err := kflib.FindRecord(db, q, true)
+		if err != nil {
+			return err
+		}
+		pw := res.Record.Password
+		if pw == "" {
+			pw, err = kflib.GenerateHashpass(db, res.Record, res.Tag)
+			if err != nil {
+				fmt.Printf("%s: no password\n", res.Record.Label)
+				continue
+			}
+		}
+		n, err := kflib.CheckPwnedPassword(env.Context(), pw)
+		if err != nil {
+			return fmt.Errorf("record %q: %w", res.Record.Label, err)
+		} else if n == 0 {
+			fmt.Printf("%s: ok\n", res.Record.Label)
+		} else {
+			fmt.Printf("%s: seen %d times in breach data\n", res.Record.Label, n)
+			nfound++
+		}
+	}
+	if nfound != 0 {
+		return fmt.Errorf("%d passwords seen in breach data", nfound)
+	}
+	return nil
+}
+
+var verifyFlags struct {
+	Set bool `flag:"set,Set the password verifier of the record"`
+}
+
+// runRecordVerify implements the "record verify" subcommand.
+func runRecordVerify(env *command.Env, query string) error {
+	s, err := config.LoadDB(env)
+	if err != nil {
+		return err
+	}
+	res, err := kflib.FindRecordMin(s.DB(), query, true, value.Cond(verifyFlags.Set, kflib.MatchSubstring, kflib.MatchFuzzy))
+	if err != nil {
+		return err
+	}
+	if verifyFlags.Set {
+		pw, err := kflib.ConfirmPassphrase("Password: ")
+		if err != nil {
+			return err
+		}
+		if err := kflib.SetVerifier(res.Record, pw); err != nil {
+			return err
+		}
+		return config.SaveDB(env, s)
+	}
+
+	pw, err := kflib.GetPassphrase("Password: ")
+	if err != nil {
+		return err
+	}
+	ok, err := kflib.CheckVerifier(res.Record, pw)
+	if err != nil {
+		return err
+	} else if !ok {
+		return errors.New("password does not match")
+	}
+	fmt.Println("Password matches")
+	return nil
+}
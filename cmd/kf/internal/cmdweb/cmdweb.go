@@ -8,7 +8,9 @@ import (
 	"errors"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os/signal"
 	"strings"
@@ -33,8 +35,20 @@ var serverFlags struct {
 	Addr     string `flag:"addr,Service address (host:port)"`
 	AutoLock bool   `flag:"autolock,Automatically lock the UI when idle"`
 	Expert   bool   `flag:"expert,PRIVATE:Enable expert UI"`
+	LogReq   bool   `flag:"log-requests,Log a summary of each request (redacted)"`
+	Allow    cidrs  `flag:"allow-cidr,Allow access only from this CIDR (repeatable)"`
+	Proxy    bool   `flag:"trust-proxy,Use X-Forwarded-For to check --allow-cidr"`
+	Reveal   string `flag:"reveal,default=auto,Allow revealing secrets (auto, true, false)"`
+	PIN      bool   `flag:"reveal-pin,Require the lock PIN to reveal secrets"`
 }
 
+// cidrs is a flag.Value that accumulates a list of CIDR strings.
+type cidrs []string
+
+func (c *cidrs) String() string { return strings.Join(*c, ",") }
+
+func (c *cidrs) Set(s string) error { *c = append(*c, s); return nil }
+
 func runServer(env *command.Env) error {
 	if serverFlags.Addr == "" {
 		return env.Usagef("you must provide a service --addr")
@@ -47,10 +61,36 @@ func runServer(env *command.Env) error {
 	webConfig := value.At(dbDefaults.Web)
 	ui := &UI{
 		Store:       w.Store,
+		LoadError:   w.LoadError,
 		Static:      staticFS,
 		Templates:   ui,
 		LockTimeout: cmp.Or(webConfig.LockTimeout.Get(), 2*time.Minute),
 		Expert:      serverFlags.Expert,
+		Save:        w.Save,
+	}
+	switch serverFlags.Reveal {
+	case "auto":
+		// Allow secrets to be revealed only if the server is bound to a
+		// loopback address.
+		ui.NoReveal = !isLoopback(serverFlags.Addr)
+	case "true", "false":
+		ui.NoReveal = serverFlags.Reveal == "false"
+	default:
+		return env.Usagef("invalid --reveal value %q", serverFlags.Reveal)
+	}
+	if ui.NoReveal {
+		log.Printf("Revealing OTP secrets and hidden details is disabled")
+	}
+	if len(serverFlags.Allow) != 0 {
+		hf, err := NewHostFilter(serverFlags.Allow...)
+		if err != nil {
+			return env.Usagef("invalid --allow-cidr: %v", err)
+		}
+		ui.AllowHosts = hf
+		ui.TrustProxy = serverFlags.Proxy
+	}
+	if serverFlags.LogReq {
+		ui.Logf = log.Printf
 	}
 	if serverFlags.AutoLock {
 		if webConfig.LockPIN == "" {
@@ -59,6 +99,13 @@ func runServer(env *command.Env) error {
 		ui.Locked = true
 		ui.LockPIN = webConfig.LockPIN
 	}
+	if serverFlags.PIN {
+		if webConfig.LockPIN == "" {
+			return env.Usagef("no lock PIN is defined for --reveal-pin")
+		}
+		ui.LockPIN = webConfig.LockPIN
+		ui.RevealPIN = true
+	}
 	srv := &http.Server{
 		Addr:    serverFlags.Addr,
 		Handler: ui.ServeMux(),
@@ -81,6 +128,18 @@ func runServer(env *command.Env) error {
 	return srv.Shutdown(context.Background())
 }
 
+// isLoopback reports whether addr (host:port) refers to a loopback address.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	} else if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
 // To update the HTMX version, edit the URL here.
 //go:generate curl -sL -o static/htmx.min.js https://unpkg.com/htmx.org@v2.0.3/dist/htmx.min.js
 
@@ -101,6 +160,7 @@ var ui = template.Must(template.New("ui").Funcs(map[string]any{
 	"formatText": func(s string) any {
 		return template.HTML(strings.ReplaceAll(template.HTMLEscapeString(s), "\n", "<br />\n"))
 	},
+	"recordID": recordID,
 	"toURL": func(s string) string {
 		u, err := url.Parse(s)
 		if err != nil || u.Scheme == "" {
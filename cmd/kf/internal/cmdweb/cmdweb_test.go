@@ -0,0 +1,723 @@
+package cmdweb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/keyfish/kfdb"
+	"github.com/creachadair/keyfish/kflib"
+	"github.com/creachadair/mds/value"
+	"github.com/creachadair/otp/otpauth"
+)
+
+// newTestUI returns a UI serving a new store containing db, using the
+// built-in templates. The caller may set other fields of the UI before use.
+func newTestUI(t *testing.T, db *kfdb.DB) *UI {
+	t.Helper()
+	st, err := kfdb.New("test passphrase", db)
+	if err != nil {
+		t.Fatalf("Create store: %v", err)
+	}
+	return &UI{Store: func() *kfdb.Store { return st }, Templates: ui}
+}
+
+// serve sends a request with the given method and path to h, and returns the
+// recorded response. The header arguments are name/value pairs; headers with
+// empty values are not set.
+func serve(h http.Handler, method, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		if header[i+1] != "" {
+			req.Header.Set(header[i], header[i+1])
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	// The UI is locked and has no store: The status endpoints must not need
+	// either of those.
+	s := &UI{Locked: true, LockPIN: "1234"}
+	srv := httptest.NewServer(s.ServeMux())
+	defer srv.Close()
+
+	for _, path := range []string{"/healthz", "/version"} {
+		rsp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Get %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("Get %s: got status %d, want %d", path, rsp.StatusCode, http.StatusOK)
+		}
+		if strings.TrimSpace(string(body)) == "" {
+			t.Errorf("Get %s: empty response body", path)
+		}
+		t.Logf("Get %s: %s", path, body)
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	var logs []string
+	s := &UI{
+		Locked:  true,
+		LockPIN: "1234",
+		Logf: func(msg string, args ...any) {
+			logs = append(logs, fmt.Sprintf(msg, args...))
+		},
+	}
+	srv := httptest.NewServer(s.ServeMux())
+	defer srv.Close()
+
+	for _, path := range []string{"/healthz", "/password/5?tag=secret", "/detail/3/1"} {
+		rsp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Get %s: %v", path, err)
+		}
+		rsp.Body.Close()
+	}
+
+	want := []string{
+		"GET /healthz from * 200",
+		"GET /password/* from * 403", // locked
+		"GET /detail/*/* from * 403", // locked
+	}
+	if len(logs) != len(want) {
+		t.Fatalf("Got %d log lines, want %d:\n%s", len(logs), len(want), strings.Join(logs, "\n"))
+	}
+	for i, log := range logs {
+		t.Logf("Log: %s", log)
+		method, rest, _ := strings.Cut(want[i], " from * ")
+		if !strings.HasPrefix(log, method+" from ") || !strings.Contains(log, ": "+rest+" ") {
+			t.Errorf("Log %d: got %q, want %q", i+1, log, want[i])
+		}
+		if strings.Contains(log, "secret") || strings.Contains(log, "/5") {
+			t.Errorf("Log %d: %q was not redacted", i+1, log)
+		}
+	}
+}
+
+func TestHostFilter(t *testing.T) {
+	hf, err := NewHostFilter("127.0.0.1", "10.0.0.0/8", "fd7a:115c:a1e0::/48")
+	if err != nil {
+		t.Fatalf("NewHostFilter: unexpected error: %v", err)
+	}
+	if _, err := NewHostFilter("10.0.0.0/33"); err == nil {
+		t.Error("NewHostFilter: got nil, want error for invalid CIDR")
+	}
+
+	s := &UI{LockPIN: "1234", AllowHosts: hf}
+	mux := s.ServeMux()
+
+	tests := []struct {
+		remote, xff string
+		trust       bool
+		want        int
+	}{
+		{"127.0.0.1:5000", "", false, http.StatusFound},
+		{"127.0.0.2:5000", "", false, http.StatusForbidden},
+		{"10.1.2.3:5000", "", false, http.StatusFound},
+		{"192.168.0.1:5000", "", false, http.StatusForbidden},
+		{"[fd7a:115c:a1e0::1]:5000", "", false, http.StatusFound},
+		{"[fd7a:115c:a1e1::1]:5000", "", false, http.StatusForbidden},
+		{"[::1]:5000", "", false, http.StatusForbidden},
+		{"[::ffff:10.0.0.1]:5000", "", false, http.StatusFound},
+
+		// X-Forwarded-For is only used when the proxy is trusted.
+		{"127.0.0.1:5000", "192.168.0.1", false, http.StatusFound},
+		{"127.0.0.1:5000", "192.168.0.1", true, http.StatusForbidden},
+		{"192.168.0.1:5000", "10.0.0.1", true, http.StatusFound},
+		{"192.168.0.1:5000", "10.0.0.1, 192.168.0.5", true, http.StatusForbidden},
+		{"192.168.0.1:5000", "192.168.0.5, fd7a:115c:a1e0::2", true, http.StatusFound},
+	}
+	for _, tc := range tests {
+		s.TrustProxy = tc.trust
+		req := httptest.NewRequest("GET", "/lock", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if got := rec.Code; got != tc.want {
+			t.Errorf("Remote %q, XFF %q, trust=%v: got status %d, want %d",
+				tc.remote, tc.xff, tc.trust, got, tc.want)
+		}
+	}
+}
+
+func TestNoReveal(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{{
+			Label: "test",
+			OTP: &otpauth.URL{
+				Type:      "totp",
+				Account:   "test",
+				RawSecret: "MFRGGZDFMZTWQ2LK",
+				Digits:    6,
+				Period:    30,
+			},
+			Details: []*kfdb.Detail{{Label: "pin", Value: "5678", Hidden: true}},
+		}},
+	})
+
+	tests := []struct {
+		path     string
+		noReveal bool
+		want     int
+	}{
+		{"/totp/0", false, http.StatusOK},
+		{"/totp/0?key=1", false, http.StatusOK},
+		{"/detail/0/0", false, http.StatusOK},
+
+		{"/totp/0", true, http.StatusOK},
+		{"/totp/0?key=1", true, http.StatusForbidden},
+		{"/detail/0/0", true, http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		s.NoReveal = tc.noReveal
+		rec := serve(s.ServeMux(), "GET", tc.path)
+		if got := rec.Code; got != tc.want {
+			t.Errorf("Get %s (noReveal=%v): got status %d, want %d", tc.path, tc.noReveal, got, tc.want)
+		}
+		if tc.want == http.StatusForbidden && strings.Contains(rec.Body.String(), "MFRGGZDFMZTWQ2LK") {
+			t.Errorf("Get %s (noReveal=%v): response contains the secret", tc.path, tc.noReveal)
+		}
+	}
+
+	// Requests refused because revealing is disabled are not counted.
+	if s.reveals != 4 {
+		t.Errorf("Reveal count: got %d, want 4", s.reveals)
+	}
+	// The record view must not embed hidden values when revealing is disabled.
+	for _, noReveal := range []bool{false, true} {
+		s.NoReveal = noReveal
+		rec := serve(s.ServeMux(), "GET", "/view/0")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Get /view/0 (noReveal=%v): got status %d, want %d", noReveal, rec.Code, http.StatusOK)
+		}
+		if got := strings.Contains(rec.Body.String(), "5678"); got == noReveal {
+			t.Errorf("Get /view/0 (noReveal=%v): body contains hidden value is %v, want %v", noReveal, got, !noReveal)
+		}
+	}
+}
+
+func TestDetailOTP(t *testing.T) {
+	// The detail URL omits the period and digits, so the defaults apply.
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{{
+			Label:   "test",
+			Details: []*kfdb.Detail{{Label: "2fa", Value: "otpauth://totp/test?secret=MFRGGZDFMZTWQ2LK"}},
+		}},
+	})
+
+	rec := serve(s.ServeMux(), "GET", "/totp/0?detail=0", "Accept", "application/json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Get detail OTP: got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var rsp jsonValue
+	if err := json.Unmarshal(rec.Body.Bytes(), &rsp); err != nil {
+		t.Fatalf("Decode response: %v", err)
+	}
+	u := &otpauth.URL{Type: "totp", RawSecret: "MFRGGZDFMZTWQ2LK", Digits: 6, Period: 30}
+	if len(rsp.Value) != 6 {
+		t.Errorf("Detail OTP: got %q, want 6 digits", rsp.Value)
+	} else if _, ok, err := kflib.MatchOTP(nil, u, rsp.Value, 1); err != nil || !ok {
+		t.Errorf("Detail OTP %q: does not match a 30-second code (%v)", rsp.Value, err)
+	}
+}
+
+func TestOTPErrors(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{
+			{Label: "empty", OTP: &otpauth.URL{Type: "totp", Account: "empty"}},
+			{Label: "corrupt", OTP: &otpauth.URL{Type: "totp", Account: "corrupt", RawSecret: "not*base32"}},
+		},
+	})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/totp/0", http.StatusNotFound},
+		{"/totp/1", http.StatusUnprocessableEntity},
+		{"/sequence/0", http.StatusNotFound},
+		{"/sequence/1", http.StatusUnprocessableEntity},
+		{"/field/1?name=otp", http.StatusUnprocessableEntity},
+	}
+	for _, tc := range tests {
+		rec := serve(s.ServeMux(), "GET", tc.path)
+		if rec.Code != tc.want {
+			t.Errorf("Get %s: got status %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestJSONResponses(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{{Label: "test", Password: "hunter2"}},
+	})
+	mux := s.ServeMux()
+
+	tests := []struct {
+		path, accept string
+		code         int
+		want         string
+	}{
+		{"/password/0", "application/json", http.StatusOK, `{"value":"hunter2"}`},
+		{"/password/5", "text/html, application/json;q=0.9", http.StatusNotFound,
+			`{"error":"no such record ID","code":404}`},
+		{"/totp/0", "application/json", http.StatusNotFound,
+			`{"error":"no OTP configuration","code":404}`},
+		{"/password/5", "", http.StatusNotFound, "no such record ID"},
+	}
+	for _, tc := range tests {
+		rec := serve(mux, "GET", tc.path, "Accept", tc.accept)
+		if rec.Code != tc.code {
+			t.Errorf("Get %s: got status %d, want %d", tc.path, rec.Code, tc.code)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != tc.want {
+			t.Errorf("Get %s: got body %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRevealPIN(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{{
+			Label:    "test",
+			Password: "hunter2",
+			Details:  []*kfdb.Detail{{Label: "pin", Value: "5678", Hidden: true}},
+		}},
+	})
+	s.LockPIN, s.RevealPIN = "1234", true
+	mux := s.ServeMux()
+
+	tests := []struct {
+		path, prompt string
+		want         int
+	}{
+		{"/password/0", "", http.StatusForbidden},
+		{"/password/0", "9999", http.StatusForbidden},
+		{"/password/0?lockpin=9999", "", http.StatusForbidden},
+		{"/detail/0/0", "", http.StatusForbidden},
+
+		{"/password/0", "1234", http.StatusOK},
+		{"/password/0?lockpin=1234", "", http.StatusOK},
+		{"/detail/0/0", "1234", http.StatusOK},
+	}
+	for _, tc := range tests {
+		rec := serve(mux, "GET", tc.path, "HX-Prompt", tc.prompt)
+		if got := rec.Code; got != tc.want {
+			t.Errorf("Get %s (prompt=%q): got status %d, want %d", tc.path, tc.prompt, got, tc.want)
+		}
+		if tc.want == http.StatusForbidden && strings.Contains(rec.Body.String(), "hunter2") {
+			t.Errorf("Get %s (prompt=%q): response contains the password", tc.path, tc.prompt)
+		}
+	}
+
+	// Only the successful reveals should be counted.
+	if s.reveals != 3 {
+		t.Errorf("Reveal count: got %d, want 3", s.reveals)
+	}
+
+	// With a reveal PIN, the view neither embeds hidden values nor offers to
+	// copy them on click.
+	rec := serve(mux, "GET", "/view/0")
+	if body := rec.Body.String(); strings.Contains(body, "5678") || strings.Contains(body, "copyclick") {
+		t.Errorf("Get /view/0: body offers to copy a hidden value:\n%s", body)
+	}
+}
+
+func TestRecordUID(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{
+			{Label: "old", Password: "hunter2"},
+			{Label: "new", UID: "8badf00d", Password: "swordfish"},
+		},
+	})
+	mux := s.ServeMux()
+
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/password/8badf00d", http.StatusOK, `{"value":"swordfish"}`},
+		{"/password/1", http.StatusOK, `{"value":"swordfish"}`},
+		{"/password/0", http.StatusOK, `{"value":"hunter2"}`},
+		{"/password/deadbeef", http.StatusNotFound, `{"error":"no such record ID","code":404}`},
+	}
+	for _, tc := range tests {
+		rec := serve(mux, "GET", tc.path, "Accept", "application/json")
+		if rec.Code != tc.code {
+			t.Errorf("Get %s: got status %d, want %d", tc.path, rec.Code, tc.code)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != tc.want {
+			t.Errorf("Get %s: got body %q, want %q", tc.path, got, tc.want)
+		}
+	}
+
+	// Links generated for a record with a UID should use the UID, and links
+	// for a record without one should fall back to its index.
+	for path, want := range map[string]string{
+		"/view/1":       `/password/8badf00d`,
+		"/view/0":       `/password/0`,
+		"/search?q=new": `/view/8badf00d`,
+	} {
+		rec := serve(mux, "GET", path)
+		if body := rec.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("Get %s: body does not contain %q:\n%s", path, want, body)
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{
+			{Label: "full", Username: "alice", Password: "hunter2", OTP: &otpauth.URL{
+				Type:      "totp",
+				RawSecret: "MFRGGZDFMZTWQ2LK",
+				Digits:    6,
+				Period:    30,
+			}},
+			{Label: "nouser", Password: "swordfish"},
+			{Label: "empty"},
+		},
+	})
+	mux := s.ServeMux()
+
+	tests := []struct {
+		path    string
+		code    int
+		stages  int
+		present []string
+	}{
+		{"/sequence/0", http.StatusOK, 3, []string{"alice", "hunter2"}},
+		{"/sequence/1", http.StatusOK, 1, []string{"swordfish"}},
+		{"/sequence/2", http.StatusNotFound, 0, nil},
+	}
+	for _, tc := range tests {
+		rec := serve(mux, "GET", tc.path)
+		if rec.Code != tc.code {
+			t.Errorf("Get %s: got status %d, want %d", tc.path, rec.Code, tc.code)
+			continue
+		}
+		body := rec.Body.String()
+		if got := strings.Count(body, "<input "); got != tc.stages {
+			t.Errorf("Get %s: got %d stages, want %d", tc.path, got, tc.stages)
+		}
+		for _, want := range tc.present {
+			if !strings.Contains(body, want) {
+				t.Errorf("Get %s: body does not contain %q", tc.path, want)
+			}
+		}
+		if tc.code == http.StatusOK {
+			trig := rec.Header().Get("HX-Trigger-After-Settle")
+			if !strings.Contains(trig, `"copySequence"`) {
+				t.Errorf("Get %s: missing copySequence trigger: %q", tc.path, trig)
+			}
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{{
+			Label:    "test",
+			UID:      "8badf00d",
+			Username: "alice",
+			Password: "hunter2",
+			OTP: &otpauth.URL{
+				Type:      "totp",
+				RawSecret: "MFRGGZDFMZTWQ2LK",
+				Digits:    6,
+				Period:    30,
+			},
+			Details: []*kfdb.Detail{{Label: "pin", Value: "5678", Hidden: true}},
+			RecoveryCodes: []*kfdb.RecoveryCode{
+				{Code: "rc-used", Used: true}, {Code: "rc-unused"},
+			},
+
+			// A field from a newer schema version, not understood by this one.
+			Extra: map[string]json.RawMessage{"futureSecret": json.RawMessage(`"xyzzy"`)},
+		}},
+	})
+	s.LockPIN, s.RevealPIN = "1234", true
+	mux := s.ServeMux()
+
+	secrets := []string{"hunter2", "MFRGGZDFMZTWQ2LK", "5678", "rc-used", "rc-unused", "xyzzy"}
+	tests := []struct {
+		path    string
+		code    int
+		reveal  bool     // whether the secrets should be present
+		present []string // other strings that must be present
+	}{
+		{"/api/record/8badf00d", http.StatusOK, false, []string{`"alice"`, `"pin"`}},
+		{"/api/record/0", http.StatusOK, false, []string{`"alice"`}},
+		{"/api/record/8badf00d?reveal=1", http.StatusForbidden, false, []string{`"invalid PIN"`}},
+		{"/api/record/8badf00d?reveal=1&lockpin=9999", http.StatusForbidden, false, nil},
+		{"/api/record/8badf00d?reveal=1&lockpin=1234", http.StatusOK, true, nil},
+		{"/api/record/deadbeef", http.StatusNotFound, false, []string{`"no such record ID"`}},
+		{"/api/search?q=test", http.StatusOK, false, []string{`"quality"`, `"alice"`}},
+		{"/api/search?q=nonesuch", http.StatusOK, false, []string{`[]`}},
+	}
+	for _, tc := range tests {
+		rec := serve(mux, "GET", tc.path)
+		if rec.Code != tc.code {
+			t.Errorf("Get %s: got status %d, want %d", tc.path, rec.Code, tc.code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Get %s: got content type %q, want application/json", tc.path, ct)
+		}
+		if rec.Header().Get("Content-Security-Policy") == "" {
+			t.Errorf("Get %s: missing CSP header", tc.path)
+		}
+		body := rec.Body.String()
+		for _, secret := range secrets {
+			if got := strings.Contains(body, secret); got != tc.reveal {
+				t.Errorf("Get %s: body contains %q is %v, want %v", tc.path, secret, got, tc.reveal)
+			}
+		}
+		for _, want := range tc.present {
+			if !strings.Contains(body, want) {
+				t.Errorf("Get %s: body does not contain %q", tc.path, want)
+			}
+		}
+	}
+
+	// The record view reports the number of remaining recovery codes, but not
+	// the codes themselves.
+	rec := serve(mux, "GET", "/view/8badf00d")
+	if body := rec.Body.String(); !strings.Contains(body, "1 of 2 codes remaining") {
+		t.Errorf("Get view: missing recovery code count:\n%s", body)
+	} else if strings.Contains(body, "rc-unused") {
+		t.Error("Get view: body contains a recovery code")
+	}
+
+	// Redaction must not modify the stored record.
+	if got := s.Store().DB().Records[0]; got.Password != "hunter2" || got.Details[0].Value != "5678" {
+		t.Errorf("Stored record was modified: %+v", got)
+	}
+
+	// The API must respect the UI lock.
+	s.Locked = true
+	rec = serve(mux, "GET", "/api/search?q=test")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Get locked: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestField(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{{
+			Label:    "test",
+			Username: "alice",
+			Addrs:    kfdb.Strings{"alice@example.com"},
+			Password: "hunter2",
+			Details:  []*kfdb.Detail{{Label: "PIN", Value: "5678", Hidden: true}},
+		}},
+	})
+	s.NoReveal = true
+	mux := s.ServeMux()
+
+	tests := []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/field/0?name=username", http.StatusOK, `{"value":"alice"}`},
+		{"/field/0?name=email", http.StatusOK, `{"value":"alice@example.com"}`},
+		{"/field/0?name=password", http.StatusOK, `{"value":"hunter2"}`},
+		{"/field/0?name=detail:pin", http.StatusForbidden,
+			`{"error":"revealing hidden details is disabled","code":403}`},
+		{"/field/0?name=otp", http.StatusNotFound, `{"error":"no OTP configuration","code":404}`},
+		{"/field/0?name=bogus", http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		rec := serve(mux, "GET", tc.path, "Accept", "application/json")
+		if rec.Code != tc.code {
+			t.Errorf("Get %s: got status %d, want %d", tc.path, rec.Code, tc.code)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tc.want != "" && got != tc.want {
+			t.Errorf("Get %s: got body %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestAttachment(t *testing.T) {
+	const content = "-----BEGIN KEY-----\nsecret\n-----END KEY-----\n"
+	s := newTestUI(t, &kfdb.DB{
+		Records: []*kfdb.Record{{
+			Label: "test",
+			Attachments: []*kfdb.Attachment{{
+				Name: "id_test", Type: "application/octet-stream", Content: []byte(content),
+			}},
+		}},
+	})
+
+	tests := []struct {
+		path     string
+		noReveal bool
+		code     int
+	}{
+		{"/attachment/0?name=id_test", false, http.StatusOK},
+		{"/attachment/0?name=bogus", false, http.StatusNotFound},
+		{"/attachment/0?name=id_test", true, http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		s.NoReveal = tc.noReveal
+		rec := serve(s.ServeMux(), "GET", tc.path)
+		if rec.Code != tc.code {
+			t.Errorf("Get %s (noReveal=%v): got status %d, want %d", tc.path, tc.noReveal, rec.Code, tc.code)
+			continue
+		}
+		body := rec.Body.String()
+		if tc.code != http.StatusOK {
+			if strings.Contains(body, "secret") {
+				t.Errorf("Get %s (noReveal=%v): response contains the content", tc.path, tc.noReveal)
+			}
+			continue
+		}
+		if body != content {
+			t.Errorf("Get %s: got body %q, want %q", tc.path, body, content)
+		}
+		if got, want := rec.Header().Get("Content-Disposition"), `attachment; filename=id_test`; got != want {
+			t.Errorf("Get %s: Content-Disposition is %q, want %q", tc.path, got, want)
+		}
+	}
+
+	// The record view lists the attachment by name, but the API redacts its
+	// content.
+	rec := serve(s.ServeMux(), "GET", "/view/0")
+	if body := rec.Body.String(); !strings.Contains(body, "id_test") {
+		t.Errorf("View: attachment name not listed:\n%s", body)
+	}
+	rec = serve(s.ServeMux(), "GET", "/api/record/0")
+	if body := rec.Body.String(); !strings.Contains(body, "id_test") || strings.Contains(body, "content\":\"LS0t") {
+		t.Errorf("API: got %s, want name without content", body)
+	}
+}
+
+func TestStoreHashpass(t *testing.T) {
+	s := newTestUI(t, &kfdb.DB{
+		Defaults: &kfdb.Defaults{Hashpass: &kfdb.Hashpass{SecretKey: "key", Length: 12}},
+		Records: []*kfdb.Record{
+			{Label: "a", Hashpass: &kfdb.Hashpass{Seed: "a.com"}},
+			{Label: "b", Hashpass: &kfdb.Hashpass{Seed: "b.com"}, Password: "hunter2"},
+			{Label: "c", Password: "plain"},
+		},
+	})
+	var saves int
+	var saveErr error
+	s.LockPIN = "1234"
+	s.Save = func() error { saves++; return saveErr }
+
+	// Without expert mode, the endpoint is not served.
+	rec := serve(s.ServeMux(), "POST", "/store-hashpass/0")
+	if rec.Code != http.StatusMethodNotAllowed && rec.Code != http.StatusNotFound {
+		t.Errorf("Post without expert: got status %d, want not found", rec.Code)
+	}
+
+	s.Expert = true
+	mux := s.ServeMux()
+	post := func(path, pin string, htmx bool) int {
+		t.Helper()
+		return serve(mux, "POST", path, "HX-Request", value.Cond(htmx, "true", ""), "HX-Prompt", pin).Code
+	}
+	tests := []struct {
+		path, pin string
+		htmx      bool
+		want      int
+	}{
+		{"/store-hashpass/0", "1234", false, http.StatusForbidden}, // not from the UI
+		{"/store-hashpass/0", "", true, http.StatusForbidden},      // missing PIN
+		{"/store-hashpass/0", "9999", true, http.StatusForbidden},  // wrong PIN
+		{"/store-hashpass/1", "1234", true, http.StatusConflict},   // not confirmed
+		{"/store-hashpass/2", "1234", true, http.StatusBadRequest}, // no hashpass
+
+		{"/store-hashpass/0", "1234", true, http.StatusOK},
+		{"/store-hashpass/1?confirm=1", "1234", true, http.StatusOK},
+	}
+	for _, tc := range tests {
+		if got := post(tc.path, tc.pin, tc.htmx); got != tc.want {
+			t.Errorf("Post %s (pin=%q): got status %d, want %d", tc.path, tc.pin, got, tc.want)
+		}
+	}
+	if saves != 2 {
+		t.Errorf("Save calls: got %d, want 2", saves)
+	}
+	for _, r := range s.Store().DB().Records[:2] {
+		want, err := kflib.GenerateHashpass(s.Store().DB(), r, "")
+		if err != nil {
+			t.Fatalf("GenerateHashpass %q: %v", r.Label, err)
+		}
+		if r.Password != want {
+			t.Errorf("Record %q: password is %q, want %q", r.Label, r.Password, want)
+		}
+	}
+
+	// If the save fails, the change is reverted.
+	s.Store().DB().Records[0].Password = ""
+	saveErr = errors.New("disk full")
+	if got := post("/store-hashpass/0", "1234", true); got != http.StatusInternalServerError {
+		t.Errorf("Post with failed save: got status %d, want %d", got, http.StatusInternalServerError)
+	}
+	if pw := s.Store().DB().Records[0].Password; pw != "" {
+		t.Errorf("After failed save: password is %q, want empty", pw)
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	const numRecords = 2*defaultSearchLimit + 20
+	db := new(kfdb.DB)
+	for i := range numRecords {
+		db.Records = append(db.Records, &kfdb.Record{Label: fmt.Sprintf("rec%03d", i), Title: "Item"})
+	}
+	s := newTestUI(t, db)
+	mux := s.ServeMux()
+
+	tests := []struct {
+		query string
+		rows  int    // number of results rendered
+		count string // result count, if any
+		more  string // "show more" link, if any
+	}{
+		{"", defaultSearchLimit, "showing 50 of 120 results", "/search?limit=100"},
+		{"&limit=100", 100, "showing 100 of 120 results", "/search?limit=200"},
+		{"&limit=200", numRecords, "120 results", ""},
+		{"&limit=0", numRecords, "120 results", ""}, // 0 means all
+		{"&limit=-5", defaultSearchLimit, "showing 50 of 120 results", "/search?limit=100"},
+		{"&limit=bogus", defaultSearchLimit, "showing 50 of 120 results", "/search?limit=100"},
+		{"&limit=10", 10, "showing 10 of 120 results", "/search?limit=20"},
+	}
+	for _, tc := range tests {
+		path := "/search?q=*" + tc.query
+		rec := serve(mux, "GET", path)
+		if rec.Code != http.StatusOK {
+			t.Errorf("Get %s: got status %d, want %d", path, rec.Code, http.StatusOK)
+			continue
+		}
+		body := rec.Body.String()
+		if got := strings.Count(body, "<tr class=sr>"); got != tc.rows {
+			t.Errorf("Get %s: got %d results, want %d", path, got, tc.rows)
+		}
+		if !strings.Contains(body, ">"+tc.count+"<") {
+			t.Errorf("Get %s: body does not contain count %q", path, tc.count)
+		}
+		if got := strings.Contains(body, "Show more"); got != (tc.more != "") {
+			t.Errorf("Get %s: has show more is %v, want %v", path, got, tc.more != "")
+		} else if tc.more != "" && !strings.Contains(body, `hx-get="`+tc.more+`"`) {
+			t.Errorf("Get %s: body does not link to %q", path, tc.more)
+		}
+	}
+}
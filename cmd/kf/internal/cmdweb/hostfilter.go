@@ -0,0 +1,66 @@
+package cmdweb
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// A HostFilter reports whether a client address is permitted access, based on
+// a list of permitted network prefixes. A nil *HostFilter permits all hosts.
+type HostFilter struct {
+	prefixes []netip.Prefix
+}
+
+// NewHostFilter constructs a HostFilter that permits addresses within any of
+// the specified CIDR prefixes, e.g., "10.0.0.0/8" or "fd7a:115c:a1e0::/48".
+// A bare address is treated as a prefix containing only that address.
+func NewHostFilter(cidrs ...string) (*HostFilter, error) {
+	h := new(HostFilter)
+	for _, s := range cidrs {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			h.prefixes = append(h.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		h.prefixes = append(h.prefixes, p.Masked())
+	}
+	return h, nil
+}
+
+// Allow reports whether addr is permitted by h.
+func (h *HostFilter) Allow(addr netip.Addr) bool {
+	if h == nil {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, p := range h.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the network address of the client that sent r.  If
+// trustProxy is true and the request has an X-Forwarded-For header, the last
+// address in that header (the one added by the nearest proxy) is used.
+func clientAddr(r *http.Request, trustProxy bool) (netip.Addr, error) {
+	if xff := r.Header.Values("X-Forwarded-For"); trustProxy && len(xff) != 0 {
+		last := xff[len(xff)-1]
+		if i := strings.LastIndex(last, ","); i >= 0 {
+			last = last[i+1:]
+		}
+		return netip.ParseAddr(strings.TrimSpace(last))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.ParseAddr(host)
+}
@@ -30,15 +30,31 @@
         }
     }
 
+    // Copy the value of the element with the given ID to the clipboard, and
+    // then clear it.
+    function copyFromElement(id) {
+        const text = document.getElementById(id);
+
+        copyToClipboard(text.value);
+        text.value = '';
+    }
+
     // Add a listener for the HTMX event our API handler reports when injecting
     // the text requested by clicking a copy button, to copy the text from the
     // element where it was stored (identified in the detail) to the clipboard.
     window.addEventListener('copyText', (evt) => {
-        const id = evt.detail.value;
-        const text = document.getElementById(id);
+        copyFromElement(evt.detail.value);
+    });
 
-        copyToClipboard(text.value);
-        text.value = '';
+    // Add a listener for the HTMX event our API handler reports when injecting
+    // a sequence of values to copy. The values are copied one at a time, with
+    // the specified delay (in milliseconds) between them.
+    window.addEventListener('copySequence', (evt) => {
+        const ids = evt.detail.ids;
+        const delay = evt.detail.delay;
+        ids.forEach((id, i) => {
+            setTimeout(() => { copyFromElement(id); }, i*delay);
+        });
     });
 
     // Add a listener to the button injected when a hidden detail value is
@@ -2,10 +2,16 @@ package cmdweb
 
 import (
 	"bytes"
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
+	"mime"
 	"net/http"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,10 +27,15 @@ import (
 type UI struct {
 	μ         sync.Mutex // guards the fields below in server handlers
 	lockReset time.Time  // last unlock time
+	reveals   int        // number of secrets revealed since startup
 
 	// Store returns the active instance of the store to serve.
 	Store func() *kfdb.Store
 
+	// LoadError, if non-nil, reports an error from the most recent attempt to
+	// reload the store. If it reports an error, the UI displays a warning.
+	LoadError func() error
+
 	// Static is the filesystem containing static file assets.
 	Static fs.FS
 
@@ -43,6 +54,34 @@ type UI struct {
 
 	// Expert, if true, enables expert settings.
 	Expert bool
+
+	// Save, if non-nil, writes the active store back to its file. Endpoints
+	// that modify the database are only served if Save is set and Expert is
+	// true.
+	Save func() error
+
+	// NoReveal, if true, prevents the UI from revealing raw OTP secrets and
+	// the values of hidden details. Requests to do so are rejected.
+	NoReveal bool
+
+	// RevealPIN, if true, requires that each request to reveal a password,
+	// OTP code or secret, or hidden detail include the LockPIN. It has no
+	// effect if LockPIN is empty.
+	RevealPIN bool
+
+	// AllowHosts, if non-nil, restricts which client addresses may access
+	// the UI. Requests from other addresses are rejected.
+	AllowHosts *HostFilter
+
+	// TrustProxy, if true, means the client address for AllowHosts is taken
+	// from the X-Forwarded-For header, if present. Only set this if the UI is
+	// served behind a trusted reverse proxy.
+	TrustProxy bool
+
+	// Logf, if non-nil, is used to log a summary of each request.  Request
+	// paths are redacted so that the log does not record which records were
+	// accessed, and query parameters and response bodies are never logged.
+	Logf func(string, ...any)
 }
 
 // ServeMux returns a router for the UI endpoints:
@@ -54,9 +93,30 @@ type UI struct {
 //	GET /detail   -- serve a single record detail (partial)
 //	GET /password -- serve a single record password (partial)
 //	GET /totp     -- serve a single record TOTP code (partial)
+//	GET /sequence -- serve a staged copy of a record login (partial)
+//	GET /field    -- serve a named field of a single record (partial)
+//	POST /store-hashpass -- store the hashpass of a record as its password
 //	GET /unlock   -- request an unlock of the UI
+//	GET /api/record/{id} -- serve a single record (JSON)
+//	GET /api/search      -- serve search results (JSON)
+//	GET /healthz  -- report that the server is running
+//	GET /version  -- report build information for the server
+//
+// Records are addressed by UID, or by index for records that do not have one.
+//
+// The /api/ endpoints serve JSON for programmatic clients. Records served by
+// the API are redacted unless the request sets reveal=1 and is permitted to
+// reveal secrets.
+//
+// The /store-hashpass endpoint modifies the database, and is only served in
+// expert mode when s.Save is set.
+//
+// The /healthz and /version endpoints do not require the UI to be unlocked,
+// and do not access the database.
 func (s *UI) ServeMux() http.Handler {
 	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /version", s.version)
 	if s.Static != nil {
 		mux.Handle("GET /static/", http.FileServer(http.FS(s.Static)))
 	}
@@ -66,19 +126,67 @@ func (s *UI) ServeMux() http.Handler {
 	mux.HandleFunc("GET /detail/{id}/{index}", wrap(s, s.checkLock(s.detail)))
 	mux.HandleFunc("GET /password/{id}", wrap(s, s.checkLock(s.password)))
 	mux.HandleFunc("GET /totp/{id}", wrap(s, s.checkLock(s.totp)))
+	mux.HandleFunc("GET /sequence/{id}", wrap(s, s.checkLock(s.sequence)))
+	mux.HandleFunc("GET /field/{id}", wrap(s, s.checkLock(s.field)))
+	mux.HandleFunc("GET /attachment/{id}", wrap(s, s.checkLock(s.attachment)))
+	mux.HandleFunc("GET /api/record/{id}", wrap(s, s.checkLock(s.apiRecord)))
+	mux.HandleFunc("GET /api/search", wrap(s, s.checkLock(s.apiSearch)))
+	if s.canSave() {
+		mux.HandleFunc("POST /store-hashpass/{id}", wrap(s, s.checkLock(s.storeHashpass)))
+	}
 	if s.LockPIN != "" {
 		mux.HandleFunc("GET /lock", wrap(s, s.lock))
 		mux.HandleFunc("GET /unlock", wrap(s, s.unlock))
 	}
+	if s.Logf != nil {
+		return s.logRequests(mux)
+	}
 	return mux
 }
 
+// logRequests wraps h to log a summary of each request to s.Logf.
+func (s *UI) logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		s.Logf("%s %s from %s: %d (%v)", r.Method, redactPath(r.URL.Path),
+			r.RemoteAddr, sw.status, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// redactPath returns a copy of path in which all components after the first
+// are replaced with "*", so that record and detail identifiers are not logged.
+// Paths for static assets are not modified.
+func redactPath(path string) string {
+	if strings.HasPrefix(path, "/static/") {
+		return path
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = "*"
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// statusWriter is a http.ResponseWriter that records the status code of the
+// response written to it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
 // runTemplate invokes the named template with the specified argument value.
 // If the template reports an error, runTemplates serves a 500.
 func (s *UI) runTemplate(w http.ResponseWriter, r *http.Request, name string, value any) {
 	var buf bytes.Buffer
 	if err := s.Templates.Lookup(name).Execute(&buf, value); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -89,12 +197,24 @@ func (s *UI) runTemplate(w http.ResponseWriter, r *http.Request, name string, va
 func (s *UI) ui(w http.ResponseWriter, r *http.Request) {
 	s.updateLockLocked(false)
 
-	u := uiData{CanLock: s.LockPIN != "", Locked: s.Locked, Expert: s.Expert}
+	u := uiData{
+		CanLock:   s.LockPIN != "",
+		Locked:    s.Locked,
+		Expert:    s.Expert,
+		RevealPIN: s.revealPIN(),
+		Reveals:   s.reveals,
+	}
+	if !u.Locked && s.LoadError != nil {
+		s.Store() // check for updates
+		if err := s.LoadError(); err != nil {
+			u.LoadError = err.Error()
+		}
+	}
 	if query := strings.TrimSpace(r.FormValue("q")); query != "" {
 		if query != "*" && query != "?" {
 			u.Query = query
 		}
-		u.SearchResult = searchRecords(s.Store().DB().Records, u.Query)
+		u.setSearchResult(searchRecords(s.Store().DB().Records, u.Query), searchLimit(r))
 	}
 	s.runTemplate(w, r, "index.html.tmpl", u)
 }
@@ -109,58 +229,60 @@ func (s *UI) search(w http.ResponseWriter, r *http.Request) {
 		query = "" // find everything
 	default:
 	}
-	s.runTemplate(w, r, "search.html.tmpl", uiData{
-		SearchResult: searchRecords(s.Store().DB().Records, query),
-		Expert:       s.Expert,
-	})
+	u := uiData{Expert: s.Expert}
+	u.setSearchResult(searchRecords(s.Store().DB().Records, query), searchLimit(r))
+	s.runTemplate(w, r, "search.html.tmpl", u)
 }
 
 // view serves a record view (partial).
 func (s *UI) view(w http.ResponseWriter, r *http.Request) {
-	st := s.Store()
-	index, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil {
-		http.Error(w, "invalid ID", http.StatusBadRequest)
-		return
-	} else if index < 0 || index >= len(st.DB().Records) {
-		http.Error(w, "no such record ID", http.StatusNotFound)
+	index, rec := findRecord(w, r, s.Store().DB(), r.PathValue("id"))
+	if rec == nil {
 		return
 	}
 	s.runTemplate(w, r, "view.html.tmpl", uiData{
 		TargetRecord: &uiRecord{
 			Index:  index,
-			Record: st.DB().Records[index],
+			Record: rec,
 		},
-		Expert: s.Expert,
+		Expert:    s.Expert,
+		CanSave:   s.canSave(),
+		NoReveal:  s.NoReveal,
+		RevealPIN: s.revealPIN(),
+		HasPIN:    s.LockPIN != "",
 	})
 }
 
 // detail serves a record detail view (partial).  This is only called for
 // details marked as "hidden".
 func (s *UI) detail(w http.ResponseWriter, r *http.Request) {
-	id, err1 := strconv.Atoi(r.PathValue("id"))
-	index, err2 := strconv.Atoi(r.PathValue("index"))
-	if err1 != nil || err2 != nil {
-		http.Error(w, "invalid ID/index", http.StatusBadRequest)
+	if s.NoReveal {
+		httpError(w, r, "revealing hidden details is disabled", http.StatusForbidden)
 		return
 	}
-	st := s.Store()
-	if id < 0 || id >= len(st.DB().Records) {
-		http.Error(w, "no such record ID", http.StatusNotFound)
+	id, rec := findRecord(w, r, s.Store().DB(), r.PathValue("id"))
+	if rec == nil {
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		httpError(w, r, "invalid index", http.StatusBadRequest)
+		return
+	} else if index < 0 || index >= len(rec.Details) {
+		httpError(w, r, "no such detail index", http.StatusNotFound)
 		return
 	}
-	rec := st.DB().Records[id]
-	if index < 0 || index >= len(rec.Details) {
-		http.Error(w, "no such detail index", http.StatusNotFound)
+	if !s.checkReveal(w, r) {
 		return
 	}
-	tag := fmt.Sprintf("r%dd%d", id, index)
+	rid := recordID(id, rec)
+	tag := fmt.Sprintf("r%sd%d", rid, index)
 	det := rec.Details[index]
 
 	// N.B. Capitalization of HX matters here.
 	w.Header().Set("HX-Trigger-After-Settle", fmt.Sprintf(`{"setValueToggle":"%s"}`, tag))
 	s.runTemplate(w, r, "detail.html.tmpl", uiDetail{
-		RecordID: id,
+		RecordID: rid,
 		DetailID: index,
 		ID:       tag,
 		Label:    det.Label,
@@ -174,74 +296,334 @@ func (s *UI) detail(w http.ResponseWriter, r *http.Request) {
 // hashpass. If hashpass=1 is set it always produces a hashpass.
 func (s *UI) password(w http.ResponseWriter, r *http.Request) {
 	st := s.Store()
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil {
-		http.Error(w, "invalid ID", http.StatusBadRequest)
+	_, rec := findRecord(w, r, st.DB(), r.PathValue("id"))
+	if rec == nil {
 		return
-	} else if id < 0 || id >= len(st.DB().Records) {
-		http.Error(w, "no such record ID", http.StatusNotFound)
+	}
+	if !s.checkReveal(w, r) {
 		return
 	}
 	preferHash, _ := strconv.ParseBool(r.FormValue("hashpass"))
 
-	rec := st.DB().Records[id]
 	var pw string
 	if rec.Password != "" && !preferHash {
 		pw = rec.Password
 	} else {
+		var err error
 		pw, err = kflib.GenerateHashpass(st.DB(), rec, r.FormValue("tag"))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 
+	if wantJSON(r) {
+		writeJSON(w, http.StatusOK, jsonValue{Value: pw})
+		return
+	}
 	w.Header().Set("HX-Trigger-After-Settle", `{"copyText":"pwval"}`)
 	s.runTemplate(w, r, "pass.html.tmpl", uiDetail{ID: "pwval", Value: pw})
 }
 
+// storeHashpass generates the hashpass for a record and stores it as the
+// literal password of the record, then serves the updated record view
+// (partial). This preserves the current value of the password even if the
+// hashpass settings later change. If the record already has a stored
+// password, the request must set confirm=1 to replace it.
+//
+// Because it modifies the database, the request must be sent by the UI (with
+// an HX-Request header), and if a lock PIN is defined, must include it as for
+// checkReveal.
+func (s *UI) storeHashpass(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("HX-Request") != "true" {
+		httpError(w, r, "request must come from the UI", http.StatusForbidden)
+		return
+	}
+	if s.LockPIN != "" && !s.checkPIN(cmp.Or(r.FormValue("lockpin"), r.Header.Get("HX-Prompt"))) {
+		httpError(w, r, "invalid PIN", http.StatusForbidden)
+		return
+	}
+	st := s.Store()
+	_, rec := findRecord(w, r, st.DB(), r.PathValue("id"))
+	if rec == nil {
+		return
+	}
+	if rec.Hashpass == nil {
+		httpError(w, r, "record has no hashpass config", http.StatusBadRequest)
+		return
+	}
+	if rec.Password != "" && !parseBool(r, "confirm", false) {
+		httpError(w, r, "record already has a stored password (set confirm=1 to replace it)", http.StatusConflict)
+		return
+	}
+	pw, err := kflib.GenerateHashpass(st.DB(), rec, r.FormValue("tag"))
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	old, oldSet := rec.Password, rec.PasswordSetAt
+	st.Update(func(*kfdb.DB) { kflib.SetPassword(rec, pw, time.Now()) })
+	if err := s.Save(); err != nil {
+		st.Update(func(*kfdb.DB) { rec.Password, rec.PasswordSetAt = old, oldSet })
+		httpError(w, r, "saving database: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.view(w, r)
+}
+
+// otpError reports an error from kflib.GenerateOTP to the client. A missing
+// secret is reported as not found, and a malformed one as unprocessable, so
+// that clients can tell a record without a usable OTP from a corrupt one.
+func otpError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, kflib.ErrNoSecret):
+		httpError(w, r, "no OTP secret", http.StatusNotFound)
+	case errors.Is(err, kflib.ErrInvalidSecret):
+		httpError(w, r, "invalid OTP secret", http.StatusUnprocessableEntity)
+	default:
+		httpError(w, r, "unable to generate OTP", http.StatusInternalServerError)
+	}
+}
+
+// canSave reports whether s serves endpoints that modify the database.
+func (s *UI) canSave() bool { return s.Expert && s.Save != nil }
+
 // totp serves a record TOTP fragment (partial).
 // It reports an error if the record does not have an OTP configuration.
 func (s *UI) totp(w http.ResponseWriter, r *http.Request) {
 	st := s.Store()
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil {
-		http.Error(w, "invalid ID", http.StatusBadRequest)
-		return
-	} else if id < 0 || id >= len(st.DB().Records) {
-		http.Error(w, "no such record ID", http.StatusNotFound)
+	id, rec := findRecord(w, r, st.DB(), r.PathValue("id"))
+	if rec == nil {
 		return
 	}
-	rec := st.DB().Records[id]
 	u, field := rec.OTP, "otpval"
 	if det, err := strconv.Atoi(r.FormValue("detail")); err == nil {
 		if det < 0 || det >= len(rec.Details) {
-			http.Error(w, "no such detail", http.StatusNotFound)
+			httpError(w, r, "no such detail", http.StatusNotFound)
 			return
 		}
 		u, err = otpauth.ParseURL(rec.Details[det].Value)
 		if err != nil {
-			http.Error(w, "detail is not an OTP", http.StatusGone)
+			httpError(w, r, "detail is not an OTP", http.StatusGone)
 			return
 		}
-		field = fmt.Sprintf("r%dd%dotp", id, det)
+		field = fmt.Sprintf("r%sd%dotp", recordID(id, rec), det)
 	} else if u == nil {
-		http.Error(w, "no OTP configuration", http.StatusNotFound)
+		httpError(w, r, "no OTP configuration", http.StatusNotFound)
 		return
 	}
 
+	// Check that the request is permitted and can succeed before the reveal
+	// check, so that only successful reveals are counted.
 	var otp string
+	var err error
 	if parseBool(r, "key", false) {
+		if s.NoReveal {
+			httpError(w, r, "revealing OTP secrets is disabled", http.StatusForbidden)
+			return
+		}
 		otp = u.RawSecret
-	} else if otp, err = kflib.GenerateOTP(u, 0); err != nil {
-		http.Error(w, "unable to generate OTP", http.StatusInternalServerError)
+	} else if otp, err = kflib.GenerateOTPWithDefaults(st.DB(), u, 0); err != nil {
+		otpError(w, r, err)
+		return
+	}
+	if !s.checkReveal(w, r) {
 		return
 	}
 
+	if wantJSON(r) {
+		writeJSON(w, http.StatusOK, jsonValue{Value: otp})
+		return
+	}
 	w.Header().Set("HX-Trigger-After-Settle", fmt.Sprintf(`{"copyText":"%s"}`, field))
 	s.runTemplate(w, r, "pass.html.tmpl", uiDetail{ID: field, Value: otp})
 }
 
+// field serves the value of a named record field (partial), as resolved by
+// kflib.RecordField from the "name" parameter. Revealing a secret field is
+// subject to the same checks as the other endpoints.
+func (s *UI) field(w http.ResponseWriter, r *http.Request) {
+	st := s.Store()
+	id, rec := findRecord(w, r, st.DB(), r.PathValue("id"))
+	if rec == nil {
+		return
+	}
+	name := r.FormValue("name")
+	value, secret, err := kflib.RecordField(st.DB(), rec, r.FormValue("tag"), name)
+	if errors.Is(err, kflib.ErrInvalidSecret) {
+		otpError(w, r, err)
+		return
+	} else if err != nil {
+		httpError(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if secret {
+		if s.NoReveal && strings.HasPrefix(name, "detail:") {
+			httpError(w, r, "revealing hidden details is disabled", http.StatusForbidden)
+			return
+		} else if !s.checkReveal(w, r) {
+			return
+		}
+	}
+
+	if wantJSON(r) {
+		writeJSON(w, http.StatusOK, jsonValue{Value: value})
+		return
+	}
+	tag := fmt.Sprintf("r%sf", recordID(id, rec))
+	w.Header().Set("HX-Trigger-After-Settle", fmt.Sprintf(`{"copyText":"%s"}`, tag))
+	s.runTemplate(w, r, "pass.html.tmpl", uiDetail{ID: tag, Value: value})
+}
+
+// attachment serves the content of a record attachment named by the "name"
+// parameter as a download. Attachments are treated as secrets, so they are
+// not served if revealing is disabled.
+func (s *UI) attachment(w http.ResponseWriter, r *http.Request) {
+	if s.NoReveal {
+		httpError(w, r, "downloading attachments is disabled", http.StatusForbidden)
+		return
+	}
+	_, rec := findRecord(w, r, s.Store().DB(), r.PathValue("id"))
+	if rec == nil {
+		return
+	}
+	att := rec.FindAttachment(r.FormValue("name"))
+	if att == nil {
+		httpError(w, r, "no such attachment", http.StatusNotFound)
+		return
+	}
+	if !s.checkReveal(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", cmp.Or(att.Type, "application/octet-stream"))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": att.Name,
+	}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(att.Content)
+}
+
+// sequenceDelay is the interval between the stages of a copy sequence.
+const sequenceDelay = 5 * time.Second
+
+// sequence serves a record login fragment (partial), containing the username,
+// password, and OTP code of the record, in that order. The client copies each
+// of them in turn, pausing between stages to allow the user to paste. Stages
+// for which the record has no value are skipped.
+func (s *UI) sequence(w http.ResponseWriter, r *http.Request) {
+	st := s.Store()
+	id, rec := findRecord(w, r, st.DB(), r.PathValue("id"))
+	if rec == nil {
+		return
+	}
+	if !s.checkReveal(w, r) {
+		return
+	}
+
+	var values []string
+	if rec.Username != "" {
+		values = append(values, rec.Username)
+	}
+	if rec.Password != "" {
+		values = append(values, rec.Password)
+	} else if rec.Hashpass != nil {
+		pw, err := kflib.GenerateHashpass(st.DB(), rec, r.FormValue("tag"))
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		values = append(values, pw)
+	}
+	if rec.OTP != nil {
+		otp, err := kflib.GenerateOTPWithDefaults(st.DB(), rec.OTP, 0)
+		if err != nil {
+			otpError(w, r, err)
+			return
+		}
+		values = append(values, otp)
+	}
+	if len(values) == 0 {
+		httpError(w, r, "nothing to copy", http.StatusNotFound)
+		return
+	}
+
+	rid := recordID(id, rec)
+	var seq []uiDetail
+	var ids []string
+	for i, v := range values {
+		tag := fmt.Sprintf("r%ss%d", rid, i)
+		seq = append(seq, uiDetail{ID: tag, Value: v})
+		ids = append(ids, tag)
+	}
+	trigger, err := json.Marshal(map[string]any{
+		"copySequence": map[string]any{"ids": ids, "delay": sequenceDelay.Milliseconds()},
+	})
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Trigger-After-Settle", string(trigger))
+	s.runTemplate(w, r, "sequence.html.tmpl", seq)
+}
+
+// apiRecord serves a single record as JSON. The record is redacted (see
+// redactRecord) unless reveal=1 is set and the request may reveal secrets.
+func (s *UI) apiRecord(w http.ResponseWriter, r *http.Request) {
+	_, rec := findRecord(w, r, s.Store().DB(), r.PathValue("id"))
+	if rec == nil {
+		return
+	}
+	if parseBool(r, "reveal", false) {
+		if s.NoReveal {
+			httpError(w, r, "revealing secrets is disabled", http.StatusForbidden)
+			return
+		}
+		if !s.checkReveal(w, r) {
+			return
+		}
+	} else {
+		rec = redactRecord(rec)
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// apiSearch serves search results as JSON. The records in the results are
+// always redacted (see redactRecord). An empty query matches all records.
+func (s *UI) apiSearch(w http.ResponseWriter, r *http.Request) {
+	found := searchRecords(s.Store().DB().Records, strings.TrimSpace(r.FormValue("q")))
+	if found == nil {
+		found = []kflib.FoundRecord{} // encode as [] rather than null
+	}
+	for i, fr := range found {
+		found[i].Record = redactRecord(fr.Record)
+	}
+	writeJSON(w, http.StatusOK, found)
+}
+
+// healthz reports that the server is running.
+func (s *UI) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// version reports build information for the server.
+func (s *UI) version(w http.ResponseWriter, r *http.Request) {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		httpError(w, r, "build information not available", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s %s\n", bi.Main.Path, cmp.Or(bi.Main.Version, "(devel)"))
+	fmt.Fprintf(w, "go: %s\n", bi.GoVersion)
+	for _, kv := range bi.Settings {
+		if strings.HasPrefix(kv.Key, "vcs.") {
+			fmt.Fprintf(w, "%s: %s\n", kv.Key, kv.Value)
+		}
+	}
+}
+
 // lock requests a lock of the UI.  It redirects to the UI.
 func (s *UI) lock(w http.ResponseWriter, r *http.Request) {
 	s.Locked = true
@@ -251,8 +633,8 @@ func (s *UI) lock(w http.ResponseWriter, r *http.Request) {
 // unlock requests an unlock of the UI.  It redirects to the UI if it is not
 // locked, or reports an error if the specified PIN does not match.
 func (s *UI) unlock(w http.ResponseWriter, r *http.Request) {
-	if s.Locked && r.FormValue("lockpin") != s.LockPIN {
-		http.Error(w, "invalid PIN", http.StatusForbidden)
+	if s.Locked && !s.checkPIN(r.FormValue("lockpin")) {
+		httpError(w, r, "invalid PIN", http.StatusForbidden)
 		return
 	}
 	s.Locked = false
@@ -260,11 +642,35 @@ func (s *UI) unlock(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// checkPIN reports whether pin matches the lock PIN.
+func (s *UI) checkPIN(pin string) bool {
+	return subtle.ConstantTimeCompare([]byte(pin), []byte(s.LockPIN)) == 1
+}
+
+// revealPIN reports whether revealing secrets requires the lock PIN.
+func (s *UI) revealPIN() bool { return s.RevealPIN && s.LockPIN != "" }
+
+// checkReveal reports whether r may reveal a secret, and if so counts the
+// reveal. If s.RevealPIN is set, the request must carry the lock PIN, either
+// in the "lockpin" parameter or in the HX-Prompt header sent by the UI.
+// If not, checkReveal writes an error to w and returns false.
+func (s *UI) checkReveal(w http.ResponseWriter, r *http.Request) bool {
+	if s.revealPIN() {
+		pin := cmp.Or(r.FormValue("lockpin"), r.Header.Get("HX-Prompt"))
+		if !s.checkPIN(pin) {
+			httpError(w, r, "invalid PIN", http.StatusForbidden)
+			return false
+		}
+	}
+	s.reveals++
+	return true
+}
+
 func (s *UI) checkLock(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		s.updateLockLocked(true)
 		if s.Locked {
-			http.Error(w, "UI is locked", http.StatusForbidden)
+			httpError(w, r, "UI is locked", http.StatusForbidden)
 			return
 		}
 		h.ServeHTTP(w, r)
@@ -299,6 +705,14 @@ var contentSecurityPolicy = strings.Join([]string{
 
 func wrap(s *UI, h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if s.AllowHosts != nil {
+			addr, err := clientAddr(r, s.TrustProxy)
+			if err != nil || !s.AllowHosts.Allow(addr) {
+				httpError(w, r, "host not allowed", http.StatusForbidden)
+				return
+			}
+		}
+
 		s.μ.Lock()
 		defer s.μ.Unlock()
 
@@ -307,12 +721,137 @@ func wrap(s *UI, h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// findRecord returns the index and record of db addressed by id, which may be
+// either a record UID or, for compatibility, the index of a record.  If no
+// record matches, findRecord writes an error to w and returns a nil record.
+func findRecord(w http.ResponseWriter, r *http.Request, db *kfdb.DB, id string) (int, *kfdb.Record) {
+	if id != "" {
+		for i, rec := range db.Records {
+			if rec.UID == id {
+				return i, rec
+			}
+		}
+	}
+	index, err := strconv.Atoi(id)
+	if err != nil || index < 0 || index >= len(db.Records) {
+		httpError(w, r, "no such record ID", http.StatusNotFound)
+		return 0, nil
+	}
+	return index, db.Records[index]
+}
+
+// recordID returns the ID used to address rec, at the given index, in the UI.
+// This is the UID of rec if it has one, otherwise its index.
+func recordID(index int, rec *kfdb.Record) string {
+	if rec.UID != "" {
+		return rec.UID
+	}
+	return strconv.Itoa(index)
+}
+
 func searchRecords(recs []*kfdb.Record, query string) []kflib.FoundRecord {
 	return slice.Partition(kflib.FindRecords(recs, query), func(fr kflib.FoundRecord) bool {
 		return !fr.Record.Archived
 	})
 }
 
+// defaultSearchLimit is the maximum number of search results rendered when
+// the request does not specify a limit.
+const defaultSearchLimit = 50
+
+// searchLimit returns the maximum number of search results to render for r.
+// The limit may be set by the "limit" query parameter; a value of 0 means all
+// results are shown.
+func searchLimit(r *http.Request) int {
+	v, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || v < 0 {
+		return defaultSearchLimit
+	}
+	return v
+}
+
+// wantJSON reports whether the client that sent r accepts a JSON response.
+// Programmatic callers may set "Accept: application/json" to receive errors
+// and values as JSON objects rather than HTML or plain text. Requests to the
+// /api/ endpoints always receive JSON.
+func wantJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+	for _, v := range r.Header.Values("Accept") {
+		for _, mt := range strings.Split(v, ",") {
+			mt, _, _ = strings.Cut(mt, ";")
+			if strings.TrimSpace(mt) == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// httpError reports an error to the client, as a JSON object if the client
+// accepts JSON (see wantJSON), and otherwise as plain text.
+func httpError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	if wantJSON(r) {
+		writeJSON(w, code, jsonError{Error: msg, Code: code})
+		return
+	}
+	http.Error(w, msg, code)
+}
+
+// writeJSON writes value to w as JSON with the given HTTP status code.
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+// jsonError is the JSON encoding of an error response.
+type jsonError struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// jsonValue is the JSON encoding of a value response.
+type jsonValue struct {
+	Value string `json:"value"`
+}
+
+// redactRecord returns a copy of rec with its secrets removed: The password
+// and password verifier, the OTP configuration, the hashpass secret key, the
+// values of hidden details, and the recovery codes. The original record is not
+// modified.
+func redactRecord(rec *kfdb.Record) *kfdb.Record {
+	cp := *rec
+	cp.Extra = nil // unknown fields may hold secrets
+	cp.Password = ""
+	cp.PasswordHash = ""
+	cp.OTP = nil
+	if cp.Hashpass != nil {
+		hp := *cp.Hashpass
+		hp.SecretKey = ""
+		cp.Hashpass = &hp
+	}
+	cp.Details = make([]*kfdb.Detail, len(rec.Details))
+	for i, d := range rec.Details {
+		dc := *d
+		if dc.Hidden {
+			dc.Value = ""
+		}
+		cp.Details[i] = &dc
+	}
+	cp.RecoveryCodes = make([]*kfdb.RecoveryCode, len(rec.RecoveryCodes))
+	for i, c := range rec.RecoveryCodes {
+		cp.RecoveryCodes[i] = &kfdb.RecoveryCode{Used: c.Used}
+	}
+	cp.Attachments = make([]*kfdb.Attachment, len(rec.Attachments))
+	for i, a := range rec.Attachments {
+		cp.Attachments[i] = &kfdb.Attachment{Name: a.Name, Type: a.Type}
+	}
+	return &cp
+}
+
 func parseBool(r *http.Request, name string, dflt bool) bool {
 	v := r.FormValue(name)
 	if v == "" {
@@ -328,10 +867,30 @@ func parseBool(r *http.Request, name string, dflt bool) bool {
 type uiData struct {
 	Query        string
 	SearchResult []kflib.FoundRecord
+	NumFound     int // total number of search results before capping
+	NextLimit    int // if positive, the limit to request to show more results
 	TargetRecord *uiRecord
-	CanLock      bool // whether locking is enabled
-	Locked       bool // whether the UI is locked now
-	Expert       bool // whether to enable expert features
+	LoadError    string // if non-empty, an error from reloading the store
+	CanLock      bool   // whether locking is enabled
+	Locked       bool   // whether the UI is locked now
+	Expert       bool   // whether to enable expert features
+	CanSave      bool   // whether endpoints that modify the database are enabled
+	NoReveal     bool   // whether revealing secrets is disabled
+	RevealPIN    bool   // whether revealing secrets requires the lock PIN
+	HasPIN       bool   // whether a lock PIN is defined
+	Reveals      int    // number of secrets revealed since startup
+}
+
+// setSearchResult populates the search result of u from found, keeping at most
+// limit results. If limit == 0, all the results are kept. Since found is
+// ordered by match quality, capping retains the best matches.
+func (u *uiData) setSearchResult(found []kflib.FoundRecord, limit int) {
+	u.NumFound = len(found)
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+		u.NextLimit = 2 * limit
+	}
+	u.SearchResult = found
 }
 
 type uiRecord struct {
@@ -339,8 +898,12 @@ type uiRecord struct {
 	Record *kfdb.Record
 }
 
+// PasswordExpired reports whether the stored password of the record is due
+// for rotation (see kflib.PasswordExpired).
+func (u *uiRecord) PasswordExpired() bool { return kflib.PasswordExpired(u.Record, time.Now()) }
+
 type uiDetail struct {
-	RecordID int
+	RecordID string
 	DetailID int
 	ID       string
 	Label    string
@@ -4,14 +4,20 @@ package main
 import (
 	"cmp"
 	"os"
+	"slices"
 
 	"github.com/creachadair/command"
 	"github.com/creachadair/flax"
 	"github.com/creachadair/keyfish/cmd/kf/config"
 
+	"github.com/creachadair/keyfish/cmd/kf/internal/cmdaudit"
 	"github.com/creachadair/keyfish/cmd/kf/internal/cmdcli"
+	"github.com/creachadair/keyfish/cmd/kf/internal/cmdcomplete"
 	"github.com/creachadair/keyfish/cmd/kf/internal/cmddb"
 	"github.com/creachadair/keyfish/cmd/kf/internal/cmddebug"
+	"github.com/creachadair/keyfish/cmd/kf/internal/cmdhashpass"
+	"github.com/creachadair/keyfish/cmd/kf/internal/cmdimport"
+	"github.com/creachadair/keyfish/cmd/kf/internal/cmdinfo"
 	"github.com/creachadair/keyfish/cmd/kf/internal/cmdrecord"
 	"github.com/creachadair/keyfish/cmd/kf/internal/cmdweb"
 )
@@ -22,10 +28,21 @@ import (
 // containing the program executable.
 var defaultDBPath string
 
+// platformFlags are additional flag structs bound by the root command on some
+// platforms. If platformInit != nil, it is called to update the settings from
+// those flags.
+var (
+	platformFlags []any
+	platformInit  func(*config.Settings)
+)
+
 func main() {
 	var flags = struct {
 		DBPath string `flag:"db,default=*,Database path (required)"`
 		PFile  string `flag:"kf.pfile,PRIVATE:Read passphrase from this file path"`
+		Quiet  bool   `flag:"quiet,Suppress informational messages"`
+		Inter  bool   `flag:"interactive,Choose among ambiguous matches from a menu"`
+		NoHist bool   `flag:"no-history,Do not record accessed records in the history"`
 	}{DBPath: cmp.Or(defaultDBPath, os.Getenv("KEYFISH_DB"))}
 
 	root := &command.C{
@@ -35,22 +52,36 @@ func main() {
 Keyfish generates and stores a database of site-specific passwords.
 Site data and passwords are stored in a database encrypted with a secret
 key provided by the user. Use --db to specify the database path, or set
-the KEYFISH_DB environment variable.`,
+the KEYFISH_DB environment variable. References to environment variables
+in the path are expanded, and a leading "$0" denotes the directory of the
+kf program.
+
+Requested values such as passwords and codes are written to stdout.
+Status messages are written to stderr, and --quiet suppresses them.`,
 
-		SetFlags: command.Flags(flax.MustBind, &flags),
+		SetFlags: command.Flags(flax.MustBind, append([]any{&flags}, platformFlags...)...),
 
 		Init: func(env *command.Env) error {
 			env.Config = &config.Settings{
-				DBPath: flags.DBPath,
-				PFile:  flags.PFile,
+				DBPath:      flags.DBPath,
+				PFile:       flags.PFile,
+				Quiet:       flags.Quiet,
+				Interactive: flags.Inter,
+				NoHistory:   flags.NoHist,
+			}
+			if platformInit != nil {
+				platformInit(env.Config.(*config.Settings))
 			}
 			return nil
 		},
 
-		Commands: append(
-			cmdcli.Commands,
+		Commands: slices.Concat(cmdcli.Commands, []*command.C{
 			cmddb.Command,
 			cmdrecord.Command,
+			cmdhashpass.Command,
+			cmdimport.Command,
+			cmdaudit.Command,
+			cmdinfo.Command,
 			cmdweb.Command,
 			command.HelpCommand([]command.HelpTopic{{
 				Name: "query-syntax",
@@ -59,25 +90,34 @@ the KEYFISH_DB environment variable.`,
 Various commands accept a query to identify which record or records to
 operate on. A query has the form [tag@]label. The label is either the unique
 label assigned to a record, a full or partial match for one of the hostnames
-associated with the record, or a substring match for the title or notes
-field of the record.
+or aliases associated with the record, a substring match for the title or
+notes field of the record, or a near miss (typo) for one of these.
 
 Matching records are ranked from most to least specific:
 
  1. An exact match on the record label.
- 2. An exact match on a hostname of the record.
- 3. A partial (suffix) match on a hostname of the record.
- 4. A substring match on the title.
+ 2. An exact match on a hostname or alias of the record.
+ 3. A partial (suffix) match on a hostname or alias of the record.
+ 4. A substring match on the title or label.
  5. A substring match on the label of a detail.
- 6. A substring match in some other text field.
+ 6. A substring match in some other text field, hostname, or alias.
+ 7. A fuzzy match, within a few edits of the label, a word of the title,
+    or a component of a hostname or alias.
+
+Among records ranked equally, a record marked as a favorite (see "record fav")
+is preferred.
 
 A command that requires a single record will select the highest-ranked
 unique result. If no such record exists, the command will report an error
-listing the candidate records that could have been chosen.`,
+listing the candidate records that could have been chosen. Commands that
+modify the database do not select a record by a fuzzy match; instead, the
+error suggests the near misses. With --interactive, when stdin and stdout
+are terminals, the commands that print or copy values instead offer a menu
+of the candidates to choose from.`,
 			}}),
 			command.VersionCommand(),
 			cmddebug.Command,
-		),
+		}, cmdcomplete.Commands),
 	}
 	command.RunOrFail(root.NewEnv(nil), os.Args[1:])
 }
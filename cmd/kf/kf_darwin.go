@@ -0,0 +1,12 @@
+package main
+
+import "github.com/creachadair/keyfish/cmd/kf/config"
+
+var keychainFlags struct {
+	Keychain bool `flag:"db-from-keychain,Read the database passphrase from the login keychain"`
+}
+
+func init() {
+	platformFlags = append(platformFlags, &keychainFlags)
+	platformInit = func(set *config.Settings) { set.Keychain = keychainFlags.Keychain }
+}
@@ -3,14 +3,22 @@ package kfdb
 
 import (
 	"bytes"
+	"cmp"
 	crand "crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/creachadair/keyfish/kfstore"
+	"github.com/creachadair/mds/mbits"
 	"github.com/creachadair/otp/otpauth"
 	"golang.org/x/crypto/hkdf"
 	yaml "gopkg.in/yaml.v3"
@@ -22,11 +30,33 @@ type Store = kfstore.Store[DB]
 
 // A DB is a database of sensitive data managed by keyfish.
 type DB struct {
+	// SchemaVersion is the version of the database schema. Databases written
+	// before versioning was added have version 0. Use Migrate to update the
+	// database to the current version.
+	SchemaVersion int `json:"schemaVersion,omitempty" yaml:"schema-version,omitempty"`
+
 	// Defaults are default values for certain record fields.
 	Defaults *Defaults `json:"defaults,omitempty" yaml:"defaults,omitempty"`
 
 	// Records are the data records contained in the database.
 	Records []*Record `json:"records,omitempty" yaml:"records,omitempty"`
+
+	// Extra holds fields of the encoded database that are not understood by
+	// this version of the package, so that they can be preserved when the
+	// database is written back. It is not included in YAML.
+	Extra map[string]json.RawMessage `json:"-" yaml:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, capturing unknown fields.
+func (db *DB) UnmarshalJSON(data []byte) error {
+	type shim DB
+	return unmarshalExtra(data, (*shim)(db), &db.Extra)
+}
+
+// MarshalJSON implements json.Marshaler, including any unknown fields.
+func (db DB) MarshalJSON() ([]byte, error) {
+	type shim DB
+	return marshalExtra(shim(db), db.Extra)
 }
 
 // Defaults are default values applied to records that do not define their own
@@ -37,6 +67,26 @@ type Defaults struct {
 
 	// WebUI, if set, contains defaults for the web UI.
 	Web *WebConfig `json:"webConfig,omitempty" yaml:"web-config,omitempty"`
+
+	// OTP, if set, contains defaults for OTP code generation.
+	OTP *OTPDefaults `json:"otp,omitempty" yaml:"otp,omitempty"`
+
+	// EMail, if set, is the base e-mail address from which plus-addressed
+	// e-mail addresses are generated for records, e.g., user+site@example.com.
+	EMail string `json:"email,omitempty" yaml:"email,omitempty"`
+
+	// Templates, if set, are named templates for the contents of new records.
+	Templates map[string]*Template `json:"templates,omitempty" yaml:"templates,omitempty"`
+}
+
+// A Template describes initial contents for a new record.
+type Template struct {
+	// Tags are added to the tags of the new record.
+	Tags []string `json:"tags,omitempty" yaml:"tags,flow,omitempty"`
+
+	// Details are added to the details of the new record. A detail with an
+	// empty value serves as a placeholder to be filled in later.
+	Details []*Detail `json:"details,omitempty" yaml:"details,omitempty"`
 }
 
 // A Record records an item of interest such as a login account.
@@ -44,6 +94,11 @@ type Record struct {
 	// Label is a short identifier for this record.
 	Label string `json:"label,omitempty" yaml:"label,omitempty"`
 
+	// UID is a unique identifier for this record, assigned when the record is
+	// created. Unlike the position of the record in the database, it does not
+	// change when other records are added, removed, or reordered.
+	UID string `json:"uid,omitempty" yaml:"uid,omitempty"`
+
 	// Title is a human-readable title for this record.
 	Title string `json:"title,omitempty" yaml:"title,omitempty"`
 
@@ -51,12 +106,21 @@ type Record struct {
 	// shown in default listings and search results.
 	Archived bool `json:"archived,omitempty" yaml:"archived,omitempty"`
 
+	// Favorite, if true, indicates the record is preferred over other records
+	// that match a query equally well.
+	Favorite bool `json:"favorite,omitempty" yaml:"favorite,omitempty"`
+
 	// Username is the user name or login associated with this record.
 	Username string `json:"username,omitempty" yaml:"username,omitempty"`
 
 	// Hosts are optional hostnames associated with this record.
 	Hosts Strings `json:"hosts,omitempty" yaml:"hosts,flow,omitempty"`
 
+	// Aliases are optional alternate hostnames for this record. An alias
+	// matches a query the same way a host does, but aliases are not used as
+	// the default hashpass seed, and are shown separately from Hosts.
+	Aliases Strings `json:"aliases,omitempty" yaml:"aliases,flow,omitempty"`
+
 	// Addrs are e-mail addresses associated with this record.
 	Addrs Strings `json:"addrs,omitempty" yaml:"addrs,flow,omitempty"`
 
@@ -72,11 +136,123 @@ type Record struct {
 	// Password, if non-empty, is a generated password.
 	Password string `json:"password,omitempty" yaml:"password,omitempty"`
 
+	// PasswordSetAt, if non-nil, is when the stored password was last set.
+	PasswordSetAt *time.Time `json:"passwordSetAt,omitempty" yaml:"password-set-at,omitempty"`
+
+	// PasswordHash, if non-empty, is a bcrypt hash of a password that is not
+	// itself stored. It can be used to verify that a password was typed
+	// correctly, but not to recover the password. It is unrelated to the
+	// stored Password and to the Hashpass config.
+	PasswordHash string `json:"passwordHash,omitempty" yaml:"password-hash,omitempty"`
+
+	// RotateAfter, if positive, is how long after PasswordSetAt the stored
+	// password should be changed.
+	RotateAfter Duration `json:"rotateAfter,omitempty" yaml:"rotate-after,omitempty"`
+
 	// OTP, if non-nil, is used to generate one-time 2FA codes.
 	OTP *otpauth.URL `json:"otp,omitempty" yaml:"otp,omitempty"`
 
 	// Details are optional labelled data annotations.
 	Details []*Detail `json:"details,omitempty" yaml:"details,omitempty"`
+
+	// RecoveryCodes are one-time recovery codes issued for this record.  Codes
+	// that have been used are marked rather than removed.
+	RecoveryCodes []*RecoveryCode `json:"recoveryCodes,omitempty" yaml:"recovery-codes,omitempty"`
+
+	// Attachments are small files stored with this record.
+	Attachments []*Attachment `json:"attachments,omitempty" yaml:"attachments,omitempty"`
+
+	// Extra holds fields of the encoded record that are not understood by
+	// this version of the package, so that they can be preserved when the
+	// record is written back. It is not included in YAML.
+	Extra map[string]json.RawMessage `json:"-" yaml:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, capturing unknown fields.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	type shim Record
+	return unmarshalExtra(data, (*shim)(r), &r.Extra)
+}
+
+// MarshalJSON implements json.Marshaler, including any unknown fields.
+func (r Record) MarshalJSON() ([]byte, error) {
+	type shim Record
+	return marshalExtra(shim(r), r.Extra)
+}
+
+// A RecoveryCode is a one-time recovery code for a record.
+type RecoveryCode struct {
+	// Code is the text of the recovery code.
+	Code string `json:"code" yaml:"code"`
+
+	// Used, if true, indicates the code has been used.
+	Used bool `json:"used,omitempty" yaml:"used,omitempty"`
+}
+
+// RemainingCodes returns the number of recovery codes of r that have not
+// been used.
+func (r *Record) RemainingCodes() int {
+	var n int
+	for _, c := range r.RecoveryCodes {
+		if !c.Used {
+			n++
+		}
+	}
+	return n
+}
+
+// An Attachment is a small named file stored with a record.
+type Attachment struct {
+	// Name is the name of the attachment, unique within its record.
+	Name string `json:"name" yaml:"name"`
+
+	// Type is the MIME type of the content, if known.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+
+	// Content is the content of the attachment. It is encoded as base64.
+	Content []byte `json:"content" yaml:"content"`
+}
+
+// attachmentYAML is the YAML encoding of an Attachment, in which the content
+// is base64-encoded as in JSON, rather than as a sequence of integers.
+type attachmentYAML struct {
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type,omitempty"`
+	Content string `yaml:"content"`
+}
+
+// MarshalYAML implements yaml.Marshaler, encoding the content as base64.
+func (a Attachment) MarshalYAML() (any, error) {
+	return attachmentYAML{
+		Name:    a.Name,
+		Type:    a.Type,
+		Content: base64.StdEncoding.EncodeToString(a.Content),
+	}, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, decoding base64 content.
+func (a *Attachment) UnmarshalYAML(node *yaml.Node) error {
+	var v attachmentYAML
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	content, err := base64.StdEncoding.DecodeString(v.Content)
+	if err != nil {
+		return fmt.Errorf("attachment %q: invalid content: %w", v.Name, err)
+	}
+	*a = Attachment{Name: v.Name, Type: v.Type, Content: content}
+	return nil
+}
+
+// FindAttachment returns the attachment of r with the given name, or nil if
+// there is no such attachment.
+func (r *Record) FindAttachment(name string) *Attachment {
+	for _, a := range r.Attachments {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
 }
 
 // Detail is a labelled data annotation for a record.
@@ -108,6 +284,12 @@ type Hashpass struct {
 	// Punct, if non-nil, specifies whether punctuation should be included in
 	// the generated password.
 	Punct *bool `json:"punct,omitempty" yaml:"punct,omitempty"`
+
+	// Alphabet, if non-empty, specifies the characters used in the generated
+	// password, and takes precedence over Punct. Each element is a character
+	// class ("upper", "lower", "letter", "digit", "punct"), or "chars:" followed
+	// by literal characters. The order of the elements affects the output.
+	Alphabet Strings `json:"alphabet,omitempty" yaml:"alphabet,flow,omitempty"`
 }
 
 // Strings is a convenience alias for an array of strings that decodes from
@@ -167,15 +349,236 @@ func (a *array[T]) UnmarshalYAML(node *yaml.Node) error {
 	}
 }
 
+// unmarshalExtra unmarshals the JSON object in data into v, which must be a
+// pointer to a struct, and sets *extra to a map of the fields of data that do
+// not correspond to fields of v, or nil if there are none.
+func unmarshalExtra(data []byte, v any, extra *map[string]json.RawMessage) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	known := knownFields(reflect.TypeOf(v).Elem())
+	for key := range fields {
+		// N.B. encoding/json matches field names case-insensitively.
+		if known[strings.ToLower(key)] {
+			delete(fields, key)
+		}
+	}
+	if len(fields) == 0 {
+		fields = nil
+	}
+	*extra = fields
+	return nil
+}
+
+// marshalExtra marshals v, which must encode as a JSON object, and adds the
+// fields of extra that are not already present in the encoding.
+func marshalExtra(v any, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	known := knownFields(reflect.TypeOf(v))
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1]) // drop the closing brace
+	for _, key := range slices.Sorted(maps.Keys(extra)) {
+		if known[strings.ToLower(key)] {
+			continue // do not duplicate a known field
+		}
+		kb, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(extra[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// knownFields returns the set of lowercased JSON field names of struct type t.
+func knownFields(t reflect.Type) map[string]bool {
+	out := make(map[string]bool)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		out[strings.ToLower(cmp.Or(name, f.Name))] = true
+	}
+	return out
+}
+
+// NewUID returns a new randomly-generated record UID.
+func NewUID() string {
+	var buf [8]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		panic(fmt.Sprintf("generate UID: %v", err))
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// AssignUIDs assigns a new UID to each record of db that does not have one.
+// It reports whether any records were updated.
+func (db *DB) AssignUIDs() bool {
+	var changed bool
+	for _, r := range db.Records {
+		if r.UID == "" {
+			r.UID = NewUID()
+			changed = true
+		}
+	}
+	return changed
+}
+
+// assignDerivedUIDs assigns a UID to each record of db that does not have
+// one, derived from the label and title of the record. Records with the same
+// label and title are distinguished by their order in db.
+func (db *DB) assignDerivedUIDs() {
+	seen := make(map[string]bool)
+	for _, r := range db.Records {
+		if r.UID != "" {
+			seen[r.UID] = true
+		}
+	}
+	for _, r := range db.Records {
+		if r.UID != "" {
+			continue
+		}
+		for n := 0; ; n++ {
+			sum := sha256.Sum256(fmt.Appendf(nil, "keyfish uid\x00%s\x00%s\x00%d", r.Label, r.Title, n))
+			if uid := hex.EncodeToString(sum[:8]); !seen[uid] {
+				r.UID = uid
+				seen[uid] = true
+				break
+			}
+		}
+	}
+}
+
+// Normalize puts the list fields of each record of db into canonical form
+// (see [Record.Normalize]). It reports whether any records were updated.
+func (db *DB) Normalize() bool {
+	var changed bool
+	for _, r := range db.Records {
+		if r.Normalize() {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Normalize puts the list fields of r into canonical form: Tags are trimmed,
+// lowercased, sorted, and deduplicated, and empty tags are removed. Duplicate
+// Hosts, Aliases, and Addrs are removed, keeping the first occurrence of each, so that
+// their order (and hence the default hashpass seed) is preserved. Normalize is
+// idempotent. It reports whether r was modified.
+func (r *Record) Normalize() bool {
+	var tags []string
+	for _, t := range r.Tags {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	slices.Sort(tags)
+	tags = slices.Compact(tags)
+
+	hosts, aliases, addrs := dedup(r.Hosts), dedup(r.Aliases), dedup(r.Addrs)
+	changed := !slices.Equal(tags, r.Tags) || len(hosts) != len(r.Hosts) ||
+		len(aliases) != len(r.Aliases) || len(addrs) != len(r.Addrs)
+	r.Tags, r.Hosts, r.Aliases, r.Addrs = tags, hosts, aliases, addrs
+	return changed
+}
+
+// dedup returns the elements of ss with duplicates removed, keeping the first
+// occurrence of each. If ss has no duplicates, it is returned unmodified.
+func dedup[T ~[]string](ss T) T {
+	seen := make(map[string]bool, len(ss))
+	var out T
+	for i, s := range ss {
+		if seen[s] {
+			if out == nil {
+				out = slices.Clone(ss[:i])
+			}
+			continue
+		}
+		seen[s] = true
+		if out != nil {
+			out = append(out, s)
+		}
+	}
+	if out == nil {
+		return ss
+	}
+	return out
+}
+
+// CurrentSchemaVersion is the current version of the database schema.
+const CurrentSchemaVersion = 2
+
+// migrations[v] updates a database from schema version v to version v+1.
+var migrations = []func(*DB) error{
+	// 0 → 1: Add a schema version. No other changes.
+	func(*DB) error { return nil },
+
+	// 1 → 2: Assign a UID to each record. The migration does not save the
+	// database, so the UIDs are derived from the records rather than random:
+	// A legacy database that is only read gets the same UIDs each time.
+	func(db *DB) error { db.assignDerivedUIDs(); return nil },
+}
+
+// Migrate updates db in-place to CurrentSchemaVersion, applying each required
+// migration in order. A database whose version is newer than the current
+// version is not modified.
+func Migrate(db *DB) error {
+	if db.SchemaVersion < 0 {
+		return fmt.Errorf("invalid schema version %d", db.SchemaVersion)
+	}
+	for v := db.SchemaVersion; v < len(migrations); v++ {
+		if err := migrations[v](db); err != nil {
+			return fmt.Errorf("migrate schema version %d to %d: %w", v, v+1, err)
+		}
+		db.SchemaVersion = v + 1
+	}
+	return nil
+}
+
 // Open reads a DB store from r using the given passphrase to generate a store
-// access key.
+// access key. The database is migrated to the current schema version, and its
+// records are normalized (see [DB.Normalize]).
 func Open(r io.Reader, passphrase string) (*Store, error) {
-	return kfstore.Open[DB](r, deriveKey(passphrase))
+	var akey []byte
+	derive := deriveKey(passphrase)
+	s, err := kfstore.Open[DB](r, func(salt []byte) []byte {
+		akey = derive(salt)
+		return akey
+	})
+	mbits.Zero(akey) // the store does not retain the access key
+	if err != nil {
+		return nil, err
+	}
+	if err := Migrate(s.DB()); err != nil {
+		return nil, err
+	}
+	s.DB().Normalize()
+	return s, nil
 }
 
 // New creates a new DB store using the given passphrase to generate a store
-// access key. If init != nil, it is used as the initial database.
+// access key. If init != nil, it is used as the initial database; otherwise
+// the initial database is empty, at the current schema version.
 func New(passphrase string, init *DB) (*Store, error) {
+	if init == nil {
+		init = &DB{SchemaVersion: CurrentSchemaVersion}
+	}
 	buf := make([]byte, 2*kfstore.AccessKeyLen)
 	accessKey, keySalt := buf[:kfstore.AccessKeyLen], buf[kfstore.AccessKeyLen:]
 	if _, err := crand.Read(keySalt); err != nil {
@@ -185,9 +588,13 @@ func New(passphrase string, init *DB) (*Store, error) {
 	if _, err := io.ReadFull(h, accessKey); err != nil {
 		return nil, fmt.Errorf("generate access key: %w", err)
 	}
+	defer mbits.Zero(accessKey) // N.B. not the salt, which the store keeps
 	return kfstore.New(accessKey, keySalt, init)
 }
 
+// deriveKey returns a function that derives a store access key from the
+// passphrase and a salt. Each call returns a new slice, which the caller
+// should zero when it is no longer needed.
 func deriveKey(passphrase string) kfstore.KeyFunc {
 	return func(salt []byte) []byte {
 		h := hkdf.New(sha256.New, []byte(passphrase), salt, nil)
@@ -209,6 +616,20 @@ type WebConfig struct {
 	LockTimeout Duration `json:"lockTimeout,omitempty" yaml:"lock-timeout,omitempty"`
 }
 
+// OTPDefaults are default settings for OTP configurations that do not specify
+// their own. Zero-valued fields use the standard defaults (SHA1, 6 digits,
+// and a 30-second period).
+type OTPDefaults struct {
+	// Algorithm is the name of the hash algorithm, e.g., "SHA1" or "SHA256".
+	Algorithm string `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+
+	// Digits is the number of digits in a generated code.
+	Digits int `json:"digits,omitempty" yaml:"digits,omitempty"`
+
+	// Period is the TOTP time step in seconds.
+	Period int `json:"period,omitempty" yaml:"period,omitempty"`
+}
+
 // A Duration represents the encoding of a [time.Duration] in JSON using a
 // string representation compatible with [time.ParseDuration].
 //
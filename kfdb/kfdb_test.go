@@ -3,6 +3,7 @@ package kfdb_test
 import (
 	"bytes"
 	crand "crypto/rand"
+	"encoding/json"
 	"io"
 	mrand "math/rand"
 	"testing"
@@ -10,6 +11,7 @@ import (
 	"github.com/creachadair/keyfish/kfdb"
 	"github.com/creachadair/mds/mtest"
 	gocmp "github.com/google/go-cmp/cmp"
+	yaml "gopkg.in/yaml.v3"
 )
 
 func TestDB(t *testing.T) {
@@ -53,3 +55,305 @@ func TestDB(t *testing.T) {
 		}
 	})
 }
+
+func TestExtraFields(t *testing.T) {
+	const input = `{
+  "records": [{"label": "a", "futureField": {"x": 1}, "Title": "A"}],
+  "futureVersion": 3
+}`
+	var db kfdb.DB
+	if err := json.Unmarshal([]byte(input), &db); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got := string(db.Extra["futureVersion"]); got != "3" {
+		t.Errorf("DB extra: got %q, want 3", got)
+	}
+	r := db.Records[0]
+	if r.Title != "A" {
+		t.Errorf("Record title: got %q, want A", r.Title)
+	}
+	if diff := gocmp.Diff(r.Extra, map[string]json.RawMessage{
+		"futureField": json.RawMessage(`{"x": 1}`),
+	}); diff != "" {
+		t.Errorf("Record extra (-got, +want):\n%s", diff)
+	}
+
+	// Open, modify, and save the database, and check that the extra fields
+	// survive.
+	const testPass = "password"
+	s, err := kfdb.New(testPass, &db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	s2, err := kfdb.Open(&buf, testPass)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s2.DB().Records[0].Notes = "modified"
+	s2.DB().Records = append(s2.DB().Records, &kfdb.Record{Label: "b"})
+	buf.Reset()
+	if _, err := s2.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	s3, err := kfdb.Open(&buf, testPass)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if diff := gocmp.Diff(s3.DB(), s2.DB()); diff != "" {
+		t.Errorf("Reopened database (-got, +want):\n%s", diff)
+	}
+	if got := string(s3.DB().Records[0].Extra["futureField"]); got != `{"x":1}` {
+		t.Errorf("Record extra: got %q, want %q", got, `{"x":1}`)
+	}
+
+	// Extra fields must not override known fields.
+	r = &kfdb.Record{Label: "c", Extra: map[string]json.RawMessage{"label": json.RawMessage(`"d"`)}}
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if got, want := string(data), `{"label":"c"}`; got != want {
+		t.Errorf("Marshal: got %s, want %s", got, want)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	t.Run("V0", func(t *testing.T) {
+		// A database written before schema versions existed.
+		const input = `{"records":[{"label":"a","hosts":"a.example.com"}]}`
+		var db kfdb.DB
+		if err := json.Unmarshal([]byte(input), &db); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if db.SchemaVersion != 0 {
+			t.Fatalf("Schema version: got %d, want 0", db.SchemaVersion)
+		}
+		if err := kfdb.Migrate(&db); err != nil {
+			t.Fatalf("Migrate: unexpected error: %v", err)
+		}
+		for i, r := range db.Records {
+			if r.UID == "" {
+				t.Errorf("Record %d: no UID assigned", i+1)
+			}
+			r.UID = "" // random, so not compared below
+		}
+		want := kfdb.DB{
+			SchemaVersion: kfdb.CurrentSchemaVersion,
+			Records:       []*kfdb.Record{{Label: "a", Hosts: kfdb.Strings{"a.example.com"}}},
+		}
+		if diff := gocmp.Diff(db, want); diff != "" {
+			t.Errorf("Migrated database (-got, +want):\n%s", diff)
+		}
+	})
+	t.Run("Open", func(t *testing.T) {
+		s, err := kfdb.New("test", &kfdb.DB{Records: []*kfdb.Record{{Label: "a"}}})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		var buf bytes.Buffer
+		if _, err := s.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo: %v", err)
+		}
+		s2, err := kfdb.Open(&buf, "test")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if got := s2.DB().SchemaVersion; got != kfdb.CurrentSchemaVersion {
+			t.Errorf("Schema version after Open: got %d, want %d", got, kfdb.CurrentSchemaVersion)
+		}
+	})
+	t.Run("StableUIDs", func(t *testing.T) {
+		// A legacy database that is opened but never saved must get the same
+		// UIDs each time it is opened.
+		s, err := kfdb.New("test", &kfdb.DB{SchemaVersion: 1, Records: []*kfdb.Record{
+			{Label: "a"}, {Label: "b"}, {Label: "b"}, {Title: "c"}, {Label: "d", UID: "8badf00d"},
+		}})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		var buf bytes.Buffer
+		if _, err := s.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo: %v", err)
+		}
+		uids := func() []string {
+			t.Helper()
+			s, err := kfdb.Open(bytes.NewReader(buf.Bytes()), "test")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			var out []string
+			for _, r := range s.DB().Records {
+				out = append(out, r.UID)
+			}
+			return out
+		}
+		first, second := uids(), uids()
+		if diff := gocmp.Diff(first, second); diff != "" {
+			t.Errorf("UIDs differ between opens (-first, +second):\n%s", diff)
+		}
+		if first[4] != "8badf00d" {
+			t.Errorf("Existing UID: got %q, want 8badf00d", first[4])
+		}
+		seen := make(map[string]bool)
+		for i, uid := range first {
+			if uid == "" || seen[uid] {
+				t.Errorf("Record %d: UID %q is empty or duplicate", i+1, uid)
+			}
+			seen[uid] = true
+		}
+	})
+	t.Run("Future", func(t *testing.T) {
+		db := kfdb.DB{SchemaVersion: kfdb.CurrentSchemaVersion + 5}
+		if err := kfdb.Migrate(&db); err != nil {
+			t.Errorf("Migrate: unexpected error: %v", err)
+		} else if db.SchemaVersion != kfdb.CurrentSchemaVersion+5 {
+			t.Errorf("Migrate: changed version to %d", db.SchemaVersion)
+		}
+	})
+	t.Run("New", func(t *testing.T) {
+		s, err := kfdb.New("test", nil)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		if got := s.DB().SchemaVersion; got != kfdb.CurrentSchemaVersion {
+			t.Errorf("Schema version: got %d, want %d", got, kfdb.CurrentSchemaVersion)
+		}
+	})
+}
+
+func TestNormalize(t *testing.T) {
+	db := &kfdb.DB{Records: []*kfdb.Record{
+		{
+			Label: "a",
+			Tags:  []string{"Work", " mail ", "work", "", "alpha"},
+			Hosts: kfdb.Strings{"b.com", "a.com", "b.com"},
+			Addrs: kfdb.Strings{"x@a.com", "x@a.com"},
+		},
+		{Label: "b", Tags: []string{"a", "b"}, Hosts: kfdb.Strings{"b.com", "a.com"}},
+	}}
+	if !db.Normalize() {
+		t.Error("Normalize: reported no change, want change")
+	}
+	want := []*kfdb.Record{
+		{
+			Label: "a",
+			Tags:  []string{"alpha", "mail", "work"},
+			Hosts: kfdb.Strings{"b.com", "a.com"},
+			Addrs: kfdb.Strings{"x@a.com"},
+		},
+		{Label: "b", Tags: []string{"a", "b"}, Hosts: kfdb.Strings{"b.com", "a.com"}},
+	}
+	if diff := gocmp.Diff(db.Records, want); diff != "" {
+		t.Errorf("Normalized records (-got, +want):\n%s", diff)
+	}
+
+	// Normalizing again should have no further effect.
+	if db.Normalize() {
+		t.Error("Normalize: reported change on normalized records")
+	}
+	if diff := gocmp.Diff(db.Records, want); diff != "" {
+		t.Errorf("Renormalized records (-got, +want):\n%s", diff)
+	}
+}
+
+func TestAttachmentEncoding(t *testing.T) {
+	want := &kfdb.Record{
+		Label: "test",
+		Attachments: []*kfdb.Attachment{
+			{Name: "key.bin", Type: "application/octet-stream", Content: []byte{0, 1, 2, 255}},
+			{Name: "notes.txt", Content: []byte("hello")},
+		},
+	}
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if !bytes.Contains(data, []byte(`"content":"AAEC/w=="`)) {
+			t.Errorf("Encoded content is not base64: %s", data)
+		}
+		got := new(kfdb.Record)
+		if err := json.Unmarshal(data, got); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if diff := gocmp.Diff(got, want); diff != "" {
+			t.Errorf("Decoded record (-got, +want):\n%s", diff)
+		}
+	})
+	t.Run("YAML", func(t *testing.T) {
+		data, err := yaml.Marshal(want)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if !bytes.Contains(data, []byte("content: AAEC/w==")) {
+			t.Errorf("Encoded content is not base64:\n%s", data)
+		}
+		got := new(kfdb.Record)
+		if err := yaml.Unmarshal(data, got); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if diff := gocmp.Diff(got, want); diff != "" {
+			t.Errorf("Decoded record (-got, +want):\n%s", diff)
+		}
+	})
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := kfdb.JSONSchema()
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatalf("Encode schema: %v", err)
+	}
+	if got := schema["$ref"]; got != "#/$defs/DB" {
+		t.Errorf("Root ref: got %v, want #/$defs/DB", got)
+	}
+	defs := schema["$defs"].(map[string]any)
+	props := func(name string) map[string]any {
+		t.Helper()
+		def, ok := defs[name].(map[string]any)
+		if !ok {
+			t.Fatalf("Missing definition for %q", name)
+		}
+		return def["properties"].(map[string]any)
+	}
+
+	// Every field of an encoded record should be described by the schema.
+	data, err := json.Marshal(kfdb.Record{
+		Label: "x", UID: "x", Title: "x", Archived: true, Favorite: true, Username: "x",
+		Hosts: kfdb.Strings{"x"}, Addrs: kfdb.Strings{"x"}, Tags: []string{"x"}, Notes: "x",
+		Hashpass: &kfdb.Hashpass{}, Password: "x", Details: []*kfdb.Detail{{}},
+		RecoveryCodes: []*kfdb.RecoveryCode{{}}, Attachments: []*kfdb.Attachment{{}},
+	})
+	if err != nil {
+		t.Fatalf("Marshal record: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Unmarshal record: %v", err)
+	}
+	rp := props("Record")
+	for name := range fields {
+		if _, ok := rp[name]; !ok {
+			t.Errorf("Record field %q is not in the schema", name)
+		}
+	}
+
+	check := func(got any, want string) {
+		t.Helper()
+		g, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if string(g) != want {
+			t.Errorf("Got %s, want %s", g, want)
+		}
+	}
+	check(rp["hosts"], `{"oneOf":[{"type":"string"},{"items":{"type":"string"},"type":"array"}]}`)
+	check(rp["otp"], `{"description":"An otpauth:// URL.","format":"uri","type":"string"}`)
+	check(props("Attachment")["content"], `{"contentEncoding":"base64","type":"string"}`)
+	check(props("WebConfig")["lockTimeout"].(map[string]any)["type"], `"string"`)
+	check(defs["Detail"].(map[string]any)["required"], `["label","value"]`)
+}
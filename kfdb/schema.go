@@ -0,0 +1,115 @@
+package kfdb
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+)
+
+// SchemaURI is the JSON Schema dialect of the schema returned by JSONSchema.
+const SchemaURI = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema returns a JSON Schema describing the plaintext JSON encoding of a
+// DB. The schema is generated from the struct tags of the database types, so
+// it stays in sync with them. Each named struct type has a definition under
+// "$defs", and the root of the schema refers to the definition of DB.
+//
+// The result can be encoded directly with [encoding/json].
+func JSONSchema() map[string]any {
+	defs := make(map[string]any)
+	root := schemaFor(reflect.TypeFor[DB](), defs)
+	return map[string]any{
+		"$schema": SchemaURI,
+		"title":   "keyfish database",
+		"$ref":    root["$ref"],
+		"$defs":   defs,
+	}
+}
+
+var (
+	textMarshaler = reflect.TypeFor[encoding.TextMarshaler]()
+	durationType  = reflect.TypeFor[Duration]()
+	otpURLType    = reflect.TypeFor[otpauth.URL]()
+)
+
+// schemaFor returns a schema for values of type t, adding definitions for
+// any struct types it refers to into defs.
+func schemaFor(t reflect.Type, defs map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == durationType:
+		return map[string]any{
+			"type":        "string",
+			"description": `A duration in Go format, e.g., "1h30m".`,
+		}
+	case t == otpURLType:
+		return map[string]any{
+			"type":        "string",
+			"format":      "uri",
+			"description": "An otpauth:// URL.",
+		}
+	case t.Implements(textMarshaler) || reflect.PointerTo(t).Implements(textMarshaler):
+		return map[string]any{"type": "string"}
+	case t.PkgPath() == reflect.TypeFor[DB]().PkgPath() && strings.HasPrefix(t.Name(), "array["):
+		// An array encodes a single element by itself (see array.MarshalJSON).
+		elem := schemaFor(t.Elem(), defs)
+		return map[string]any{
+			"oneOf": []any{elem, map[string]any{"type": "array", "items": elem}},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+		if _, ok := defs[t.Name()]; ok {
+			return ref
+		}
+		def := map[string]any{"type": "object"}
+		defs[t.Name()] = def // placeholder, in case of recursion
+
+		props := make(map[string]any)
+		var required []string
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if !f.IsExported() || tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaFor(f.Type, defs)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		def["properties"] = props
+		if len(required) != 0 {
+			def["required"] = required
+		}
+		return ref
+	default:
+		return map[string]any{} // any value
+	}
+}
@@ -0,0 +1,49 @@
+package kflib
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/creachadair/keyfish/kfdb"
+)
+
+// MaxAttachmentSize is the maximum size in bytes of the content of a single
+// record attachment. Attachments are stored in the encrypted database, so
+// this limit keeps them from bloating it.
+const MaxAttachmentSize = 64 << 10
+
+// Attach adds an attachment with the given name and content to rec.  If rec
+// already has an attachment with that name, it is replaced.  The MIME type
+// of the attachment is inferred from the extension of name if possible, or
+// otherwise from the content.  Attach reports whether an existing attachment
+// was replaced.
+func Attach(rec *kfdb.Record, name string, content []byte) (bool, error) {
+	if err := checkAttachment(name, content); err != nil {
+		return false, err
+	}
+	mtype := mime.TypeByExtension(filepath.Ext(name))
+	if mtype == "" {
+		mtype = http.DetectContentType(content)
+	}
+	att := &kfdb.Attachment{Name: name, Type: mtype, Content: content}
+	if old := rec.FindAttachment(name); old != nil {
+		*old = *att
+		return true, nil
+	}
+	rec.Attachments = append(rec.Attachments, att)
+	return false, nil
+}
+
+// checkAttachment reports an error if name and content are not valid for an
+// attachment.
+func checkAttachment(name string, content []byte) error {
+	if name == "" {
+		return errors.New("attachment name is empty")
+	} else if len(content) > MaxAttachmentSize {
+		return fmt.Errorf("attachment %q is %d bytes, the limit is %d", name, len(content), MaxAttachmentSize)
+	}
+	return nil
+}
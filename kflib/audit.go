@@ -0,0 +1,121 @@
+package kflib
+
+import (
+	"slices"
+	"time"
+
+	"github.com/creachadair/keyfish/kfdb"
+)
+
+// AuditReport is a summary of potential security problems with the records
+// of a database. Records are identified by label. Archived records are not
+// included in the report.
+type AuditReport struct {
+	// Records with neither a stored password nor a usable hashpass config.
+	NoPassword []string `json:"noPassword,omitempty"`
+
+	// Groups of records that share the same stored password.
+	Reused [][]string `json:"reused,omitempty"`
+
+	// Groups of records without a stored password whose hashpass configs
+	// generate the same password.
+	SameHashpass [][]string `json:"sameHashpass,omitempty"`
+
+	// Records whose stored password is shorter than the minimum length.
+	Short []string `json:"short,omitempty"`
+
+	// Records that have a login (a username and a host) but no OTP config.
+	NoOTP []string `json:"noOTP,omitempty"`
+
+	// Records whose stored password is due for rotation (see PasswordExpired).
+	Expired []string `json:"expired,omitempty"`
+}
+
+// IsEmpty reports whether r contains no findings.
+func (r *AuditReport) IsEmpty() bool {
+	return len(r.NoPassword) == 0 && len(r.Reused) == 0 && len(r.SameHashpass) == 0 &&
+		len(r.Short) == 0 && len(r.NoOTP) == 0 && len(r.Expired) == 0
+}
+
+// Audit scans the unarchived records of db and reports potential problems.
+// Records without a stored password whose effective hashpass configs are the
+// same are reported together, since they generate the same password.
+// Stored passwords shorter than minLength are reported as short; if
+// minLength ≤ 0 the length check is skipped. Stored passwords due for rotation
+// as of the current time are reported as expired.
+func Audit(db *kfdb.DB, minLength int) *AuditReport {
+	var out AuditReport
+	now := time.Now()
+	byPassword := make(map[string][]string)
+	var order []string // passwords in order of first use, for stable output
+	byConfig := make(map[hashpassConfig][]string)
+	var corder []hashpassConfig // likewise, for hashpass configs
+	for _, r := range db.Records {
+		if r.Archived {
+			continue
+		}
+		if r.Password == "" {
+			hc, err := getHashpassConfig(db, r, "")
+			if err != nil {
+				out.NoPassword = append(out.NoPassword, r.Label)
+			} else {
+				key := hc.canonical()
+				if len(byConfig[key]) == 0 {
+					corder = append(corder, key)
+				}
+				byConfig[key] = append(byConfig[key], r.Label)
+			}
+		} else {
+			if len(byPassword[r.Password]) == 0 {
+				order = append(order, r.Password)
+			}
+			byPassword[r.Password] = append(byPassword[r.Password], r.Label)
+			if len(r.Password) < minLength {
+				out.Short = append(out.Short, r.Label)
+			}
+			if PasswordExpired(r, now) {
+				out.Expired = append(out.Expired, r.Label)
+			}
+		}
+		if r.OTP == nil && r.Username != "" && len(r.Hosts) != 0 {
+			out.NoOTP = append(out.NoOTP, r.Label)
+		}
+	}
+	for _, pw := range order {
+		if labels := byPassword[pw]; len(labels) > 1 {
+			out.Reused = append(out.Reused, slices.Clone(labels))
+		}
+	}
+	for _, hc := range corder {
+		if labels := byConfig[hc]; len(labels) > 1 {
+			out.SameHashpass = append(out.SameHashpass, slices.Clone(labels))
+		}
+	}
+	return &out
+}
+
+// SetPassword sets the stored password of rec to pw, and records now as the
+// time it was set. If pw is empty, the time is cleared.
+func SetPassword(rec *kfdb.Record, pw string, now time.Time) {
+	rec.Password = pw
+	if pw == "" {
+		rec.PasswordSetAt = nil
+	} else {
+		now = now.UTC().Truncate(time.Second)
+		rec.PasswordSetAt = &now
+	}
+}
+
+// PasswordExpired reports whether the stored password of rec is due for
+// rotation as of now. This is true if rec has a stored password and a
+// positive RotateAfter, and the password was set at least RotateAfter before
+// now. A password with no recorded set time is treated as expired, since its
+// age is unknown.
+func PasswordExpired(rec *kfdb.Record, now time.Time) bool {
+	if rec.Password == "" || rec.RotateAfter <= 0 {
+		return false
+	} else if rec.PasswordSetAt == nil {
+		return true
+	}
+	return !now.Before(rec.PasswordSetAt.Add(rec.RotateAfter.Get()))
+}
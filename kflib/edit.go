@@ -9,10 +9,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/creachadair/keyfish/kfdb"
 	"github.com/creachadair/mds/mdiff"
 	"github.com/creachadair/mds/mstr"
+	"github.com/creachadair/mds/value"
 	"golang.org/x/term"
 	yaml "gopkg.in/yaml.v3"
 )
@@ -23,6 +26,9 @@ import (
 // are unmarshaled back into a new value, which is returned; otherwise an error
 // is reported.
 //
+// Unknown fields (Extra) of a *kfdb.DB or *kfdb.Record value, which are not
+// rendered as YAML, are copied from the input to the result (see keepExtra).
+//
 // If the edit did not change the input, Edit returns (value, ErrNoChange).
 // If the user rejected the changes, Edit returns (value, ErrUserReject).
 func Edit[T any](ctx context.Context, value T) (T, error) {
@@ -99,8 +105,54 @@ confirm:
 		}
 	}
 
-	err = yaml.Unmarshal(edited, &out)
-	return out, err
+	return decodeEdited(value, edited)
+}
+
+// decodeEdited unmarshals the edited YAML text into a new value, and copies
+// any unknown fields from orig into the result.
+func decodeEdited[T any](orig T, edited []byte) (T, error) {
+	var out T
+	if err := yaml.Unmarshal(edited, &out); err != nil {
+		return out, err
+	}
+	keepExtra(orig, out)
+	return out, nil
+}
+
+// keepExtra copies the unknown fields of orig into out, if they are both
+// *kfdb.DB or both *kfdb.Record values. Otherwise it does nothing.  Records of
+// a database are matched by UID or, if they have no UID, by label.
+func keepExtra(orig, out any) {
+	switch o := orig.(type) {
+	case *kfdb.Record:
+		if r, ok := out.(*kfdb.Record); ok && o != nil && r != nil {
+			r.Extra = o.Extra
+		}
+	case *kfdb.DB:
+		db, ok := out.(*kfdb.DB)
+		if !ok || o == nil || db == nil {
+			return
+		}
+		db.Extra = o.Extra
+		byUID := make(map[string]*kfdb.Record)
+		byLabel := make(map[string]*kfdb.Record)
+		for _, r := range o.Records {
+			if r.UID != "" {
+				byUID[r.UID] = r
+			} else if r.Label != "" {
+				byLabel[r.Label] = r
+			}
+		}
+		for _, r := range db.Records {
+			if r == nil {
+				continue
+			} else if old, ok := byUID[r.UID]; ok && r.UID != "" {
+				r.Extra = old.Extra
+			} else if old, ok := byLabel[r.Label]; ok && r.UID == "" {
+				r.Extra = old.Extra
+			}
+		}
+	}
 }
 
 var (
@@ -110,3 +162,99 @@ var (
 	// ErrUserReject is reported by Edit if the user rejected the changed file.
 	ErrUserReject = errors.New("the user rejected the edits")
 )
+
+// SetFieldNames are the names of the record fields that can be modified by
+// SetField, in lexicographic order.
+var SetFieldNames = []string{"addr", "alias", "code", "detail", "host", "notes", "title", "username"}
+
+// SetField sets the specified field of r to value, and returns a
+// human-readable description of the change. The fields "title", "username",
+// and "notes" replace the existing value. The fields "host", "alias", and
+// "addr" add value to the existing list, if it is not already present. The
+// field "detail" has a value of the form "label:value", and replaces the value
+// of the detail with that label, or adds a new detail if there is none. The
+// field "code" adds an unused recovery code, if it is not already present.
+//
+// SetField reports an error if field is not one of SetFieldNames, or if the
+// value is invalid for that field.
+func SetField(r *kfdb.Record, field, value string) (string, error) {
+	setString := func(p *string) string {
+		old := *p
+		*p = value
+		return fmt.Sprintf("%s: %q → %q", field, old, value)
+	}
+	addString := func(p *kfdb.Strings) string {
+		if slices.Contains(*p, value) {
+			return fmt.Sprintf("%s: %q already present", field, value)
+		}
+		*p = append(*p, value)
+		return fmt.Sprintf("%s: added %q", field, value)
+	}
+	switch field {
+	case "title":
+		return setString(&r.Title), nil
+	case "username":
+		return setString(&r.Username), nil
+	case "notes":
+		return setString(&r.Notes), nil
+	case "host":
+		if err := checkHost(value); err != nil {
+			return "", fmt.Errorf("invalid host %q: %w", value, err)
+		}
+		return addString(&r.Hosts), nil
+	case "alias":
+		if err := checkHost(value); err != nil {
+			return "", fmt.Errorf("invalid alias %q: %w", value, err)
+		}
+		return addString(&r.Aliases), nil
+	case "addr":
+		if value == "" {
+			return "", errors.New("empty address")
+		}
+		return addString(&r.Addrs), nil
+	case "code":
+		if value == "" {
+			return "", errors.New("empty recovery code")
+		} else if slices.ContainsFunc(r.RecoveryCodes, func(c *kfdb.RecoveryCode) bool { return c.Code == value }) {
+			return "code: already present", nil
+		}
+		r.RecoveryCodes = append(r.RecoveryCodes, &kfdb.RecoveryCode{Code: value})
+		return "code: added", nil
+	case "detail":
+		label, text, ok := strings.Cut(value, ":")
+		if !ok || label == "" {
+			return "", fmt.Errorf("invalid detail %q, want label:value", value)
+		}
+		for _, d := range r.Details {
+			if strings.EqualFold(d.Label, label) {
+				d.Value = text
+				return fmt.Sprintf("detail %q: updated", d.Label), nil
+			}
+		}
+		r.Details = append(r.Details, &kfdb.Detail{Label: label, Value: text})
+		return fmt.Sprintf("detail %q: added", label), nil
+	default:
+		return "", fmt.Errorf("unknown field %q (settable: %s)", field, strings.Join(SetFieldNames, ", "))
+	}
+}
+
+// ApplyTemplate adds the tags and details of the template with the given name
+// in the defaults of db to rec. Details whose labels rec already has are not
+// added. ApplyTemplate reports an error if db has no template with that name.
+func ApplyTemplate(db *kfdb.DB, rec *kfdb.Record, name string) error {
+	tmpl, ok := value.At(db.Defaults).Templates[name]
+	if !ok || tmpl == nil {
+		return fmt.Errorf("no template named %q", name)
+	}
+	rec.Tags = append(rec.Tags, tmpl.Tags...)
+	rec.Normalize()
+	for _, d := range tmpl.Details {
+		if !slices.ContainsFunc(rec.Details, func(old *kfdb.Detail) bool {
+			return strings.EqualFold(old.Label, d.Label)
+		}) {
+			cp := *d
+			rec.Details = append(rec.Details, &cp)
+		}
+	}
+	return nil
+}
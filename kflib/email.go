@@ -0,0 +1,59 @@
+package kflib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/keyfish/kfdb"
+	"github.com/creachadair/mds/value"
+)
+
+// InsertAddressTag returns the e-mail address addr with tag inserted as a
+// plus-address tag, for example:
+//
+//	InsertAddressTag("user@example.com", "shop") == "user+shop@example.com"
+//
+// If the local part of addr already has a tag, it is replaced. If tag == "",
+// addr is returned with any existing tag removed. It reports an error if addr
+// is not of the form local@domain, or if tag contains characters that are
+// not valid in a tag.
+func InsertAddressTag(addr, tag string) (string, error) {
+	i := strings.LastIndex(addr, "@")
+	if i <= 0 || i == len(addr)-1 {
+		return "", fmt.Errorf("invalid e-mail address %q", addr)
+	} else if strings.ContainsAny(tag, "@+ \t\r\n") {
+		return "", fmt.Errorf("invalid address tag %q", tag)
+	}
+	local, domain := addr[:i], addr[i:]
+	if base, _, ok := strings.Cut(local, "+"); ok {
+		local = base
+	}
+	if tag == "" {
+		return local + domain, nil
+	}
+	return local + "+" + tag + domain, nil
+}
+
+// TaggedAddress returns the default e-mail address of db with tag inserted
+// as a plus-address tag (see InsertAddressTag). It reports an error if db
+// does not have a default e-mail address.
+func TaggedAddress(db *kfdb.DB, tag string) (string, error) {
+	base := value.At(db.Defaults).EMail
+	if base == "" {
+		return "", errors.New("no default e-mail address is set")
+	}
+	return InsertAddressTag(base, tag)
+}
+
+// RecordAddress returns the effective e-mail address of rec in db. This is
+// the first address of the record, if it has any; otherwise the default
+// e-mail address of db. It reports an error if neither is set.
+func RecordAddress(db *kfdb.DB, rec *kfdb.Record) (string, error) {
+	if len(rec.Addrs) != 0 {
+		return rec.Addrs[0], nil
+	} else if base := value.At(db.Defaults).EMail; base != "" {
+		return base, nil
+	}
+	return "", fmt.Errorf("record %q has no e-mail address", rec.Label)
+}
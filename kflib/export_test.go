@@ -0,0 +1,16 @@
+package kflib
+
+import "github.com/creachadair/keyfish/kfdb"
+
+// PickRecordFrom exposes the implementation of PickRecord to tests, with the
+// input and output streams as parameters.
+var PickRecordFrom = pickRecord
+
+// VerifierCost exposes the bcrypt cost of password verifiers to tests.
+var VerifierCost = &verifierCost
+
+// DecodeEditedDB exposes the decoding step of Edit for databases to tests.
+var DecodeEditedDB = decodeEdited[*kfdb.DB]
+
+// DecodeEditedRecord exposes the decoding step of Edit for records to tests.
+var DecodeEditedRecord = decodeEdited[*kfdb.Record]
@@ -0,0 +1,104 @@
+package kflib
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/keyfish/kfdb"
+)
+
+// FieldNames are the names of the record fields that can be resolved by
+// RecordField, in lexicographic order. A name ending in ":" takes an argument.
+var FieldNames = []string{
+	"addr", "addr:", "detail:", "email", "host", "notes", "otp", "password", "title", "username",
+}
+
+// RecordField returns the value of the named field of rec, and reports
+// whether that value is a secret.  The names are:
+//
+//	username   -- the username
+//	email      -- the first e-mail address (also "addr")
+//	addr:<n>   -- the e-mail address at offset n (0-based)
+//	host       -- the first host name
+//	title      -- the title
+//	notes      -- the notes
+//	password   -- the stored password, or else the hashpass for tag (secret)
+//	otp        -- the current OTP code (secret)
+//	detail:<l> -- the detail whose label matches l, ignoring case (secret if
+//	              the detail is hidden)
+//
+// RecordField reports an error if the name is unknown, or if the record has
+// no value for the named field.
+func RecordField(db *kfdb.DB, rec *kfdb.Record, tag, name string) (value string, secret bool, _ error) {
+	nonEmpty := func(s string, secret bool) (string, bool, error) {
+		if s == "" {
+			return "", false, fmt.Errorf("record %q has no %s", rec.Label, name)
+		}
+		return s, secret, nil
+	}
+	if arg, ok := strings.CutPrefix(name, "detail:"); ok {
+		var found *kfdb.Detail
+		for _, d := range rec.Details {
+			if !strings.EqualFold(d.Label, arg) {
+				continue
+			} else if found != nil {
+				return "", false, fmt.Errorf("ambiguous detail %q", arg)
+			}
+			found = d
+		}
+		if found == nil {
+			return "", false, fmt.Errorf("no detail matching %q", arg)
+		}
+		return found.Value, found.Hidden, nil
+	}
+	if arg, ok := strings.CutPrefix(name, "addr:"); ok {
+		i, err := strconv.Atoi(arg)
+		if err != nil || i < 0 {
+			return "", false, fmt.Errorf("invalid address offset %q", arg)
+		} else if i >= len(rec.Addrs) {
+			return "", false, fmt.Errorf("record %q has no address at offset %d", rec.Label, i)
+		}
+		return rec.Addrs[i], false, nil
+	}
+
+	switch name {
+	case "username":
+		return nonEmpty(rec.Username, false)
+	case "email", "addr":
+		if len(rec.Addrs) == 0 {
+			return nonEmpty("", false)
+		}
+		return rec.Addrs[0], false, nil
+	case "host":
+		if len(rec.Hosts) == 0 {
+			return nonEmpty("", false)
+		}
+		return rec.Hosts[0], false, nil
+	case "title":
+		return nonEmpty(rec.Title, false)
+	case "notes":
+		return nonEmpty(rec.Notes, false)
+	case "password":
+		if rec.Password != "" {
+			return rec.Password, true, nil
+		}
+		pw, err := GenerateHashpass(db, rec, tag)
+		if err != nil {
+			return "", false, err
+		}
+		return pw, true, nil
+	case "otp":
+		if rec.OTP == nil {
+			return "", false, errors.New("no OTP configuration")
+		}
+		otp, err := GenerateOTPWithDefaults(db, rec.OTP, 0)
+		if err != nil {
+			return "", false, err
+		}
+		return otp, true, nil
+	default:
+		return "", false, fmt.Errorf("unknown field %q (known: %s)", name, strings.Join(FieldNames, ", "))
+	}
+}
@@ -0,0 +1,324 @@
+package kflib
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/keyfish/kfdb"
+	"github.com/creachadair/otp"
+	"github.com/creachadair/otp/otpauth"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// csvFormat describes the columns of a CSV export from another password
+// manager. Each field lists the (case-insensitive) column names that may be
+// used for the corresponding record field.
+type csvFormat struct {
+	title, url, username, password, notes, totp []string
+}
+
+// csvFormats are the supported CSV import formats, indexed by name.
+var csvFormats = map[string]csvFormat{
+	"bitwarden-csv": {
+		title:    []string{"name"},
+		url:      []string{"login_uri"},
+		username: []string{"login_username"},
+		password: []string{"login_password"},
+		notes:    []string{"notes"},
+		totp:     []string{"login_totp"},
+	},
+	"1password-csv": {
+		title:    []string{"title"},
+		url:      []string{"url", "website", "urls"},
+		username: []string{"username"},
+		password: []string{"password"},
+		notes:    []string{"notes", "notesplain"},
+		totp:     []string{"otpauth", "one-time password"},
+	},
+}
+
+// CSVFormats returns the names of the supported CSV import formats.
+func CSVFormats() []string { return slices.Sorted(maps.Keys(csvFormats)) }
+
+// ReadCSV reads records from a CSV export in the specified format, which must
+// be one of the names reported by CSVFormats. The input must begin with a
+// header row naming the columns. Each resulting record is assigned a label
+// derived from its title (see MakeLabel).
+//
+// If a record has a TOTP value, it may be either an otpauth URL or a bare
+// base32-encoded secret. Invalid TOTP values are reported as errors.
+func ReadCSV(r io.Reader, format string) ([]*kfdb.Record, error) {
+	cf, ok := csvFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown CSV format %q", format)
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // some exporters omit trailing empty fields
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	col := func(names []string) int {
+		for i, h := range header {
+			for _, name := range names {
+				if strings.EqualFold(strings.TrimSpace(h), name) {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	iTitle, iURL, iUser := col(cf.title), col(cf.url), col(cf.username)
+	iPass, iNotes, iTOTP := col(cf.password), col(cf.notes), col(cf.totp)
+	if iTitle < 0 {
+		return nil, fmt.Errorf("missing title column (%s)", strings.Join(cf.title, ", "))
+	}
+
+	var out []*kfdb.Record
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		rec := &kfdb.Record{
+			Title:    get(iTitle),
+			Username: get(iUser),
+			Password: get(iPass),
+			Notes:    get(iNotes),
+		}
+		for _, u := range strings.Split(get(iURL), ",") {
+			if host := hostFromURL(u); host != "" {
+				rec.Hosts = append(rec.Hosts, host)
+			}
+		}
+		if t := get(iTOTP); t != "" {
+			u, err := ParseOTP(nil, t, rec.Title, rec.Username)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid TOTP: %w", line, err)
+			}
+			rec.OTP = u
+		}
+		rec.Label = MakeLabel(rec.Title)
+		if rec.Label == "" && len(rec.Hosts) != 0 {
+			rec.Label = MakeLabel(rec.Hosts[0])
+		}
+		if rec.Label == "" {
+			return nil, fmt.Errorf("line %d: record has no title or host", line)
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// AddRecords appends to db each of recs whose label is not already used by a
+// record in db, or by an earlier element of recs. It returns the number of
+// records added and skipped.
+func AddRecords(db *kfdb.DB, recs []*kfdb.Record) (added, skipped int) {
+	seen := make(map[string]bool)
+	for _, r := range db.Records {
+		seen[r.Label] = true
+	}
+	for _, r := range recs {
+		if seen[r.Label] {
+			skipped++
+			continue
+		}
+		seen[r.Label] = true
+		db.Records = append(db.Records, r)
+		added++
+	}
+	return added, skipped
+}
+
+// ImportRecords adds recs to db as AddRecords does, skipping each record
+// whose label is already used in db or by an earlier element of recs. A
+// record without a label is first given one derived from its title (see
+// MakeLabel). The records to be added are checked with ValidateDB; if any of
+// them is invalid, ImportRecords reports the problems and db is not modified.
+func ImportRecords(db *kfdb.DB, recs []*kfdb.Record) (added, skipped int, err error) {
+	for _, r := range recs {
+		if r.Label == "" {
+			r.Label = MakeLabel(r.Title)
+		}
+	}
+	tmp := &kfdb.DB{Records: slices.Clone(db.Records)}
+	added, skipped = AddRecords(tmp, recs)
+	if errs := ValidateDB(&kfdb.DB{Records: tmp.Records[len(db.Records):]}); len(errs) != 0 {
+		return 0, 0, errors.Join(errs...)
+	}
+	db.Records = tmp.Records
+	return added, skipped, nil
+}
+
+// ReadRecordsJSON reads a JSON array of records from r.
+func ReadRecordsJSON(r io.Reader) ([]*kfdb.Record, error) {
+	var recs []*kfdb.Record
+	if err := json.NewDecoder(r).Decode(&recs); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode JSON: %w", err)
+	}
+	return recs, nil
+}
+
+// ReadRecordsYAML reads a YAML sequence of records from r.
+// Unknown fields in the input are reported as errors.
+func ReadRecordsYAML(r io.Reader) ([]*kfdb.Record, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	var recs []*kfdb.Record
+	if err := dec.Decode(&recs); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode YAML: %w", err)
+	}
+	return recs, nil
+}
+
+// MakeLabel returns a record label derived from s, consisting of the lowercase
+// letters and digits of s with other runs of characters replaced by "-".
+func MakeLabel(s string) string {
+	var sb strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(s) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			if dash && sb.Len() != 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return sb.String()
+}
+
+// hostFromURL returns the hostname of the URL s, or "" if s does not contain
+// a valid hostname. If s has no scheme, it is treated as an HTTPS URL.
+func hostFromURL(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	} else if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// ParseOTP parses s as an otpauth URL, or as a bare base32 TOTP secret. In
+// the latter case, the issuer and account are used to construct the URL, with
+// the algorithm, digits, and period taken from the OTP defaults of db (see
+// OTPWithDefaults); db may be nil. ParseOTP reports an error if the secret is
+// not valid.
+func ParseOTP(db *kfdb.DB, s, issuer, account string) (*otpauth.URL, error) {
+	var u *otpauth.URL
+	if strings.HasPrefix(s, "otpauth://") {
+		var err error
+		u, err = otpauth.ParseURL(s)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		u = OTPWithDefaults(db, &otpauth.URL{
+			Type:      "totp",
+			Issuer:    issuer,
+			Account:   account,
+			RawSecret: strings.ToUpper(strings.Join(strings.Fields(s), "")),
+		})
+	}
+	if _, err := otp.ParseKey(u.RawSecret); err != nil {
+		return nil, fmt.Errorf("invalid OTP secret: %w", err)
+	}
+	return u, nil
+}
+
+// ReadOTPURLs reads otpauth URLs from r, one per line. Blank lines are
+// ignored. If any line is not a valid URL, ReadOTPURLs reports an error for
+// each such line, identified by its line number.
+func ReadOTPURLs(r io.Reader) ([]*otpauth.URL, error) {
+	var urls []*otpauth.URL
+	var errs []error
+	sc := bufio.NewScanner(r)
+	for ln := 1; sc.Scan(); ln++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		u, err := otpauth.ParseURL(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", ln, err))
+			continue
+		}
+		urls = append(urls, u)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return urls, errors.Join(errs...)
+}
+
+// AddOTPs adds the OTP configurations in urls to db. A URL whose issuer
+// matches the label or title of an existing record that has no OTP config is
+// attached to that record; otherwise a new record is created for it. URLs
+// that would create a new record with the same label as an existing one are
+// skipped. It returns the number of records created and attached, and the
+// number of URLs skipped.
+func AddOTPs(db *kfdb.DB, urls []*otpauth.URL) (created, attached, skipped int) {
+	for _, u := range urls {
+		if r := findOTPTarget(db, u.Issuer); r != nil {
+			r.OTP = u
+			attached++
+			continue
+		}
+		rec := &kfdb.Record{
+			Label:    MakeLabel(cmp.Or(u.Issuer, u.Account)),
+			Title:    u.Issuer,
+			Username: u.Account,
+			OTP:      u,
+		}
+		if n, _ := AddRecords(db, []*kfdb.Record{rec}); n == 0 {
+			skipped++
+		} else {
+			created++
+		}
+	}
+	return created, attached, skipped
+}
+
+// findOTPTarget returns the first unarchived record of db without an OTP
+// config whose label or title matches issuer, or nil if there is none.
+func findOTPTarget(db *kfdb.DB, issuer string) *kfdb.Record {
+	if issuer == "" {
+		return nil
+	}
+	label := MakeLabel(issuer)
+	for _, r := range db.Records {
+		if r.OTP != nil || r.Archived {
+			continue
+		}
+		if r.Label == label || strings.EqualFold(r.Title, issuer) {
+			return r
+		}
+	}
+	return nil
+}
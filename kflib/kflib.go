@@ -2,16 +2,25 @@
 package kflib
 
 import (
+	"bufio"
 	"cmp"
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"log"
 	"os"
 	"slices"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/creachadair/atomicfile"
 	"github.com/creachadair/getpass"
@@ -49,14 +58,112 @@ func OpenDBWithPassphrase(dbPath, passphrase string) (*kfdb.Store, error) {
 	return kfdb.Open(f, passphrase)
 }
 
-// SaveDB writes the specified database store to dbPath.
+// SaveDB writes the specified database store to dbPath. Any records that do
+// not have a UID are assigned one, and all records are normalized (see
+// [kfdb.DB.Normalize]) before saving.
 func SaveDB(s *kfdb.Store, dbPath string) error {
+	s.Update(func(db *kfdb.DB) {
+		db.AssignUIDs()
+		db.Normalize()
+	})
 	return atomicfile.Tx(dbPath, 0600, func(f *atomicfile.File) error {
 		_, err := s.WriteTo(f)
 		return err
 	})
 }
 
+// ChangePassphrase returns a new store with the same database contents as s,
+// but encrypted with an access key generated from newPassphrase. The new
+// store uses the same compression level as s. The caller is responsible for
+// saving the result, e.g., with [SaveDB].
+func ChangePassphrase(s *kfdb.Store, newPassphrase string) (*kfdb.Store, error) {
+	s2, err := kfdb.New(newPassphrase, s.DB())
+	if err != nil {
+		return nil, fmt.Errorf("change passphrase: %w", err)
+	} else if err := s2.SetCompression(s.Compression()); err != nil {
+		return nil, fmt.Errorf("change passphrase: %w", err)
+	}
+	return s2, nil
+}
+
+// CompactDB returns a new store with the same records as s, in canonical form
+// and encrypted with a fresh data key under passphrase, which must be the
+// passphrase for s. Records are normalized (see [kfdb.Record.Normalize]) and
+// sorted by label, empty hashpass settings and details are dropped, and empty
+// lists are cleared. The database of s is not modified: The new store has
+// its own copy, and uses the same compression level as s. The caller is
+// responsible for saving the result, e.g., with [SaveDB].
+func CompactDB(s *kfdb.Store, passphrase string) (*kfdb.Store, error) {
+	db, err := s.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("compact database: %w", err)
+	}
+	for _, r := range db.Records {
+		r.Normalize()
+		if h := r.Hashpass; h != nil && h.SecretKey == "" && h.Seed == "" &&
+			h.Length == 0 && h.Punct == nil && len(h.Alphabet) == 0 {
+			r.Hashpass = nil
+		}
+		r.Details = slices.DeleteFunc(r.Details, func(d *kfdb.Detail) bool {
+			return d.Label == "" && d.Value == ""
+		})
+		r.Tags = nilIfEmpty(r.Tags)
+		r.Hosts = nilIfEmpty(r.Hosts)
+		r.Aliases = nilIfEmpty(r.Aliases)
+		r.Addrs = nilIfEmpty(r.Addrs)
+		r.Details = nilIfEmpty(r.Details)
+	}
+	slices.SortStableFunc(db.Records, func(a, b *kfdb.Record) int {
+		return cmp.Compare(a.Label, b.Label)
+	})
+	s2, err := kfdb.New(passphrase, db)
+	if err != nil {
+		return nil, fmt.Errorf("compact database: %w", err)
+	} else if err := s2.SetCompression(s.Compression()); err != nil {
+		return nil, fmt.Errorf("compact database: %w", err)
+	}
+	return s2, nil
+}
+
+func nilIfEmpty[T ~[]E, E any](s T) T {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// CloneRecord returns a deep copy of rec with the given label, for a new
+// account sharing the configuration of rec. Secrets specific to the account
+// are not copied: The stored password and password verifier, OTP
+// configuration, recovery codes, and attachments are cleared. The copy has no
+// UID, and is not archived.
+//
+// If rec has a hashpass configuration, the copy uses label as its seed, so
+// that it does not generate the same password as rec.
+func CloneRecord(rec *kfdb.Record, label string) (*kfdb.Record, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("clone record: %w", err)
+	}
+	cp := new(kfdb.Record)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("clone record: %w", err)
+	}
+	cp.Label = label
+	cp.UID = ""
+	cp.Archived = false
+	cp.Password = ""
+	cp.PasswordSetAt = nil
+	cp.PasswordHash = ""
+	cp.OTP = nil
+	cp.RecoveryCodes = nil
+	cp.Attachments = nil
+	if cp.Hashpass != nil {
+		cp.Hashpass.Seed = label
+	}
+	return cp, nil
+}
+
 // GetPassphrase prompts the user at the terminal for a passphrase with echo
 // disabled.  An empty passprase is permitted; the caller must check for that
 // case if an empty passphrase is not wanted.
@@ -86,17 +193,133 @@ func ConfirmPassphrase(prompt string) (string, error) {
 	return passphrase, nil
 }
 
+// Confirm prompts the user at the terminal with a yes/no question, and reports
+// whether they answered yes. It reports an error if the response could not be
+// read, or was not recognizable as yes or no.
+func Confirm(prompt string) (bool, error) {
+	fmt.Fprint(os.Stderr, prompt, " (y/n) ")
+	ln, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("read response: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(ln)) {
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid response %q", strings.TrimSpace(ln))
+	}
+}
+
+var (
+	// ErrNoSecret is reported by GenerateOTP if the OTP configuration does not
+	// have a secret, and so cannot generate codes.
+	ErrNoSecret = errors.New("OTP configuration has no secret")
+
+	// ErrInvalidSecret is reported by GenerateOTP if the secret of the OTP
+	// configuration is malformed.
+	ErrInvalidSecret = errors.New("invalid OTP secret")
+)
+
 // GenerateOTP returns a TOTP code based on url.  The time code is shifted by
-// offset steps (based on the size of the window specified by url).
+// offset steps (based on the size of the window specified by url).  If url
+// does not specify an algorithm, digits, or period, the standard defaults are
+// used (see OTPWithDefaults).
+//
+// If url is nil or has no secret, GenerateOTP reports ErrNoSecret. If the
+// secret is malformed, the error wraps ErrInvalidSecret.
 func GenerateOTP(url *otpauth.URL, offset int) (string, error) {
-	step := (time.Now().Unix() / int64(url.Period)) + int64(offset)
-	cfg := otp.Config{Digits: url.Digits}
-	if err := cfg.ParseKey(url.RawSecret); err != nil {
+	return GenerateOTPWithDefaults(nil, url, offset)
+}
+
+// GenerateOTPWithDefaults is as GenerateOTP, but if url does not specify an
+// algorithm, digits, or period, the OTP defaults of db are used in preference
+// to the standard defaults. The db may be nil.
+func GenerateOTPWithDefaults(db *kfdb.DB, url *otpauth.URL, offset int) (string, error) {
+	if url == nil || strings.TrimSpace(url.RawSecret) == "" {
+		return "", ErrNoSecret
+	}
+	u := OTPWithDefaults(db, url)
+	hash, err := otpHash(u.Algorithm)
+	if err != nil {
 		return "", err
 	}
+	step := (time.Now().Unix() / int64(u.Period)) + int64(offset)
+	cfg := otp.Config{Hash: hash, Digits: u.Digits}
+	if err := cfg.ParseKey(u.RawSecret); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSecret, err)
+	}
 	return cfg.HOTP(uint64(step)), nil
 
-	// TODO(creachadair): Other algorithms, HOTP.
+	// TODO(creachadair): HOTP.
+}
+
+// MatchOTP reports the time step offset, relative to the current time, at
+// which url generates code, searching offsets from -window to +window in
+// order of increasing distance from the current step. If no offset in that
+// range matches, MatchOTP reports false.
+func MatchOTP(db *kfdb.DB, url *otpauth.URL, code string, window int) (int, bool, error) {
+	for d := range window + 1 {
+		for _, off := range []int{-d, d} {
+			got, err := GenerateOTPWithDefaults(db, url, off)
+			if err != nil {
+				return 0, false, err
+			} else if got == code {
+				return off, true, nil
+			}
+			if d == 0 {
+				break
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// OTPWithDefaults returns a copy of url in which an empty algorithm, digits,
+// or period is replaced by the corresponding OTP default of db, if it has one,
+// or otherwise by the standard default (SHA1, 6 digits, 30 seconds).  The db
+// may be nil, in which case only the standard defaults are used.
+func OTPWithDefaults(db *kfdb.DB, url *otpauth.URL) *otpauth.URL {
+	var d kfdb.OTPDefaults
+	if db != nil {
+		d = value.At(value.At(db.Defaults).OTP)
+	}
+	u := *url
+	u.Algorithm = strings.ToUpper(cmp.Or(u.Algorithm, d.Algorithm, "SHA1"))
+	u.Digits = cmp.Or(u.Digits, d.Digits, 6)
+	u.Period = cmp.Or(u.Period, d.Period, 30)
+	return &u
+}
+
+// ExportOTP returns a copy of url suitable for import into another
+// authenticator, with its algorithm, digits, and period filled in as by
+// OTPWithDefaults. An empty issuer is filled in from the title of rec, or its
+// label; an empty account name is filled in from the username of rec, its
+// first e-mail address, or its label.
+func ExportOTP(db *kfdb.DB, rec *kfdb.Record, url *otpauth.URL) *otpauth.URL {
+	u := OTPWithDefaults(db, url)
+	u.Issuer = cmp.Or(u.Issuer, rec.Title, rec.Label)
+	var addr string
+	if len(rec.Addrs) != 0 {
+		addr = rec.Addrs[0]
+	}
+	u.Account = cmp.Or(u.Account, rec.Username, addr, rec.Label)
+	return u
+}
+
+// otpHash returns the hash constructor for the named OTP algorithm.
+func otpHash(alg string) (func() hash.Hash, error) {
+	switch alg {
+	case "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported OTP algorithm %q", alg)
+	}
 }
 
 // FindResult is the result of a successful call to FindRecord.
@@ -116,10 +339,12 @@ const (
 	// MatchLabel means the query matches the record's label.
 	MatchLabel
 
-	// MatchHost means the query is an exact host match for the record.
+	// MatchHost means the query is an exact match for a host or alias of the
+	// record.
 	MatchHost
 
-	// MatchHostPartial means the query is a partial host match for the record.
+	// MatchHostPartial means the query is a partial match for a host or alias
+	// of the record.
 	MatchHostPartial
 
 	// MatchTitle means the query is a case-insensitive substring match for the
@@ -131,8 +356,13 @@ const (
 	MatchDetail
 
 	// MatchSubstring means the query is a case-insensitive substring match for
-	// one of the text fields or host entries of the record.
+	// one of the text fields, hosts, or aliases of the record.
 	MatchSubstring
+
+	// MatchFuzzy means the query is within a small edit distance of the label
+	// of the record, or of a word of its title or a component of one of its
+	// hosts or aliases.
+	MatchFuzzy
 )
 
 // MatchRecord reports how good a match query is for the specified record.
@@ -140,18 +370,16 @@ func MatchRecord(query string, r *kfdb.Record) MatchQuality {
 	if r.Label != "" && query == r.Label {
 		return MatchLabel
 	}
+	hosts := slices.Concat(r.Hosts, r.Aliases)
+	if slices.Contains(hosts, query) {
+		return MatchHost
+	}
 	if strings.Contains(query, ".") {
-		var isPartial bool
-		for _, h := range r.Hosts {
-			if query == h {
-				return MatchHost
-			} else if strings.HasSuffix(h, "."+query) {
-				isPartial = true
+		for _, h := range hosts {
+			if strings.HasSuffix(h, "."+query) {
+				return MatchHostPartial
 			}
 		}
-		if isPartial {
-			return MatchHostPartial
-		}
 	}
 
 	sub := strings.ToLower(query)
@@ -166,30 +394,155 @@ func MatchRecord(query string, r *kfdb.Record) MatchQuality {
 	if strings.Contains(strings.ToLower(r.Notes), sub) {
 		return MatchSubstring
 	}
-	for _, h := range r.Hosts {
+	for _, h := range hosts {
 		if strings.Contains(h, query) {
 			return MatchSubstring
 		}
 	}
+	if fuzzyMatch(sub, r) {
+		return MatchFuzzy
+	}
 	return MatchNone
 }
 
+// fuzzyMatch reports whether query is within a small edit distance of the
+// label of r, a word of its title, or a component of one of its hosts.  The
+// permitted distance is scaled by the length of the query, and queries shorter
+// than 4 characters are not matched at all, so that short queries do not
+// match too many records.
+func fuzzyMatch(query string, r *kfdb.Record) bool {
+	n := utf8.RuneCountInString(query)
+	if n < 4 {
+		return false
+	}
+	limit := value.Cond(n < 8, 1, 2)
+	return wordDistance(query, r, limit) <= limit
+}
+
+// wordDistance returns the smallest edit distance between query and the label
+// of r, a word of its title, or a component of one of its hosts or aliases.
+// If that distance exceeds limit, wordDistance returns limit+1.
+func wordDistance(query string, r *kfdb.Record, limit int) int {
+	isWordSep := func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }
+
+	words := append([]string{strings.ToLower(r.Label)}, strings.FieldsFunc(strings.ToLower(r.Title), isWordSep)...)
+	for _, h := range slices.Concat(r.Hosts, r.Aliases) {
+		words = append(words, strings.Split(h, ".")...)
+	}
+	best := limit + 1
+	for _, w := range words {
+		if w != "" {
+			best = min(best, editDistance(query, w, limit))
+		}
+	}
+	return best
+}
+
+// SuggestRecords returns up to n records that are near misses for query, for
+// use in reporting a search that found no matches. A record is a near miss if
+// query is within a few edits of its label, a word of its title, or a
+// component of one of its hosts or aliases. The results are ordered from closest to
+// farthest, with ties broken by label.
+func SuggestRecords(recs []*kfdb.Record, query string, n int) []*kfdb.Record {
+	if _, rest, ok := strings.Cut(query, "@"); ok {
+		query = rest
+	}
+	query = strings.ToLower(query)
+	limit := min(max(utf8.RuneCountInString(query)/2, 1), 3)
+
+	type cand struct {
+		dist int
+		rec  *kfdb.Record
+	}
+	var cands []cand
+	for _, r := range recs {
+		if d := wordDistance(query, r, limit); d <= limit {
+			cands = append(cands, cand{d, r})
+		}
+	}
+	slices.SortFunc(cands, func(a, b cand) int {
+		return cmp.Or(cmp.Compare(a.dist, b.dist), cmp.Compare(a.rec.Label, b.rec.Label))
+	})
+	out := make([]*kfdb.Record, 0, min(n, len(cands)))
+	for _, c := range cands[:min(n, len(cands))] {
+		out = append(out, c.rec)
+	}
+	return out
+}
+
+// editDistance returns the optimal string alignment distance between a and b,
+// that is, the number of single-rune insertions, deletions, substitutions, and
+// transpositions of adjacent runes needed to transform a into b.  If the
+// distance exceeds limit, editDistance may return any value greater than
+// limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+	// Keep three rows of the distance matrix: d2 (i-2), d1 (i-1), and d0 (i).
+	d2, d1, d0 := make([]int, len(rb)+1), make([]int, len(rb)+1), make([]int, len(rb)+1)
+	for j := range d1 {
+		d1[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		d0[0] = i
+		rowMin := d0[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := value.Cond(ra[i-1] == rb[j-1], 0, 1)
+			d0[j] = min(d1[j]+1, d0[j-1]+1, d1[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d0[j] = min(d0[j], d2[j-2]+1)
+			}
+			rowMin = min(rowMin, d0[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		d2, d1, d0 = d1, d0, d2
+	}
+	return d1[len(rb)]
+}
+
 // FindRecord finds the unique record matching the specified query.  An exact
 // match for a label is preferred; otherwise FindRecord will look for a full or
 // partial match on host names, or other substrings in the title and notes. An
-// error is reported if query matches no records, or more than 1.  If all is
+// error is reported if query matches no records, or more than 1; in the
+// latter case the error has concrete type *MultipleMatchError.  If all is
 // true, all records are considered; otherwise archived records are skipped.
 //
 // If the query begins with a tag (tag@label), the tag is removed and returned
 // along with the result.
+//
+// FindRecord is equivalent to FindRecordMin(db, query, all, MatchFuzzy).
 func FindRecord(db *kfdb.DB, query string, all bool) (FindResult, error) {
-	found := FindRecords(db.Records, query)
+	return FindRecordMin(db, query, all, MatchFuzzy)
+}
+
+// FindRecordMin is as FindRecord, but selects only a record matched with at
+// least the quality of minQuality (see FindRecordsMin). If no record matches
+// that well, the error suggests any near misses. Commands that modify the
+// database should use MatchSubstring, so that a typo in the query does not
+// select a record by a fuzzy match.
+func FindRecordMin(db *kfdb.DB, query string, all bool, minQuality MatchQuality) (FindResult, error) {
+	found := FindRecordsMin(db.Records, query, minQuality)
 	if !all {
 		found = slice.Partition(found, func(r FoundRecord) bool {
 			return !r.Record.Archived
 		})
 	}
 	if len(found) == 0 {
+		recs := db.Records
+		if !all {
+			recs = slices.DeleteFunc(slices.Clone(recs), func(r *kfdb.Record) bool { return r.Archived })
+		}
+		var hints []string
+		for _, r := range SuggestRecords(recs, query, 3) {
+			hints = append(hints, cmp.Or(r.Label, r.Title))
+		}
+		if len(hints) != 0 {
+			return FindResult{}, fmt.Errorf("no matches for %q (did you mean %s?)", query, strings.Join(hints, ", "))
+		}
 		return FindResult{}, fmt.Errorf("no matches for %q", query)
 	}
 	tag, _, ok := strings.Cut(query, "@")
@@ -206,21 +559,35 @@ func FindRecord(db *kfdb.DB, query string, all bool) (FindResult, error) {
 	}
 
 	// At this point there was no unique match, report a diagnostic error.
+	return FindResult{}, &MultipleMatchError{Query: query, Tag: tag, Found: found}
+}
+
+// MultipleMatchError is the error reported by FindRecord when a query matches
+// more than one record, and none of them is a unique best match.
+type MultipleMatchError struct {
+	Query string        // the query as given
+	Tag   string        // the tag from the query, if any
+	Found []FoundRecord // the candidate records, best first
+}
+
+// Error satisfies the error interface. The message lists the labels of up to
+// six of the candidates.
+func (e *MultipleMatchError) Error() string {
 	var hits []string
-	for _, fr := range found {
+	for _, fr := range e.Found {
 		hits = append(hits, cmp.Or(fr.Record.Label, fr.Record.Title))
 		if len(hits) > 5 {
 			hits = append(hits, "...")
 			break
 		}
 	}
-	return FindResult{}, fmt.Errorf("found %d matches for %q (%s)",
-		len(found), query, strings.Join(hits, ", "))
+	return fmt.Sprintf("found %d matches for %q (%s)", len(e.Found), e.Query, strings.Join(hits, ", "))
 }
 
 // PickBest reports whether there is a unique "best" match in a slice of found
 // records, and if so returns that specific record. The records must be ordered
-// in decreasing order of match quality.
+// in decreasing order of match quality.  Among records of the same quality, a
+// single favorite record is preferred over the others.
 func PickBest(found []FoundRecord) (FoundRecord, bool) {
 	pos := 0
 	for pos < len(found) {
@@ -231,6 +598,12 @@ func PickBest(found []FoundRecord) (FoundRecord, bool) {
 		if end-pos == 1 {
 			return found[pos], true
 		}
+		favs := slices.DeleteFunc(slices.Clone(found[pos:end]), func(fr FoundRecord) bool {
+			return !fr.Record.Favorite
+		})
+		if len(favs) == 1 {
+			return favs[0], true
+		}
 		pos = end
 	}
 	return FoundRecord{}, false
@@ -246,8 +619,18 @@ type FoundRecord struct {
 // FindRecords finds candidate records matching the specified query.  If the
 // query begins with a tag (tag@label), the tag is removed.  Results are
 // returned in order of quality from highest to lowest, with ties broken by
-// index.
+// placing favorite records first, and then by index.
+//
+// This is equivalent to FindRecordsMin(recs, query, MatchFuzzy).
 func FindRecords(recs []*kfdb.Record, query string) []FoundRecord {
+	return FindRecordsMin(recs, query, MatchFuzzy)
+}
+
+// FindRecordsMin is as FindRecords, but reports only records matched with at
+// least the quality of minQuality. For example, with minQuality == MatchHost,
+// only exact label and host matches are reported. Note that better matches
+// have lower MatchQuality values, with MatchLabel the best.
+func FindRecordsMin(recs []*kfdb.Record, query string, minQuality MatchQuality) []FoundRecord {
 	if _, rest, ok := strings.Cut(query, "@"); ok {
 		query = rest
 	}
@@ -255,7 +638,7 @@ func FindRecords(recs []*kfdb.Record, query string) []FoundRecord {
 	var out []FoundRecord
 	for i, r := range recs {
 		m := MatchRecord(query, r)
-		if m == MatchNone {
+		if m == MatchNone || m > minQuality {
 			continue
 		}
 		out = append(out, FoundRecord{
@@ -267,6 +650,8 @@ func FindRecords(recs []*kfdb.Record, query string) []FoundRecord {
 	slices.SortFunc(out, func(a, b FoundRecord) int {
 		if c := cmp.Compare(a.Quality, b.Quality); c != 0 {
 			return c
+		} else if a.Record.Favorite != b.Record.Favorite {
+			return value.Cond(a.Record.Favorite, -1, 1)
 		}
 		return cmp.Compare(a.Index, b.Index)
 	})
@@ -274,15 +659,27 @@ func FindRecords(recs []*kfdb.Record, query string) []FoundRecord {
 }
 
 type hashpassConfig struct {
-	Secret  string
-	Tag     string
-	Seed    string
-	Length  int
-	Charset Charset
+	Secret   string
+	Tag      string
+	Seed     string
+	Length   int
+	Alphabet string
 }
 
 func (h hashpassConfig) Generate() string {
-	return HashedChars(h.Length, h.Charset, h.Secret, h.Seed, h.Tag)
+	pw, err := HashedCharsAlphabet(h.Length, h.Alphabet, h.Secret, h.Seed, h.Tag)
+	if err != nil {
+		panic(err) // the alphabet is checked by getHashpassConfig
+	}
+	return pw
+}
+
+// canonical returns a copy of h with settings that do not affect the generated
+// password normalized, so that two configs generate the same password exactly
+// when their canonical forms are equal.
+func (h hashpassConfig) canonical() hashpassConfig {
+	h.Length = max(h.Length, minHashLength)
+	return h
 }
 
 func getHashpassConfig(db *kfdb.DB, rec *kfdb.Record, tag string) (out hashpassConfig, _ error) {
@@ -309,25 +706,82 @@ func getHashpassConfig(db *kfdb.DB, rec *kfdb.Record, tag string) (out hashpassC
 		return out, fmt.Errorf("no hashpass seed is available")
 	}
 
-	// Charset
-	out.Charset = AllChars
-	if h.Punct != nil {
-		if !*h.Punct {
-			out.Charset &^= Symbols // punctuation is disabled for this record
+	// Alphabet: The record settings take precedence over the defaults, and
+	// within each, an explicit alphabet takes precedence over Punct.  An
+	// explicit Punct on the record overrides the default in either direction,
+	// so a record may re-enable punctuation that the defaults disable.
+	var specs []string
+	punct := true
+	switch {
+	case len(h.Alphabet) != 0:
+		specs = h.Alphabet
+	case h.Punct != nil:
+		punct = *h.Punct
+	case len(dh.Alphabet) != 0:
+		specs = dh.Alphabet
+	case dh.Punct != nil:
+		punct = *dh.Punct
+	}
+	charset := AllChars
+	if !punct {
+		charset &^= Symbols
+	}
+	if len(specs) != 0 {
+		alpha, err := ParseAlphabet(specs)
+		if err != nil {
+			return out, fmt.Errorf("invalid hashpass alphabet: %w", err)
 		}
-	} else if dh.Punct != nil && !*dh.Punct {
-		out.Charset &^= Symbols // punctuation is disabled by default
+		out.Alphabet = alpha
+	} else {
+		out.Alphabet = charset.Alphabet()
 	}
 	return out, nil
 }
 
-// GenerateHashpass hashpass password for the specified record in the given
-// database. It reports an error if no hashpass secret is available.  will be
+// HashpassSettings are the effective settings used to generate a hashpass
+// password for a record, after merging the record's hashpass config with the
+// database defaults. The secret key is not included.
+type HashpassSettings struct {
+	Seed     string `json:"seed" yaml:"seed"`
+	Tag      string `json:"tag,omitempty" yaml:"tag,omitempty"`
+	Length   int    `json:"length" yaml:"length"`
+	Alphabet string `json:"alphabet" yaml:"alphabet"`
+}
+
+// EffectiveHashpass returns the effective hashpass settings for the specified
+// record in the given database, as used by GenerateHashpass with the same
+// arguments. It reports an error if no hashpass password can be generated for
+// the record.
+func EffectiveHashpass(db *kfdb.DB, rec *kfdb.Record, tag string) (*HashpassSettings, error) {
+	hc, err := getHashpassConfig(db, rec, tag)
+	if err != nil {
+		return nil, err
+	}
+	hc = hc.canonical()
+	return &HashpassSettings{
+		Seed:     hc.Seed,
+		Tag:      hc.Tag,
+		Length:   hc.Length,
+		Alphabet: hc.Alphabet,
+	}, nil
+}
+
+// GenerateHashpass generates a hashpass password for the specified record in
+// the given database. It reports an error if no hashpass secret is available.
 func GenerateHashpass(db *kfdb.DB, rec *kfdb.Record, tag string) (string, error) {
+	return GenerateHashpassLength(db, rec, tag, 0)
+}
+
+// GenerateHashpassLength is as GenerateHashpass, but if length > 0 it
+// overrides the password length configured by the record and the defaults.
+func GenerateHashpassLength(db *kfdb.DB, rec *kfdb.Record, tag string, length int) (string, error) {
 	hc, err := getHashpassConfig(db, rec, tag)
 	if err != nil {
 		return "", err
 	}
+	if length > 0 {
+		hc.Length = length
+	}
 	return hc.Generate(), nil
 }
 
@@ -341,8 +795,28 @@ type DBWatcher struct {
 	μ         sync.Mutex
 	store     *kfdb.Store
 	hasUpdate bool
+	loadErr   error // the error from the last reload attempt, if any
+
+	// OnUpdate, if non-nil, is called by Run with the new store each time Run
+	// successfully reloads the database. If OnUpdate is nil, Run does not
+	// reload the database itself, and updates are loaded lazily by Store.
+	//
+	// OnUpdate is called synchronously from the goroutine executing Run, and
+	// without holding any locks, so it is safe for it to call methods of w.
+	// However, no further updates are processed until it returns. OnUpdate
+	// must be set before Run is called, and not modified thereafter.
+	OnUpdate func(*kfdb.Store)
+
+	// Debounce is how long Run waits after a change to the database file
+	// before it reports an update, so that a burst of changes results in a
+	// single reload. If zero, DefaultDebounce is used. Debounce must be set
+	// before Run is called, and not modified thereafter.
+	Debounce time.Duration
 }
 
+// DefaultDebounce is the default interval for DBWatcher.Debounce.
+const DefaultDebounce = 200 * time.Millisecond
+
 // NewDBWatcher creates a watcher that automatically reloads the specified
 // store from its original path when that path is modified.
 func NewDBWatcher(s *kfdb.Store, dbPath, passphrase string) (*DBWatcher, error) {
@@ -358,51 +832,132 @@ func NewDBWatcher(s *kfdb.Store, dbPath, passphrase string) (*DBWatcher, error)
 func (w *DBWatcher) Store() *kfdb.Store {
 	w.μ.Lock()
 	defer w.μ.Unlock()
+	w.reloadLocked()
+	return w.store
+}
 
-	for w.hasUpdate {
-		f, err := os.Open(w.path)
-		if err != nil {
-			log.Printf("WARNING: Open database: %v (skipped)", err)
-			w.hasUpdate = false // don't retry until it changes again
-			break
-		}
-		defer f.Close()
+// reloadLocked reloads the database if an update is available, and reports
+// whether the store was replaced. The caller must hold w.μ.
+func (w *DBWatcher) reloadLocked() bool {
+	if !w.hasUpdate {
+		return false
+	}
+	f, err := os.Open(w.path)
+	if err != nil {
+		log.Printf("WARNING: Open database: %v (skipped)", err)
+		w.hasUpdate = false // don't retry until it changes again
+		w.loadErr = fmt.Errorf("open database: %w", err)
+		return false
+	}
+	defer f.Close()
 
-		st, err := kfdb.Open(f, w.passphrase)
-		if err != nil {
-			log.Printf("WARNING: Load database: %v (skipped)", err)
-			// N.B. Don't reset the flag; it might just be an incomplete update.
-			break
+	st, err := kfdb.Open(f, w.passphrase)
+	if err != nil {
+		log.Printf("WARNING: Load database: %v (skipped)", err)
+		// N.B. Don't reset the flag; it might just be an incomplete update.
+		w.loadErr = fmt.Errorf("load database: %w", err)
+		return false
+	}
+	log.Printf("Updated database %q", w.path)
+	w.hasUpdate = false
+	w.store = st
+	w.loadErr = nil
+	return true
+}
+
+// LoadError reports the error from the most recent attempt by Store to reload
+// the database, or nil if that attempt succeeded or no reload has occurred.
+// When LoadError is non-nil, Store is serving the last version of the
+// database that loaded successfully.
+func (w *DBWatcher) LoadError() error {
+	w.μ.Lock()
+	defer w.μ.Unlock()
+	return w.loadErr
+}
+
+// Save writes the current database back to the path watched by w, as
+// [SaveDB]. Changes to the file that w has not yet loaded are overwritten.
+func (w *DBWatcher) Save() error {
+	w.μ.Lock()
+	defer w.μ.Unlock()
+	return SaveDB(w.store, w.path)
+}
+
+// rewatchTries and rewatchDelay govern how rewatch retries when the database
+// path does not exist, e.g., between the removal of the old file and the
+// rename of its replacement.
+const (
+	rewatchTries = 20
+	rewatchDelay = 50 * time.Millisecond
+)
+
+// rewatch replaces the watch on w.path after the file has been removed or
+// renamed. It reports whether a new watch was successfully added.
+func (w *DBWatcher) rewatch(ctx context.Context) bool {
+	w.fw.Remove(w.path) // OK if this fails; the watch may already be gone
+	for range rewatchTries {
+		if err := w.fw.Add(w.path); err == nil {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(rewatchDelay):
 		}
-		log.Printf("Updated database %q", w.path)
-		w.hasUpdate = false
-		w.store = st
 	}
-	return w.store
+	return false
 }
 
 // Run monitors for changes to the database path in w, and updates it when the
 // underlying file is modified. Run should be run in a separate goroutine.  It
 // exits when the watcher closes, or ctx ends.
+//
+// If the file is removed or renamed, as when it is replaced by an atomic
+// rename, Run watches the path again and treats the replacement as an update.
+// If the path does not reappear after a short time, Run exits.
+//
+// Changes are reported once they have stopped arriving for w.Debounce, so
+// that Store does not see a partially-written file.
 func (w *DBWatcher) Run(ctx context.Context) {
 	w.fw.Add(w.path)
 	defer w.fw.Close()
 
+	// Changes often arrive in bursts, and the file may not be complete until
+	// the burst ends. Each change (re)starts the timer, and the update is not
+	// reported until it fires.
+	delay := cmp.Or(w.Debounce, DefaultDebounce)
+	timer := time.NewTimer(delay)
+	timer.Stop()
+	defer timer.Stop()
+
 	for {
 		select {
 		case evt, ok := <-w.fw.Events:
 			if !ok {
 				return
 			}
-			if evt.Op&fsnotify.Rename != 0 {
-				log.Printf("Database %q has moved; stopping the watcher", w.path)
-				return
+			if evt.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+				// The file was moved or replaced, e.g., by an atomic rename.
+				// Watch the path again so we see the new file.
+				if !w.rewatch(ctx) {
+					log.Printf("Database %q has moved; stopping the watcher", w.path)
+					return
+				}
 			} else if evt.Op&(fsnotify.Create|fsnotify.Chmod) == 0 {
 				continue // not relevant here
 			}
+			timer.Reset(delay)
+		case <-timer.C:
 			w.μ.Lock()
 			w.hasUpdate = true // read by Store
+			var st *kfdb.Store
+			if w.OnUpdate != nil && w.reloadLocked() {
+				st = w.store
+			}
 			w.μ.Unlock()
+			if st != nil {
+				w.OnUpdate(st) // N.B. not holding the lock
+			}
 		case e, ok := <-w.fw.Errors:
 			if !ok {
 				return
@@ -1,16 +1,31 @@
 package kflib_test
 
 import (
+	"bytes"
+	"context"
 	crand "crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	mrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/creachadair/keyfish/kfdb"
 	"github.com/creachadair/keyfish/kflib"
+	"github.com/creachadair/keyfish/kfstore"
 	"github.com/creachadair/mds/mtest"
+	"github.com/creachadair/otp/otpauth"
+	gocmp "github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestChars(t *testing.T) {
@@ -45,7 +60,7 @@ func TestChars(t *testing.T) {
 		}
 	}
 	t.Run("Random", func(t *testing.T) {
-		mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240323171652)))
+		mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240327120001)))
 
 		for _, tc := range tests {
 			check(t, kflib.RandomChars(tc.length, tc.charset), tc)
@@ -60,6 +75,125 @@ func TestChars(t *testing.T) {
 	})
 }
 
+func TestRandomCharsEnsure(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240325092214)))
+
+	for _, cs := range []kflib.Charset{kflib.Letters, kflib.Digits, kflib.Symbols, kflib.AllChars} {
+		// Use the minimum length, so that a uniform choice would often miss at
+		// least one of the character types.
+		for range 100 {
+			pw, err := kflib.RandomCharsEnsure(8, cs, "")
+			if err != nil {
+				t.Fatalf("RandomCharsEnsure: unexpected error: %v", err)
+			}
+			hasLetter, hasDigit, hasSymbol := checkPW(pw)
+			if !hasLetter {
+				t.Errorf("Password %q has no letters", pw)
+			}
+			if wd := cs&kflib.Digits != 0; wd != hasDigit {
+				t.Errorf("Password %q: has digit = %v, want %v", pw, hasDigit, wd)
+			}
+			if ws := cs&kflib.Symbols != 0; ws != hasSymbol {
+				t.Errorf("Password %q: has symbol = %v, want %v", pw, hasSymbol, ws)
+			}
+		}
+	}
+}
+
+func TestCustomSymbols(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240324143307)))
+
+	t.Run("Check", func(t *testing.T) {
+		for _, bad := range []string{"", "!!", "a!", "!5", "! ", "\t", "é"} {
+			if err := kflib.CheckSymbols(bad); err == nil {
+				t.Errorf("CheckSymbols(%q): got nil, want error", bad)
+			}
+		}
+		for _, good := range []string{"!", "-_", `'"\` + "`", "~!@#$%^&*"} {
+			if err := kflib.CheckSymbols(good); err != nil {
+				t.Errorf("CheckSymbols(%q): unexpected error: %v", good, err)
+			}
+		}
+	})
+
+	const symbols = "-_"
+	checkSyms := func(t *testing.T, pw string) {
+		t.Helper()
+		for i := range pw {
+			c := pw[i]
+			isAlnum := c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+			if !isAlnum && !strings.ContainsRune(symbols, rune(c)) {
+				t.Errorf("Password %q has unexpected symbol %q", pw, c)
+			}
+		}
+	}
+	t.Run("Random", func(t *testing.T) {
+		pw, err := kflib.RandomCharsCustom(40, kflib.AllChars, symbols)
+		if err != nil {
+			t.Fatalf("RandomCharsCustom: unexpected error: %v", err)
+		}
+		t.Logf("Generated %q", pw)
+		checkSyms(t, pw)
+	})
+	t.Run("Hashed", func(t *testing.T) {
+		pw, err := kflib.HashedCharsCustom(40, kflib.AllChars, symbols, "magic", "example.com", "")
+		if err != nil {
+			t.Fatalf("HashedCharsCustom: unexpected error: %v", err)
+		}
+		t.Logf("Generated %q", pw)
+		checkSyms(t, pw)
+
+		// Without a custom symbol set, the result should match HashedChars.
+		want := kflib.HashedChars(20, kflib.AllChars, "magic", "example.com", "")
+		got, err := kflib.HashedCharsCustom(20, kflib.AllChars, "", "magic", "example.com", "")
+		if err != nil {
+			t.Fatalf("HashedCharsCustom: unexpected error: %v", err)
+		} else if got != want {
+			t.Errorf("HashedCharsCustom: got %q, want %q", got, want)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		if pw, err := kflib.RandomCharsCustom(20, kflib.AllChars, "aa"); err == nil {
+			t.Errorf("RandomCharsCustom: got %q, want error", pw)
+		}
+	})
+}
+
+func TestUniform(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240327110925)))
+
+	// Generate a large sample of letters, and check that the distribution of
+	// characters is flat using a chi-squared test.
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	const perChar = 2000
+	const numChars = perChar * len(alphabet)
+
+	counts := make(map[rune]int)
+	for n := 0; n < numChars; {
+		pw := kflib.RandomChars(1000, kflib.Letters)
+		for _, c := range pw[:min(len(pw), numChars-n)] {
+			counts[c]++
+			n++
+		}
+	}
+	if len(counts) != len(alphabet) {
+		t.Errorf("Got %d distinct characters, want %d", len(counts), len(alphabet))
+	}
+	var chi2 float64
+	for _, c := range alphabet {
+		d := float64(counts[c] - perChar)
+		chi2 += d * d / perChar
+	}
+
+	// The critical value of the chi-squared distribution with 51 degrees of
+	// freedom at p = 0.001 is approximately 87.97.
+	const critical = 87.97
+	t.Logf("Chi-squared statistic: %.2f (critical value %.2f)", chi2, critical)
+	if chi2 > critical {
+		t.Errorf("Distribution is not flat: chi-squared %.2f > %.2f", chi2, critical)
+	}
+}
+
 func checkPW(s string) (hasLetter, hasDigit, hasOther bool) {
 	for i := range s {
 		if s[i] >= 'A' && s[i] <= 'Z' || s[i] >= 'a' && s[i] <= 'z' {
@@ -96,3 +230,1877 @@ func TestRandomWords(t *testing.T) {
 		log.Printf("Generated %q %q", raw, got)
 	}
 }
+
+func TestLoadWordList(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240326154210)))
+	t.Cleanup(func() {
+		if err := kflib.LoadWordList(""); err != nil {
+			t.Fatalf("Restore word list: %v", err)
+		}
+	})
+
+	writeList := func(t *testing.T, words []string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "words.txt")
+		if err := os.WriteFile(path, []byte(strings.Join(words, "\n")), 0600); err != nil {
+			t.Fatalf("Write word list: %v", err)
+		}
+		return path
+	}
+	var words []string
+	for i := range 300 {
+		words = append(words, fmt.Sprintf("%05d wort%d", 11111+i, i))
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		if err := kflib.LoadWordList(writeList(t, words)); err != nil {
+			t.Fatalf("LoadWordList: unexpected error: %v", err)
+		}
+		raw := kflib.RandomWords(5, " ")
+		t.Logf("Generated %q", raw)
+		for _, w := range strings.Fields(raw) {
+			if !strings.HasPrefix(w, "wort") {
+				t.Errorf("Word %q is not from the loaded list", w)
+			}
+		}
+	})
+	t.Run("TooShort", func(t *testing.T) {
+		if err := kflib.LoadWordList(writeList(t, words[:255])); err == nil {
+			t.Error("LoadWordList: got nil, want error")
+		}
+	})
+	t.Run("Duplicate", func(t *testing.T) {
+		dup := append(words[:len(words):len(words)], "99999 wort5")
+		if err := kflib.LoadWordList(writeList(t, dup)); err == nil {
+			t.Error("LoadWordList: got nil, want error")
+		}
+	})
+}
+
+func TestRandomWordsWith(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240326120744)))
+
+	tests := []struct {
+		numWords int
+		opts     kflib.WordOptions
+	}{
+		{1, kflib.WordOptions{Digits: 2}},
+		{3, kflib.WordOptions{TitleCase: true}},
+		{4, kflib.WordOptions{Digits: 3, TitleCase: true}},
+	}
+	for _, tc := range tests {
+		raw := kflib.RandomWordsWith(tc.numWords, "-", tc.opts)
+		t.Logf("Generated %q", raw)
+		got := strings.Split(raw, "-")
+
+		wantLen := max(tc.numWords, 3)
+		if tc.opts.Digits > 0 {
+			wantLen++
+			last := got[len(got)-1]
+			if len(last) != tc.opts.Digits || strings.Trim(last, "0123456789") != "" {
+				t.Errorf("Last group is %q, want %d digits", last, tc.opts.Digits)
+			}
+		}
+		if len(got) != wantLen {
+			t.Errorf("Got %d groups, want %d", len(got), wantLen)
+		}
+		if hasUpper := strings.ToLower(raw) != raw; hasUpper != tc.opts.TitleCase {
+			t.Errorf("Has capital = %v, want %v", hasUpper, tc.opts.TitleCase)
+		}
+	}
+}
+
+func TestRandomPronounceable(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240324101518)))
+
+	for _, length := range []int{1, 12, 15, 20} {
+		got := kflib.RandomPronounceable(length)
+		if want := max(length, 12); len(got) != want {
+			t.Errorf("Got length %d, want %d", len(got), want)
+		}
+		for i := range got {
+			isVowel := strings.IndexByte("aeiou", got[i]) >= 0
+			if isVowel != (i%2 == 1) {
+				t.Errorf("Position %d of %q: got vowel=%v, want %v", i, got, isVowel, i%2 == 1)
+			}
+		}
+		t.Logf("Generated %q", got)
+	}
+}
+
+func TestRandomFrom(t *testing.T) {
+	const seed = 20261015093012
+	newRNG := func() io.Reader { return mrand.New(mrand.NewSource(seed)) }
+
+	// Each generator should produce the same output when given identically
+	// seeded readers, without touching crypto/rand.
+	gens := []struct {
+		name string
+		gen  func(io.Reader) (string, error)
+	}{
+		{"Chars", func(r io.Reader) (string, error) {
+			return kflib.RandomCharsFrom(r, 16, kflib.AllChars), nil
+		}},
+		{"CharsCustom", func(r io.Reader) (string, error) {
+			return kflib.RandomCharsCustomFrom(r, 16, kflib.AllChars, "!@#")
+		}},
+		{"CharsEnsure", func(r io.Reader) (string, error) {
+			return kflib.RandomCharsEnsureFrom(r, 12, kflib.AllChars, "")
+		}},
+		{"Words", func(r io.Reader) (string, error) {
+			return kflib.RandomWordsFrom(r, 4, "-", kflib.WordOptions{}), nil
+		}},
+		{"Pronounceable", func(r io.Reader) (string, error) {
+			return kflib.RandomPronounceableFrom(r, 16), nil
+		}},
+	}
+	for _, g := range gens {
+		t.Run(g.name, func(t *testing.T) {
+			v1, err := g.gen(newRNG())
+			if err != nil {
+				t.Fatalf("Generate 1: unexpected error: %v", err)
+			}
+			v2, err := g.gen(newRNG())
+			if err != nil {
+				t.Fatalf("Generate 2: unexpected error: %v", err)
+			}
+			if v1 != v2 {
+				t.Errorf("Outputs differ: %q vs. %q", v1, v2)
+			}
+
+			// A different seed should (almost surely) give different output.
+			v3, err := g.gen(mrand.New(mrand.NewSource(seed + 1)))
+			if err != nil {
+				t.Fatalf("Generate 3: unexpected error: %v", err)
+			}
+			if v3 == v1 {
+				t.Errorf("Different seeds gave the same output: %q", v1)
+			}
+			t.Logf("Generated %q", v1)
+		})
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	near := func(got, want float64) bool { return math.Abs(got-want) < 0.01 }
+
+	t.Run("Chars", func(t *testing.T) {
+		tests := []struct {
+			length  int
+			charset kflib.Charset
+			want    float64
+		}{
+			{1, kflib.Letters, 45.60},    // minimum length is 8: 8 * log2(52)
+			{8, kflib.Letters, 45.60},    // 8 * log2(52)
+			{12, kflib.Digits, 71.45},    // 12 * log2(62)
+			{16, kflib.Symbols, 101.15},  // 16 * log2(80)
+			{20, kflib.AllChars, 129.84}, // 20 * log2(90)
+		}
+		for _, tc := range tests {
+			if got := kflib.CharsEntropy(tc.length, tc.charset); !near(got, tc.want) {
+				t.Errorf("CharsEntropy(%d, %v): got %.2f, want %.2f", tc.length, tc.charset, got, tc.want)
+			}
+		}
+	})
+	t.Run("Words", func(t *testing.T) {
+		// These values assume the standard 7776-entry diceware word list.
+		tests := []struct {
+			numWords int
+			want     float64
+		}{
+			{1, 38.77}, // minimum is 3 words: 3 * log2(7776)
+			{3, 38.77},
+			{5, 64.62},
+			{6, 77.55},
+		}
+		for _, tc := range tests {
+			if got := kflib.WordsEntropy(tc.numWords); !near(got, tc.want) {
+				t.Errorf("WordsEntropy(%d): got %.2f, want %.2f", tc.numWords, got, tc.want)
+			}
+		}
+	})
+	t.Run("WordsWith", func(t *testing.T) {
+		tests := []struct {
+			numWords int
+			opts     kflib.WordOptions
+			want     float64
+		}{
+			{3, kflib.WordOptions{}, 38.77},
+			{3, kflib.WordOptions{Digits: 2}, 45.41},                  // + 2 * log2(10)
+			{4, kflib.WordOptions{TitleCase: true}, 53.70},            // + log2(4)
+			{4, kflib.WordOptions{Digits: 1, TitleCase: true}, 57.02}, // + log2(10) + log2(4)
+		}
+		for _, tc := range tests {
+			if got := kflib.WordsEntropyWith(tc.numWords, tc.opts); !near(got, tc.want) {
+				t.Errorf("WordsEntropyWith(%d, %+v): got %.2f, want %.2f", tc.numWords, tc.opts, got, tc.want)
+			}
+		}
+	})
+	t.Run("Pronounceable", func(t *testing.T) {
+		tests := []struct {
+			length int
+			want   float64
+		}{
+			{1, 38.96},  // minimum length is 12: 6 * log2(18) + 6 * log2(5)
+			{12, 38.96}, // 6 * log2(18) + 6 * log2(5)
+			{13, 43.13}, // 7 * log2(18) + 6 * log2(5)
+		}
+		for _, tc := range tests {
+			if got := kflib.PronounceableEntropy(tc.length); !near(got, tc.want) {
+				t.Errorf("PronounceableEntropy(%d): got %.2f, want %.2f", tc.length, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestReadCSV(t *testing.T) {
+	const bitwarden = `folder,favorite,type,name,notes,fields,reprompt,login_uri,login_username,login_password,login_totp
+,,login,Example Bank,my notes,,0,"https://www.bank.example.com/login,bank.example.com",alice,pa$$word,JBSWY3DPEHPK3PXP
+,1,login,Mail,,,0,mail.example.com,alice@example.com,hunter2,
+`
+	const onePassword = `Title,Url,Username,Password,OTPAuth,Favorite,Archived,Tags,Notes
+Example Bank,https://bank.example.com,bob,secret1,otpauth://totp/Bank:bob?secret=JBSWY3DPEHPK3PXP&issuer=Bank,false,false,,
+Forum Login!,,bob,secret2,,false,false,,hello
+`
+	opt := cmpopts.IgnoreFields(otpauth.URL{}, "RawSecret")
+
+	t.Run("Bitwarden", func(t *testing.T) {
+		got, err := kflib.ReadCSV(strings.NewReader(bitwarden), "bitwarden-csv")
+		if err != nil {
+			t.Fatalf("ReadCSV: unexpected error: %v", err)
+		}
+		want := []*kfdb.Record{{
+			Label:    "example-bank",
+			Title:    "Example Bank",
+			Username: "alice",
+			Hosts:    kfdb.Strings{"www.bank.example.com", "bank.example.com"},
+			Password: "pa$$word",
+			Notes:    "my notes",
+			OTP: &otpauth.URL{
+				Type: "totp", Issuer: "Example Bank", Account: "alice",
+				Algorithm: "SHA1", Digits: 6, Period: 30,
+			},
+		}, {
+			Label:    "mail",
+			Title:    "Mail",
+			Username: "alice@example.com",
+			Hosts:    kfdb.Strings{"mail.example.com"},
+			Password: "hunter2",
+		}}
+		if diff := gocmp.Diff(got, want, opt); diff != "" {
+			t.Errorf("ReadCSV (-got, +want):\n%s", diff)
+		}
+	})
+	t.Run("1Password", func(t *testing.T) {
+		got, err := kflib.ReadCSV(strings.NewReader(onePassword), "1password-csv")
+		if err != nil {
+			t.Fatalf("ReadCSV: unexpected error: %v", err)
+		}
+		want := []*kfdb.Record{{
+			Label:    "example-bank",
+			Title:    "Example Bank",
+			Username: "bob",
+			Hosts:    kfdb.Strings{"bank.example.com"},
+			Password: "secret1",
+			OTP: &otpauth.URL{
+				Type: "totp", Issuer: "Bank", Account: "bob",
+				Algorithm: "SHA1", Digits: 6, Period: 30,
+			},
+		}, {
+			Label:    "forum-login",
+			Title:    "Forum Login!",
+			Username: "bob",
+			Password: "secret2",
+			Notes:    "hello",
+		}}
+		if diff := gocmp.Diff(got, want, opt); diff != "" {
+			t.Errorf("ReadCSV (-got, +want):\n%s", diff)
+		}
+
+		db := &kfdb.DB{Records: []*kfdb.Record{{Label: "forum-login"}}}
+		added, skipped := kflib.AddRecords(db, got)
+		if added != 1 || skipped != 1 {
+			t.Errorf("AddRecords: got (%d, %d), want (1, 1)", added, skipped)
+		}
+		if len(db.Records) != 2 || db.Records[1].Label != "example-bank" {
+			t.Errorf("AddRecords: wrong result %+v", db.Records)
+		}
+	})
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := kflib.ReadCSV(strings.NewReader(bitwarden), "nonesuch"); err == nil {
+			t.Error("ReadCSV with bad format: got nil, want error")
+		}
+		const badTOTP = "name,login_totp\nfoo,not*base32\n"
+		if _, err := kflib.ReadCSV(strings.NewReader(badTOTP), "bitwarden-csv"); err == nil {
+			t.Error("ReadCSV with bad TOTP: got nil, want error")
+		}
+	})
+	t.Run("Duplicates", func(t *testing.T) {
+		const dups = `name,login_uri,login_username,login_password
+Google,accounts.google.com,alice@gmail.com,secret1
+Google,accounts.google.com,bob@gmail.com,secret2
+Mail,mail.example.com,alice,secret3
+`
+		recs, err := kflib.ReadCSV(strings.NewReader(dups), "bitwarden-csv")
+		if err != nil {
+			t.Fatalf("ReadCSV: unexpected error: %v", err)
+		}
+		db := &kfdb.DB{Records: []*kfdb.Record{{Label: "mail"}}}
+		added, skipped, err := kflib.ImportRecords(db, recs)
+		if err != nil {
+			t.Fatalf("ImportRecords: unexpected error: %v", err)
+		}
+		if added != 1 || skipped != 2 {
+			t.Errorf("ImportRecords: got (%d, %d), want (1, 2)", added, skipped)
+		}
+		if len(db.Records) != 2 || db.Records[1].Username != "alice@gmail.com" {
+			t.Errorf("ImportRecords: wrong result %+v", db.Records)
+		}
+
+		bad := []*kfdb.Record{{Label: "ok", Title: "OK"}, {Label: "bad", Hosts: kfdb.Strings{"https://x.com"}}}
+		if _, _, err := kflib.ImportRecords(db, bad); err == nil {
+			t.Error("ImportRecords with invalid record: got nil, want error")
+		} else if len(db.Records) != 2 {
+			t.Errorf("ImportRecords: modified the database on error: %+v", db.Records)
+		}
+	})
+}
+
+func TestReadOTPURLs(t *testing.T) {
+	const input = `otpauth://totp/Bank:alice?secret=JBSWY3DPEHPK3PXP&issuer=Bank
+
+otpauth://totp/Mail:bob@example.com?secret=MFRGGZDFMZTWQ2LK&issuer=Mail
+otpauth://totp/alice?secret=MFRGGZDFMZTWQ2LK
+`
+	urls, err := kflib.ReadOTPURLs(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadOTPURLs: unexpected error: %v", err)
+	}
+	if len(urls) != 3 {
+		t.Fatalf("ReadOTPURLs: got %d URLs, want 3", len(urls))
+	}
+
+	db := &kfdb.DB{Records: []*kfdb.Record{
+		{Label: "bank", Title: "Example Bank"},
+		{Label: "email", Title: "Mail"},
+		{Label: "alice"},
+	}}
+	created, attached, skipped := kflib.AddOTPs(db, urls)
+	if created != 0 || attached != 2 || skipped != 1 {
+		t.Errorf("AddOTPs: got (%d, %d, %d), want (0, 2, 1)", created, attached, skipped)
+	}
+	if db.Records[0].OTP != urls[0] || db.Records[1].OTP != urls[1] || db.Records[2].OTP != nil {
+		t.Errorf("AddOTPs: wrong attachments %+v", db.Records)
+	}
+
+	db = &kfdb.DB{}
+	created, attached, skipped = kflib.AddOTPs(db, urls)
+	if created != 3 || attached != 0 || skipped != 0 {
+		t.Errorf("AddOTPs: got (%d, %d, %d), want (3, 0, 0)", created, attached, skipped)
+	}
+	var labels []string
+	for _, r := range db.Records {
+		labels = append(labels, r.Label)
+	}
+	if diff := gocmp.Diff(labels, []string{"bank", "mail", "alice"}); diff != "" {
+		t.Errorf("AddOTPs labels (-got, +want):\n%s", diff)
+	}
+
+	const bad = "otpauth://totp/ok?secret=MFRGGZDFMZTWQ2LK\nhttp://bogus\n\notpauth://totp/x?secret=%zz\n"
+	_, err = kflib.ReadOTPURLs(strings.NewReader(bad))
+	if err == nil {
+		t.Fatal("ReadOTPURLs: got nil, want error")
+	}
+	for _, want := range []string{"line 2:", "line 4:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ReadOTPURLs error %q: missing %q", err, want)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestCheckPwnedPassword(t *testing.T) {
+	// SHA1("password") = 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	const body = "0018A45C4D1DEF81644B54AB7F969B88D65:0\r\n" +
+		"1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n" +
+		"011053FD0102E94D6AE2F8B83D76FAF94F6:1\r\n"
+	var paths []string
+	mtest.Swap[http.RoundTripper](t, &http.DefaultClient.Transport, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.URL.Path)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	}))
+
+	tests := []struct {
+		pw   string
+		want int
+	}{
+		{"password", 3861493},
+		{"correct horse battery staple", 0},
+	}
+	for _, tc := range tests {
+		got, err := kflib.CheckPwnedPassword(context.Background(), tc.pw)
+		if err != nil {
+			t.Errorf("CheckPwnedPassword(%q): unexpected error: %v", tc.pw, err)
+		} else if got != tc.want {
+			t.Errorf("CheckPwnedPassword(%q): got %d, want %d", tc.pw, got, tc.want)
+		}
+	}
+
+	// Only the 5-digit prefix of the digest may be sent.
+	if diff := gocmp.Diff(paths, []string{"/range/5BAA6", "/range/ABF7A"}); diff != "" {
+		t.Errorf("Request paths (-got, +want):\n%s", diff)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	otp := &otpauth.URL{Type: "totp", RawSecret: "MFRGGZDFMZTWQ2LK"}
+	db := &kfdb.DB{
+		Defaults: &kfdb.Defaults{Hashpass: &kfdb.Hashpass{SecretKey: "key"}},
+		Records: []*kfdb.Record{
+			{Label: "a", Password: "correct horse battery", Username: "u", Hosts: kfdb.Strings{"a.com"}, OTP: otp},
+			{Label: "b", Password: "short"},
+			{Label: "c"},                               // no password, no host for hashpass
+			{Label: "d", Hosts: kfdb.Strings{"d.com"}}, // hashpass
+			{Label: "e", Password: "correct horse battery", Username: "u", Hosts: kfdb.Strings{"e.com"}},
+			{Label: "f", Password: "short", Archived: true},
+			{Label: "g", Password: "short"},
+			{Label: "h", Password: "rotated long ago", RotateAfter: kfdb.Duration(time.Hour)},
+
+			// Hashpass configs that generate the same password as each other.
+			{Label: "i", Hashpass: &kfdb.Hashpass{Seed: "shared"}},
+			{Label: "j", Hashpass: &kfdb.Hashpass{Seed: "shared", Length: 4}}, // minimum is 8
+			{Label: "k", Hashpass: &kfdb.Hashpass{Seed: "shared", Length: 8}},
+			{Label: "l", Hashpass: &kfdb.Hashpass{Seed: "shared", SecretKey: "other"}},
+			{Label: "m", Hashpass: &kfdb.Hashpass{Seed: "shared", Punct: new(bool)}},
+			{Label: "n", Hosts: kfdb.Strings{"d.com"}, Hashpass: &kfdb.Hashpass{Length: 0}},
+		},
+	}
+	got := kflib.Audit(db, 12)
+	want := &kflib.AuditReport{
+		NoPassword:   []string{"c"},
+		Reused:       [][]string{{"a", "e"}, {"b", "g"}},
+		SameHashpass: [][]string{{"d", "n"}, {"i", "j", "k"}},
+		Short:        []string{"b", "g"},
+		NoOTP:        []string{"e"},
+		Expired:      []string{"h"},
+	}
+	if diff := gocmp.Diff(got, want); diff != "" {
+		t.Errorf("Audit (-got, +want):\n%s", diff)
+	}
+	if got.IsEmpty() {
+		t.Error("IsEmpty: got true, want false")
+	}
+	if rep := kflib.Audit(&kfdb.DB{}, 12); !rep.IsEmpty() {
+		t.Errorf("Audit of empty DB: got %+v, want empty", rep)
+	}
+}
+
+func TestPasswordExpired(t *testing.T) {
+	const day = 24 * time.Hour
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time { t := now.Add(-d); return &t }
+	tests := []struct {
+		name string
+		rec  *kfdb.Record
+		want bool
+	}{
+		{"no rotation", &kfdb.Record{Password: "x", PasswordSetAt: at(1000 * day)}, false},
+		{"no password", &kfdb.Record{RotateAfter: kfdb.Duration(day), PasswordSetAt: at(2 * day)}, false},
+		{"fresh", &kfdb.Record{Password: "x", RotateAfter: kfdb.Duration(90 * day), PasswordSetAt: at(89 * day)}, false},
+		{"due now", &kfdb.Record{Password: "x", RotateAfter: kfdb.Duration(90 * day), PasswordSetAt: at(90 * day)}, true},
+		{"overdue", &kfdb.Record{Password: "x", RotateAfter: kfdb.Duration(90 * day), PasswordSetAt: at(400 * day)}, true},
+		{"unknown age", &kfdb.Record{Password: "x", RotateAfter: kfdb.Duration(90 * day)}, true},
+	}
+	for _, tc := range tests {
+		if got := kflib.PasswordExpired(tc.rec, now); got != tc.want {
+			t.Errorf("PasswordExpired(%s): got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// Setting the password resets its age.
+	r := &kfdb.Record{Password: "old", RotateAfter: kfdb.Duration(90 * day), PasswordSetAt: at(100 * day)}
+	kflib.SetPassword(r, "new", now)
+	if r.PasswordSetAt == nil || !r.PasswordSetAt.Equal(now) {
+		t.Errorf("SetPassword: set time is %v, want %v", r.PasswordSetAt, now)
+	}
+	if kflib.PasswordExpired(r, now.Add(89*day)) || !kflib.PasswordExpired(r, now.Add(90*day)) {
+		t.Error("PasswordExpired: rotation not measured from the new set time")
+	}
+	kflib.SetPassword(r, "", now)
+	if r.PasswordSetAt != nil {
+		t.Errorf("SetPassword(empty): set time is %v, want nil", r.PasswordSetAt)
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	db := &kfdb.DB{
+		SchemaVersion: kfdb.CurrentSchemaVersion,
+		Records:       []*kfdb.Record{{Label: "test", Password: "hunter2"}},
+	}
+	s, err := kfdb.New("old passphrase", db)
+	if err != nil {
+		t.Fatalf("Create store: %v", err)
+	}
+	s2, err := kflib.ChangePassphrase(s, "new passphrase")
+	if err != nil {
+		t.Fatalf("ChangePassphrase: unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := s2.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: unexpected error: %v", err)
+	}
+
+	if _, err := kfdb.Open(bytes.NewReader(buf.Bytes()), "old passphrase"); err == nil {
+		t.Error("Open with old passphrase: got nil, want error")
+	}
+	s3, err := kfdb.Open(bytes.NewReader(buf.Bytes()), "new passphrase")
+	if err != nil {
+		t.Fatalf("Open with new passphrase: unexpected error: %v", err)
+	}
+	if diff := gocmp.Diff(s3.DB(), db); diff != "" {
+		t.Errorf("Reopened DB (-got, +want):\n%s", diff)
+	}
+}
+
+func TestCompactDB(t *testing.T) {
+	db := &kfdb.DB{
+		SchemaVersion: kfdb.CurrentSchemaVersion,
+		Records: []*kfdb.Record{
+			{Label: "zebra", Hosts: kfdb.Strings{"z.com", "z.com"}, Hashpass: &kfdb.Hashpass{}},
+			{Label: "apple", Tags: kfdb.Strings{}, Details: []*kfdb.Detail{{}, {Label: "pin", Value: "1234"}}},
+			{Label: "mango", Hashpass: &kfdb.Hashpass{Seed: "fruit"}},
+		},
+	}
+	s, err := kfdb.New("passphrase", db)
+	if err != nil {
+		t.Fatalf("Create store: %v", err)
+	}
+	s2, err := kflib.CompactDB(s, "passphrase")
+	if err != nil {
+		t.Fatalf("CompactDB: unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := s2.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: unexpected error: %v", err)
+	}
+	s3, err := kfdb.Open(bytes.NewReader(buf.Bytes()), "passphrase")
+	if err != nil {
+		t.Fatalf("Reopen: unexpected error: %v", err)
+	}
+	want := []*kfdb.Record{
+		{Label: "apple", Details: []*kfdb.Detail{{Label: "pin", Value: "1234"}}},
+		{Label: "mango", Hashpass: &kfdb.Hashpass{Seed: "fruit"}},
+		{Label: "zebra", Hosts: kfdb.Strings{"z.com"}},
+	}
+	if diff := gocmp.Diff(s3.DB().Records, want); diff != "" {
+		t.Errorf("Compacted records (-got, +want):\n%s", diff)
+	}
+
+	// The original store is not modified.
+	if s.DB() == s2.DB() {
+		t.Error("CompactDB: new store shares the database of the original")
+	}
+	if got := s.DB().Records[0]; got.Label != "zebra" || len(got.Hosts) != 2 || got.Hashpass == nil {
+		t.Errorf("CompactDB modified the original record: %+v", got)
+	}
+}
+
+func TestRewriteKeepsCompression(t *testing.T) {
+	const passphrase = "passphrase"
+	rewrite := map[string]func(*kfdb.Store) (*kfdb.Store, error){
+		"ChangePassphrase": func(s *kfdb.Store) (*kfdb.Store, error) { return kflib.ChangePassphrase(s, passphrase) },
+		"CompactDB":        func(s *kfdb.Store) (*kfdb.Store, error) { return kflib.CompactDB(s, passphrase) },
+	}
+	for name, f := range rewrite {
+		t.Run(name, func(t *testing.T) {
+			s, err := kfdb.New(passphrase, &kfdb.DB{Records: []*kfdb.Record{{Label: "test"}}})
+			if err != nil {
+				t.Fatalf("Create store: %v", err)
+			}
+			if err := s.SetCompression(kfstore.NoCompression); err != nil {
+				t.Fatalf("SetCompression: %v", err)
+			}
+			s2, err := f(s)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+			if got := s2.Compression(); got != kfstore.NoCompression {
+				t.Errorf("Compression: got %d, want %d", got, kfstore.NoCompression)
+			}
+		})
+	}
+}
+
+func TestCloneRecord(t *testing.T) {
+	otpURL := &otpauth.URL{Type: "totp", Account: "a", RawSecret: "MFRGGZDFMZTWQ2LK"}
+	rec := &kfdb.Record{
+		Label:         "orig",
+		UID:           "1234",
+		Archived:      true,
+		Username:      "alice",
+		Hosts:         kfdb.Strings{"example.com"},
+		Tags:          []string{"work"},
+		Hashpass:      &kfdb.Hashpass{Seed: "example", Length: 20},
+		Password:      "hunter2",
+		OTP:           otpURL,
+		Details:       []*kfdb.Detail{{Label: "pin", Value: "1234", Hidden: true}},
+		RecoveryCodes: []*kfdb.RecoveryCode{{Code: "abc-def"}},
+		Attachments:   []*kfdb.Attachment{{Name: "key", Content: []byte("secret")}},
+	}
+	cp, err := kflib.CloneRecord(rec, "copy")
+	if err != nil {
+		t.Fatalf("CloneRecord: unexpected error: %v", err)
+	}
+	want := &kfdb.Record{
+		Label:    "copy",
+		Username: "alice",
+		Hosts:    kfdb.Strings{"example.com"},
+		Tags:     []string{"work"},
+		Hashpass: &kfdb.Hashpass{Seed: "copy", Length: 20},
+		Details:  []*kfdb.Detail{{Label: "pin", Value: "1234", Hidden: true}},
+	}
+	if diff := gocmp.Diff(cp, want); diff != "" {
+		t.Errorf("Clone (-got, +want):\n%s", diff)
+	}
+
+	// The copy must not generate the same hashpass as the original, even if
+	// the original seed is taken from its hosts.
+	hp := &kfdb.Record{Label: "hp", Hosts: kfdb.Strings{"example.com"}, Hashpass: &kfdb.Hashpass{}}
+	cp2, err := kflib.CloneRecord(hp, "hp2")
+	if err != nil {
+		t.Fatalf("CloneRecord: unexpected error: %v", err)
+	}
+	db := &kfdb.DB{Records: []*kfdb.Record{hp, cp2}}
+	if a := kflib.Audit(db, 0); len(a.SameHashpass) != 0 {
+		t.Errorf("Audit: clone reuses the hashpass of the original: %v", a.SameHashpass)
+	}
+
+	// The copy must not share storage with the original.
+	cp.Hashpass.Seed = "other"
+	cp.Details[0].Value = "5678"
+	if rec.Hashpass.Seed != "example" || rec.Details[0].Value != "1234" {
+		t.Errorf("Modifying the clone changed the original: %+v", rec)
+	}
+}
+
+func TestDBWatcherOnUpdate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := kfdb.New("test", &kfdb.DB{Records: []*kfdb.Record{{Label: "old"}}})
+	if err != nil {
+		t.Fatalf("Create store: %v", err)
+	}
+	if err := kflib.SaveDB(s, dbPath); err != nil {
+		t.Fatalf("SaveDB: %v", err)
+	}
+	w, err := kflib.NewDBWatcher(s, dbPath, "test")
+	if err != nil {
+		t.Fatalf("NewDBWatcher: %v", err)
+	}
+	w.Debounce = 10 * time.Millisecond
+	updated := make(chan string, 1)
+	w.OnUpdate = func(st *kfdb.Store) {
+		if w.Store() != st { // must not deadlock
+			t.Error("OnUpdate: store does not match watcher")
+		}
+		select {
+		case updated <- st.DB().Records[0].Label:
+		default:
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); w.Run(ctx) }()
+	defer func() { cancel(); <-done }()
+
+	// The watcher may not have started when the first update is written, so
+	// retry a few times before giving up.
+	s.DB().Records[0].Label = "new"
+	for i := 0; i < 20; i++ {
+		if err := kflib.SaveDB(s, dbPath); err != nil {
+			t.Fatalf("SaveDB: %v", err)
+		}
+		select {
+		case got := <-updated:
+			if got != "new" {
+				t.Errorf("OnUpdate: got label %q, want new", got)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("OnUpdate was not called after modification")
+}
+
+func TestDBWatcherReplace(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := kfdb.New("test", &kfdb.DB{Records: []*kfdb.Record{{Label: "v0"}}})
+	if err != nil {
+		t.Fatalf("Create store: %v", err)
+	}
+	if err := kflib.SaveDB(s, dbPath); err != nil {
+		t.Fatalf("SaveDB: %v", err)
+	}
+	w, err := kflib.NewDBWatcher(s, dbPath, "test")
+	if err != nil {
+		t.Fatalf("NewDBWatcher: %v", err)
+	}
+	w.Debounce = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); w.Run(ctx) }()
+	defer func() { cancel(); <-done }()
+
+	// Each save replaces the file with an atomic rename. The watcher must
+	// survive each replacement and see the next one.
+	waitFor := func(want string) bool {
+		for range 20 {
+			if got := w.Store().DB().Records[0].Label; got == want {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+	for i, retries := 1, 5; i <= 3; i++ {
+		want := fmt.Sprintf("v%d", i)
+		s, err := kfdb.New("test", &kfdb.DB{Records: []*kfdb.Record{{Label: want}}})
+		if err != nil {
+			t.Fatalf("Create store: %v", err)
+		}
+		if err := kflib.SaveDB(s, dbPath); err != nil {
+			t.Fatalf("SaveDB: %v", err)
+		}
+		if !waitFor(want) {
+			if i == 1 && retries > 0 {
+				i-- // the watcher may not have started yet; try again
+				retries--
+				continue
+			}
+			t.Fatalf("Store: did not observe update %q", want)
+		}
+	}
+}
+
+func TestDBWatcherDebounce(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	save := func(label string) {
+		t.Helper()
+		s, err := kfdb.New("test", &kfdb.DB{Records: []*kfdb.Record{{Label: label}}})
+		if err != nil {
+			t.Fatalf("Create store: %v", err)
+		}
+		if err := kflib.SaveDB(s, dbPath); err != nil {
+			t.Fatalf("SaveDB: %v", err)
+		}
+	}
+	save("init")
+	s, err := kflib.OpenDBWithPassphrase(dbPath, "test")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	w, err := kflib.NewDBWatcher(s, dbPath, "test")
+	if err != nil {
+		t.Fatalf("NewDBWatcher: %v", err)
+	}
+	w.Debounce = 100 * time.Millisecond
+	updates := make(chan string, 10)
+	w.OnUpdate = func(st *kfdb.Store) { updates <- st.DB().Records[0].Label }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); w.Run(ctx) }()
+	defer func() { cancel(); <-done }()
+
+	// Wait for the watcher to start.
+	for i := 0; ; i++ {
+		save("start")
+		select {
+		case <-updates:
+		case <-time.After(300 * time.Millisecond):
+			if i < 10 {
+				continue
+			}
+			t.Fatal("Watcher did not report an update")
+		}
+		break
+	}
+
+	// A burst of changes should be reported as a single update.
+	for i := range 5 {
+		save(fmt.Sprintf("burst-%d", i))
+	}
+	select {
+	case got := <-updates:
+		if got != "burst-4" {
+			t.Errorf("OnUpdate: got %q, want burst-4", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watcher did not report an update")
+	}
+	select {
+	case got := <-updates:
+		t.Errorf("OnUpdate: got extra update %q", got)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestReadRecordsYAML(t *testing.T) {
+	const input = `
+- label: bank
+  title: Example Bank
+  hosts: bank.example.com
+- label: mail
+  hosts: [mail.example.com, example.com]
+  details:
+    - label: pin
+      value: "1234"
+`
+	recs, err := kflib.ReadRecordsYAML(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadRecordsYAML: unexpected error: %v", err)
+	}
+	want := []*kfdb.Record{{
+		Label: "bank",
+		Title: "Example Bank",
+		Hosts: kfdb.Strings{"bank.example.com"},
+	}, {
+		Label:   "mail",
+		Hosts:   kfdb.Strings{"mail.example.com", "example.com"},
+		Details: []*kfdb.Detail{{Label: "pin", Value: "1234"}},
+	}}
+	if diff := gocmp.Diff(recs, want); diff != "" {
+		t.Errorf("ReadRecordsYAML (-got, +want):\n%s", diff)
+	}
+	if _, err := kflib.ReadRecordsYAML(strings.NewReader("- label: x\n  bogus: 1\n")); err == nil {
+		t.Error("ReadRecordsYAML with unknown field: got nil, want error")
+	}
+
+	db := &kfdb.DB{Records: []*kfdb.Record{{Label: "mail"}}}
+	added, skipped, err := kflib.ImportRecords(db, recs)
+	if err != nil {
+		t.Fatalf("ImportRecords: unexpected error: %v", err)
+	} else if added != 1 || skipped != 1 {
+		t.Errorf("ImportRecords: got (%d, %d), want (1, 1)", added, skipped)
+	}
+	if _, _, err := kflib.ImportRecords(db, []*kfdb.Record{{Notes: "no label or title"}}); err == nil {
+		t.Error("ImportRecords with invalid record: got nil, want error")
+	} else if len(db.Records) != 2 {
+		t.Errorf("ImportRecords: modified the database on error: %+v", db.Records)
+	}
+
+	jrecs, err := kflib.ReadRecordsJSON(strings.NewReader(`[{"title": "Example Bank", "future": 1}]`))
+	if err != nil {
+		t.Fatalf("ReadRecordsJSON: unexpected error: %v", err)
+	}
+	if added, _, err := kflib.ImportRecords(db, jrecs); err != nil || added != 1 {
+		t.Errorf("ImportRecords: got (%d, %v), want (1, nil)", added, err)
+	} else if r := db.Records[2]; r.Label != "example-bank" || string(r.Extra["future"]) != "1" {
+		t.Errorf("ImportRecords: got %+v, want a derived label and the unknown field", r)
+	}
+}
+
+func TestValidateDB(t *testing.T) {
+	good := &kfdb.DB{Records: []*kfdb.Record{
+		{Label: "a", Hosts: kfdb.Strings{"a.example.com"}, Hashpass: &kfdb.Hashpass{}},
+		{Title: "No label"},
+		{Label: "b", OTP: &otpauth.URL{Type: "totp", RawSecret: "MFRGGZDFMZTWQ2LK"}},
+		{Label: "c", Details: []*kfdb.Detail{{Label: "pin", Value: "1234"}}},
+	}}
+	if errs := kflib.ValidateDB(good); len(errs) != 0 {
+		t.Errorf("ValidateDB: unexpected errors: %v", errs)
+	}
+
+	bad := &kfdb.DB{Records: []*kfdb.Record{
+		{Label: "a"},
+		{Label: "a"}, // duplicate label
+		{},           // no label or title
+		{Label: "b", OTP: &otpauth.URL{Type: "totp", RawSecret: "not*base32"}},
+		{Label: "c", OTP: &otpauth.URL{Type: "bogus", RawSecret: "MFRGGZDFMZTWQ2LK"}},
+		{Label: "d", Details: []*kfdb.Detail{{Value: "1234"}}},
+		{Label: "e", Hosts: kfdb.Strings{"https://e.example.com/login"}},
+		{Label: "f", Hashpass: &kfdb.Hashpass{Length: 10}},
+		{Label: "g", UID: "x1"},
+		{Label: "h", UID: "x1"}, // duplicate UID
+	}, Defaults: &kfdb.Defaults{Templates: map[string]*kfdb.Template{
+		"t": {Details: []*kfdb.Detail{{Label: "ok"}, {Value: "no label"}}},
+	}}}
+	errs := kflib.ValidateDB(bad)
+	for _, err := range errs {
+		t.Logf("Error: %v", err)
+	}
+	if len(errs) != 9 {
+		t.Errorf("ValidateDB: got %d errors, want 9", len(errs))
+	}
+}
+
+func TestParseAlphabet(t *testing.T) {
+	tests := []struct {
+		specs []string
+		want  string
+	}{
+		{[]string{"upper"}, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+		{[]string{"lower"}, "abcdefghijklmnopqrstuvwxyz"},
+		{[]string{"letter"}, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"},
+		{[]string{"digit"}, "0123456789"},
+		{[]string{"punct"}, `!#$%&()*+,-./:;<=>?@[]^_{|}~`},
+		{[]string{"chars:xyz"}, "xyz"},
+		{[]string{"digit", "chars:-_"}, "0123456789-_"},
+		{[]string{"lower", "upper"}, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+		{[]string{"chars:aabbc", "lower"}, "abcdefghijklmnopqrstuvwxyz"},
+	}
+	for _, tc := range tests {
+		got, err := kflib.ParseAlphabet(tc.specs)
+		if err != nil {
+			t.Errorf("ParseAlphabet(%q): unexpected error: %v", tc.specs, err)
+		} else if got != tc.want {
+			t.Errorf("ParseAlphabet(%q): got %q, want %q", tc.specs, got, tc.want)
+		}
+	}
+
+	for _, bad := range [][]string{
+		nil, {"bogus"}, {"chars:a"}, {"chars:a b"}, {"chars:é"}, {"chars:"},
+	} {
+		if got, err := kflib.ParseAlphabet(bad); err == nil {
+			t.Errorf("ParseAlphabet(%q): got %q, want error", bad, got)
+		}
+	}
+
+	// The alphabet of a charset generates the same passwords as the charset.
+	for _, cs := range []kflib.Charset{kflib.Letters, kflib.Digits, kflib.Symbols, kflib.AllChars} {
+		want := kflib.HashedChars(15, cs, "secret", "seed", "salt")
+		got, err := kflib.HashedCharsAlphabet(15, cs.Alphabet(), "secret", "seed", "salt")
+		if err != nil {
+			t.Errorf("HashedCharsAlphabet: unexpected error: %v", err)
+		} else if got != want {
+			t.Errorf("HashedCharsAlphabet(%v): got %q, want %q", cs, got, want)
+		}
+	}
+}
+
+func TestHashpassAlphabet(t *testing.T) {
+	noPunct := false
+	db := &kfdb.DB{
+		Defaults: &kfdb.Defaults{Hashpass: &kfdb.Hashpass{SecretKey: "secret", Length: 10}},
+		Records: []*kfdb.Record{
+			{Label: "pin", Hosts: kfdb.Strings{"bank.com"}, Hashpass: &kfdb.Hashpass{Alphabet: kfdb.Strings{"digit"}}},
+			{Label: "lower", Hosts: kfdb.Strings{"mail.com"}, Hashpass: &kfdb.Hashpass{Alphabet: kfdb.Strings{"lower", "chars:-"}}},
+			{Label: "plain", Hosts: kfdb.Strings{"web.com"}, Hashpass: &kfdb.Hashpass{Punct: &noPunct}},
+			{Label: "bad", Hosts: kfdb.Strings{"bad.com"}, Hashpass: &kfdb.Hashpass{Alphabet: kfdb.Strings{"nonesuch"}}},
+		},
+	}
+	check := func(label, chars string) {
+		t.Helper()
+		rec := db.Records[slices.IndexFunc(db.Records, func(r *kfdb.Record) bool { return r.Label == label })]
+		pw, err := kflib.GenerateHashpass(db, rec, "")
+		if err != nil {
+			t.Fatalf("GenerateHashpass %q: unexpected error: %v", label, err)
+		}
+		if len(pw) != 10 || strings.Trim(pw, chars) != "" {
+			t.Errorf("GenerateHashpass %q: got %q, want 10 characters of %q", label, pw, chars)
+		}
+	}
+	check("pin", "0123456789")
+	check("lower", "abcdefghijklmnopqrstuvwxyz-")
+	check("plain", "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
+
+	// The plain record matches HashedChars without symbols.
+	if got, _ := kflib.GenerateHashpass(db, db.Records[2], "x"); got != kflib.HashedChars(10, kflib.Digits, "secret", "web.com", "x") {
+		t.Errorf("GenerateHashpass: got %q, does not match HashedChars", got)
+	}
+	if pw, err := kflib.GenerateHashpass(db, db.Records[3], ""); err == nil {
+		t.Errorf("GenerateHashpass with bad alphabet: got %q, want error", pw)
+	}
+
+	// Different tags generate different passwords.
+	if v1, _ := kflib.GenerateHashpass(db, db.Records[2], "v1"); v1 == "" {
+		t.Error("GenerateHashpass with tag v1: empty result")
+	} else if v2, _ := kflib.GenerateHashpass(db, db.Records[2], "v2"); v1 == v2 {
+		t.Errorf("GenerateHashpass: tags v1 and v2 both generated %q", v1)
+	}
+
+	// A length override replaces the configured length.
+	if pw, err := kflib.GenerateHashpassLength(db, db.Records[0], "", 8); err != nil {
+		t.Errorf("GenerateHashpassLength: unexpected error: %v", err)
+	} else if len(pw) != 8 || strings.Trim(pw, "0123456789") != "" {
+		t.Errorf("GenerateHashpassLength: got %q, want 8 digits", pw)
+	}
+}
+
+func TestHashpassPunct(t *testing.T) {
+	yes, no := true, false
+	const noSymbols = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	tests := []struct {
+		name         string
+		record, dflt *bool
+		want         bool // whether symbols are permitted
+	}{
+		{"unset", nil, nil, true},
+		{"record-on", &yes, nil, true},
+		{"record-off", &no, nil, false},
+		{"default-on", nil, &yes, true},
+		{"default-off", nil, &no, false},
+		{"on-on", &yes, &yes, true},
+		{"on-off", &yes, &no, true},
+		{"off-on", &no, &yes, false},
+		{"off-off", &no, &no, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &kfdb.DB{
+				Defaults: &kfdb.Defaults{Hashpass: &kfdb.Hashpass{SecretKey: "secret", Length: 32, Punct: tc.dflt}},
+			}
+			rec := &kfdb.Record{Label: "test", Hosts: kfdb.Strings{"example.com"}, Hashpass: &kfdb.Hashpass{Punct: tc.record}}
+			pw, err := kflib.GenerateHashpass(db, rec, "")
+			if err != nil {
+				t.Fatalf("GenerateHashpass: unexpected error: %v", err)
+			}
+			want := kflib.HashedChars(32, kflib.AllChars, "secret", "example.com", "")
+			if !tc.want {
+				want = kflib.HashedChars(32, kflib.AllChars&^kflib.Symbols, "secret", "example.com", "")
+				if strings.Trim(pw, noSymbols) != "" {
+					t.Errorf("GenerateHashpass: got %q, want no symbols", pw)
+				}
+			}
+			if pw != want {
+				t.Errorf("GenerateHashpass: got %q, want %q", pw, want)
+			}
+		})
+	}
+}
+
+// The outputs of HashedChars are a compatibility contract: Users regenerate
+// the same password from the same inputs on different machines and with
+// different versions of keyfish. If this test fails, the change that caused
+// it must be reverted, not the test updated.
+func TestHashedGolden(t *testing.T) {
+	tests := []struct {
+		length                 int
+		charset                kflib.Charset
+		passphrase, seed, salt string
+		want                   string
+	}{
+		{8, kflib.Letters, "secret", "example.com", "", "DaPnPVKE"},
+		{12, kflib.Digits, "secret", "example.com", "", "hPYVSrJY3TqM"},
+		{16, kflib.AllChars, "secret", "example.com", "", "pN{b|!|^k|w4i?Vb"},
+		{16, kflib.AllChars, "secret", "example.com", "work", "k6:_FHKN.u[OS]-="},
+		{20, kflib.Symbols, "correct horse battery staple", "mail.example.org", "2024", "StLVxQ(+,Yi@n#EDzZXS"},
+		{32, kflib.AllChars, "full plate and packing steel", "bank.example.net", "", "Aw!*_YSgdPV7,+-[3T&x}x-x:S$4p;P2"},
+		{4, kflib.Digits, "secret", "example.com", "", "hPYVSrJY"}, // minimum length
+		{64, kflib.AllChars, "", "", "", "plUE>H8?8gme;Z4sz.#Z4*l|e];#j6y>V-g6a=2[jo8{L-;*b+FXr;<xrA]0L6_3"},
+	}
+	for _, tc := range tests {
+		got := kflib.HashedChars(tc.length, tc.charset, tc.passphrase, tc.seed, tc.salt)
+		if got != tc.want {
+			t.Errorf("HashedChars(%d, %v, %q, %q, %q): got %q, want %q",
+				tc.length, tc.charset, tc.passphrase, tc.seed, tc.salt, got, tc.want)
+		}
+	}
+}
+
+func TestSetField(t *testing.T) {
+	r := &kfdb.Record{
+		Label:   "test",
+		Title:   "Old",
+		Hosts:   kfdb.Strings{"a.com"},
+		Details: []*kfdb.Detail{{Label: "PIN", Value: "1234"}},
+	}
+	for _, arg := range [][2]string{
+		{"title", "New title"},
+		{"username", "alice"},
+		{"notes", "some notes"},
+		{"host", "b.com"},
+		{"host", "a.com"}, // already present
+		{"alias", "a.net"},
+		{"addr", "alice@a.com"},
+		{"detail", "pin:5678"},
+		{"detail", "account:12:34"},
+		{"code", "abcd-1234"},
+		{"code", "abcd-1234"}, // already present
+	} {
+		msg, err := kflib.SetField(r, arg[0], arg[1])
+		if err != nil {
+			t.Errorf("SetField(%q, %q): unexpected error: %v", arg[0], arg[1], err)
+		}
+		t.Logf("SetField(%q, %q): %s", arg[0], arg[1], msg)
+	}
+	want := &kfdb.Record{
+		Label:    "test",
+		Title:    "New title",
+		Username: "alice",
+		Notes:    "some notes",
+		Hosts:    kfdb.Strings{"a.com", "b.com"},
+		Aliases:  kfdb.Strings{"a.net"},
+		Addrs:    kfdb.Strings{"alice@a.com"},
+		Details: []*kfdb.Detail{
+			{Label: "PIN", Value: "5678"},
+			{Label: "account", Value: "12:34"},
+		},
+		RecoveryCodes: []*kfdb.RecoveryCode{{Code: "abcd-1234"}},
+	}
+	if diff := gocmp.Diff(r, want); diff != "" {
+		t.Errorf("Record (-got, +want):\n%s", diff)
+	}
+
+	for _, bad := range [][2]string{
+		{"label", "x"}, {"password", "x"}, {"host", "https://a.com/"}, {"alias", "a b"}, {"detail", "nolabel"}, {"addr", ""}, {"code", ""},
+	} {
+		if _, err := kflib.SetField(r, bad[0], bad[1]); err == nil {
+			t.Errorf("SetField(%q, %q): got nil, want error", bad[0], bad[1])
+		}
+	}
+}
+
+func TestEditKeepsExtra(t *testing.T) {
+	extra := func(s string) map[string]json.RawMessage {
+		return map[string]json.RawMessage{"future": json.RawMessage(s)}
+	}
+	db := &kfdb.DB{
+		Records: []*kfdb.Record{
+			{Label: "a", UID: "u1", Title: "A", Extra: extra(`1`)},
+			{Label: "b", Title: "B", Extra: extra(`2`)},
+		},
+		Extra: extra(`"db"`),
+	}
+
+	// Simulate an edit that changes and reorders records and adds a new one.
+	edited := []byte(`records:
+  - label: b
+    title: B modified
+  - label: a2
+    uid: u1
+    title: A renamed
+  - label: c
+`)
+	got, err := kflib.DecodeEditedDB(db, edited)
+	if err != nil {
+		t.Fatalf("Decode: unexpected error: %v", err)
+	}
+
+	// Save and reopen the edited database, and check the extra fields.
+	const testPass = "password"
+	s, err := kfdb.New(testPass, got)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	s2, err := kfdb.Open(&buf, testPass)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	out := s2.DB()
+	if got := string(out.Extra["future"]); got != `"db"` {
+		t.Errorf("DB extra: got %q, want %q", got, `"db"`)
+	}
+	for i, want := range []string{`2`, `1`, ``} {
+		if got := string(out.Records[i].Extra["future"]); got != want {
+			t.Errorf("Record %d extra: got %q, want %q", i+1, got, want)
+		}
+	}
+	if out.Records[1].Title != "A renamed" {
+		t.Errorf("Record 2 title: got %q, want %q", out.Records[1].Title, "A renamed")
+	}
+
+	rec, err := kflib.DecodeEditedRecord(db.Records[0], []byte("label: a\ntitle: Edited\n"))
+	if err != nil {
+		t.Fatalf("Decode record: unexpected error: %v", err)
+	}
+	if rec.Title != "Edited" || string(rec.Extra["future"]) != `1` {
+		t.Errorf("Decode record: got %+v, want title Edited with extra fields", rec)
+	}
+}
+
+func TestApplyTemplate(t *testing.T) {
+	db := &kfdb.DB{Defaults: &kfdb.Defaults{Templates: map[string]*kfdb.Template{
+		"bank": {
+			Tags: []string{"Finance", "work"},
+			Details: []*kfdb.Detail{
+				{Label: "Account number"},
+				{Label: "Security question", Hidden: true},
+			},
+		},
+	}}}
+	r := &kfdb.Record{
+		Label:   "mybank",
+		Tags:    []string{"work"},
+		Details: []*kfdb.Detail{{Label: "account number", Value: "12345"}},
+	}
+	if err := kflib.ApplyTemplate(db, r, "bank"); err != nil {
+		t.Fatalf("ApplyTemplate: unexpected error: %v", err)
+	}
+	want := &kfdb.Record{
+		Label: "mybank",
+		Tags:  []string{"finance", "work"},
+		Details: []*kfdb.Detail{
+			{Label: "account number", Value: "12345"},
+			{Label: "Security question", Hidden: true},
+		},
+	}
+	if diff := gocmp.Diff(r, want); diff != "" {
+		t.Errorf("Record (-got, +want):\n%s", diff)
+	}
+
+	// The template is not modified by changes to the record.
+	r.Details[1].Value = "secret"
+	if v := db.Defaults.Templates["bank"].Details[1].Value; v != "" {
+		t.Errorf("Template detail value: got %q, want empty", v)
+	}
+
+	if err := kflib.ApplyTemplate(db, r, "nonesuch"); err == nil {
+		t.Error("ApplyTemplate(nonesuch): got nil, want error")
+	}
+}
+
+func TestParseOTP(t *testing.T) {
+	u, err := kflib.ParseOTP(nil, "mfrg gzdf mztw q2lk", "site", "alice")
+	if err != nil {
+		t.Fatalf("ParseOTP: unexpected error: %v", err)
+	}
+	want := &otpauth.URL{
+		Type: "totp", Issuer: "site", Account: "alice", RawSecret: "MFRGGZDFMZTWQ2LK",
+		Algorithm: "SHA1", Digits: 6, Period: 30,
+	}
+	if diff := gocmp.Diff(u, want); diff != "" {
+		t.Errorf("ParseOTP (-got, +want):\n%s", diff)
+	}
+
+	u, err = kflib.ParseOTP(nil, "otpauth://totp/Bank:bob?secret=JBSWY3DPEHPK3PXP&issuer=Bank", "site", "alice")
+	if err != nil {
+		t.Fatalf("ParseOTP: unexpected error: %v", err)
+	} else if u.Issuer != "Bank" || u.Account != "bob" {
+		t.Errorf("ParseOTP: got issuer %q, account %q; want Bank, bob", u.Issuer, u.Account)
+	}
+
+	for _, bad := range []string{"not*base32", "otpauth://totp/x?secret=1234", "otpauth://totp/x?secret=%zz"} {
+		if u, err := kflib.ParseOTP(nil, bad, "", ""); err == nil {
+			t.Errorf("ParseOTP(%q): got %v, want error", bad, u)
+		}
+	}
+
+	// A bare secret should use the OTP defaults of the database.
+	db := &kfdb.DB{Defaults: &kfdb.Defaults{
+		OTP: &kfdb.OTPDefaults{Algorithm: "sha256", Digits: 8},
+	}}
+	u, err = kflib.ParseOTP(db, "mfrggzdfmztwq2lk", "site", "alice")
+	if err != nil {
+		t.Fatalf("ParseOTP: unexpected error: %v", err)
+	}
+	want.Algorithm, want.Digits = "SHA256", 8
+	if diff := gocmp.Diff(u, want); diff != "" {
+		t.Errorf("ParseOTP with defaults (-got, +want):\n%s", diff)
+	}
+}
+
+func TestGenerateOTP(t *testing.T) {
+	u := &otpauth.URL{Type: "totp", RawSecret: "MFRGGZDFMZTWQ2LK"}
+	db := &kfdb.DB{Defaults: &kfdb.Defaults{
+		OTP: &kfdb.OTPDefaults{Digits: 8, Period: 60},
+	}}
+	tests := []struct {
+		db   *kfdb.DB
+		url  *otpauth.URL
+		want int // code length
+	}{
+		{nil, u, 6},
+		{db, u, 8},
+		{db, &otpauth.URL{Type: "totp", RawSecret: u.RawSecret, Digits: 7}, 7},
+	}
+	for _, tc := range tests {
+		code, err := kflib.GenerateOTPWithDefaults(tc.db, tc.url, 0)
+		if err != nil {
+			t.Errorf("GenerateOTPWithDefaults(%v): unexpected error: %v", tc.url, err)
+		} else if len(code) != tc.want {
+			t.Errorf("GenerateOTPWithDefaults(%v): got %q, want %d digits", tc.url, code, tc.want)
+		}
+	}
+	if code, err := kflib.GenerateOTP(u, 0); err != nil || len(code) != 6 {
+		t.Errorf("GenerateOTP(%v): got (%q, %v), want 6 digits", u, code, err)
+	}
+
+	bad := &otpauth.URL{Type: "totp", RawSecret: u.RawSecret, Algorithm: "MD5"}
+	if code, err := kflib.GenerateOTP(bad, 0); err == nil {
+		t.Errorf("GenerateOTP(%v): got %q, want error", bad, code)
+	}
+	if code, err := kflib.GenerateOTP(&otpauth.URL{Type: "totp"}, 0); !errors.Is(err, kflib.ErrNoSecret) {
+		t.Errorf("GenerateOTP(no secret): got (%q, %v), want %v", code, err, kflib.ErrNoSecret)
+	}
+	corrupt := &otpauth.URL{Type: "totp", RawSecret: "not*base32"}
+	if code, err := kflib.GenerateOTP(corrupt, 0); !errors.Is(err, kflib.ErrInvalidSecret) {
+		t.Errorf("GenerateOTP(%v): got (%q, %v), want %v", corrupt, code, err, kflib.ErrInvalidSecret)
+	}
+
+	// The input URL should not be modified by applying defaults.
+	if u.Digits != 0 || u.Period != 0 || u.Algorithm != "" {
+		t.Errorf("GenerateOTP modified its input: %+v", u)
+	}
+	// A URL that omits the period and digits uses 6 digits and a 30-second
+	// window, matching an explicit configuration with those values.  Allow
+	// for the time step to roll over between the two computations.
+	code, err := kflib.GenerateOTP(u, 0)
+	if err != nil {
+		t.Fatalf("GenerateOTP(%v): unexpected error: %v", u, err)
+	}
+	explicit := &otpauth.URL{Type: "totp", RawSecret: u.RawSecret, Digits: 6, Period: 30}
+	if _, ok, err := kflib.MatchOTP(nil, explicit, code, 1); err != nil || !ok {
+		t.Errorf("MatchOTP(%q): got (%v, %v), want match with explicit defaults", code, ok, err)
+	}
+}
+
+func TestExportOTP(t *testing.T) {
+	db := &kfdb.DB{Defaults: &kfdb.Defaults{OTP: &kfdb.OTPDefaults{Digits: 8}}}
+	tests := []struct {
+		rec  *kfdb.Record
+		url  *otpauth.URL
+		want string
+	}{
+		{&kfdb.Record{Label: "bank", Title: "My Bank", Username: "alice"},
+			&otpauth.URL{Type: "totp", RawSecret: "MFRGGZDFMZTWQ2LK"},
+			"otpauth://totp/My%20Bank:alice?digits=8&issuer=My%20Bank&secret=MFRGGZDFMZTWQ2LK"},
+		{&kfdb.Record{Label: "mail", Addrs: kfdb.Strings{"bob@example.com"}},
+			&otpauth.URL{Type: "totp", RawSecret: "MFRGGZDFMZTWQ2LK", Digits: 6, Period: 60},
+			"otpauth://totp/mail:bob@example.com?issuer=mail&period=60&secret=MFRGGZDFMZTWQ2LK"},
+		{&kfdb.Record{Label: "x", Title: "Ignored", Username: "ignored"},
+			&otpauth.URL{Type: "totp", Issuer: "Acme", Account: "carol", RawSecret: "MFRGGZDFMZTWQ2LK"},
+			"otpauth://totp/Acme:carol?digits=8&issuer=Acme&secret=MFRGGZDFMZTWQ2LK"},
+	}
+	for _, tc := range tests {
+		if got := kflib.ExportOTP(db, tc.rec, tc.url).String(); got != tc.want {
+			t.Errorf("ExportOTP(%q):\n got %s\nwant %s", tc.rec.Label, got, tc.want)
+		}
+	}
+}
+
+func TestMatchOTP(t *testing.T) {
+	// Use a long period so the time step does not roll over during the test.
+	u := &otpauth.URL{Type: "totp", RawSecret: "MFRGGZDFMZTWQ2LK", Digits: 8, Period: 3600}
+	for _, off := range []int{-3, -1, 0, 2, 5} {
+		code, err := kflib.GenerateOTP(u, off)
+		if err != nil {
+			t.Fatalf("GenerateOTP(%d): unexpected error: %v", off, err)
+		}
+		got, ok, err := kflib.MatchOTP(nil, u, code, 5)
+		if err != nil || !ok || got != off {
+			t.Errorf("MatchOTP(%q): got (%d, %v, %v), want (%d, true, nil)", code, got, ok, err, off)
+		}
+	}
+
+	code, err := kflib.GenerateOTP(u, 4)
+	if err != nil {
+		t.Fatalf("GenerateOTP: unexpected error: %v", err)
+	}
+	if got, ok, err := kflib.MatchOTP(nil, u, code, 3); err != nil || ok {
+		t.Errorf("MatchOTP outside window: got (%d, %v, %v), want no match", got, ok, err)
+	}
+}
+
+func TestPFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// A plain passphrase file is read as text.
+	plain := filepath.Join(dir, "plain")
+	if err := os.WriteFile(plain, []byte("  open sesame\n"), 0600); err != nil {
+		t.Fatalf("Write plain file: %v", err)
+	}
+	if got, err := kflib.ReadPFile(plain, ""); err != nil || got != "open sesame" {
+		t.Errorf("ReadPFile plain: got (%q, %v), want (open sesame, nil)", got, err)
+	}
+
+	// An encrypted passphrase file requires the key.
+	enc := filepath.Join(dir, "encrypted")
+	if err := kflib.WritePFile(enc, "open sesame", "pfile key"); err != nil {
+		t.Fatalf("WritePFile: %v", err)
+	}
+	if data, err := os.ReadFile(enc); err != nil {
+		t.Fatalf("Read encrypted file: %v", err)
+	} else if bytes.Contains(data, []byte("sesame")) {
+		t.Errorf("Encrypted file contains the passphrase: %s", data)
+	}
+	if got, err := kflib.ReadPFile(enc, "pfile key"); err != nil || got != "open sesame" {
+		t.Errorf("ReadPFile encrypted: got (%q, %v), want (open sesame, nil)", got, err)
+	}
+	if got, err := kflib.ReadPFile(enc, ""); !errors.Is(err, kflib.ErrNoPFileKey) {
+		t.Errorf("ReadPFile without key: got (%q, %v), want %v", got, err, kflib.ErrNoPFileKey)
+	}
+	if got, err := kflib.ReadPFile(enc, "wrong key"); err == nil {
+		t.Errorf("ReadPFile with wrong key: got %q, want error", got)
+	}
+}
+
+func TestFavorite(t *testing.T) {
+	db := &kfdb.DB{Records: []*kfdb.Record{
+		{Label: "work-mail", Hosts: kfdb.Strings{"mail.example.com"}},
+		{Label: "home-mail", Hosts: kfdb.Strings{"mail.example.org"}, Favorite: true},
+		{Label: "old-mail", Hosts: kfdb.Strings{"mail.example.net"}},
+		{Label: "example", Hosts: kfdb.Strings{"example.com"}},
+	}}
+
+	// Favorites sort first among records of the same quality.
+	var got []string
+	for _, fr := range kflib.FindRecords(db.Records, "mail") {
+		got = append(got, fr.Record.Label)
+	}
+	if diff := gocmp.Diff(got, []string{"home-mail", "work-mail", "old-mail"}); diff != "" {
+		t.Errorf("FindRecords order (-got, +want):\n%s", diff)
+	}
+
+	// A single favorite breaks a tie.
+	if res, err := kflib.FindRecord(db, "mail", false); err != nil {
+		t.Errorf("FindRecord: unexpected error: %v", err)
+	} else if res.Record.Label != "home-mail" {
+		t.Errorf("FindRecord: got %q, want home-mail", res.Record.Label)
+	}
+
+	// Multiple favorites of the same quality do not break the tie.
+	db.Records[0].Favorite = true
+	if res, err := kflib.FindRecord(db, "mail", false); err == nil {
+		t.Errorf("FindRecord: got %q, want error", res.Record.Label)
+	}
+}
+
+func TestMatchFuzzy(t *testing.T) {
+	r := &kfdb.Record{
+		Label: "github",
+		Title: "GitHub Enterprise",
+		Hosts: kfdb.Strings{"github.com", "accounts.example.org"},
+	}
+	tests := []struct {
+		query string
+		want  kflib.MatchQuality
+	}{
+		{"github", kflib.MatchLabel},
+		{"hub", kflib.MatchTitle},
+		{"githbu", kflib.MatchFuzzy},     // transposition
+		{"gthub", kflib.MatchFuzzy},      // deletion
+		{"enterprize", kflib.MatchFuzzy}, // substitution in a title word
+		{"acounts", kflib.MatchFuzzy},    // host component
+		{"enterpriese", kflib.MatchFuzzy},
+		{"gtihbu", kflib.MatchNone}, // too many edits for a short query
+		{"gbt", kflib.MatchNone},    // too short to match fuzzily
+		{"xqzvwk", kflib.MatchNone},
+		{"zebrafish", kflib.MatchNone},
+	}
+	for _, tc := range tests {
+		if got := kflib.MatchRecord(tc.query, r); got != tc.want {
+			t.Errorf("MatchRecord(%q): got %v, want %v", tc.query, got, tc.want)
+		}
+	}
+
+	// Fuzzy matches rank below all other matches.
+	db := &kfdb.DB{Records: []*kfdb.Record{
+		{Label: "gitlab"},
+		{Label: "sub", Notes: "my gitlb account"},
+	}}
+	found := kflib.FindRecords(db.Records, "gitlb")
+	if len(found) != 2 || found[0].Record.Label != "sub" || found[1].Quality != kflib.MatchFuzzy {
+		t.Errorf("FindRecords: got %+v, want substring before fuzzy match", found)
+	}
+
+	// A fuzzy match selects a record, unless fuzzy matches are excluded, in
+	// which case the match is offered as a suggestion.
+	if res, err := kflib.FindRecord(db, "gitlba", true); err != nil || res.Record.Label != "gitlab" {
+		t.Errorf("FindRecord: got (%+v, %v), want gitlab", res, err)
+	}
+	if res, err := kflib.FindRecordMin(db, "gitlba", true, kflib.MatchSubstring); err == nil {
+		t.Errorf("FindRecordMin: got %+v, want error", res)
+	} else if !strings.Contains(err.Error(), "did you mean gitlab") {
+		t.Errorf("FindRecordMin: got %v, want a suggestion", err)
+	}
+}
+
+func TestFindRecordsMin(t *testing.T) {
+	recs := []*kfdb.Record{
+		{Label: "misc", Notes: "see example.com"},                // substring
+		{Label: "exmaple.com"},                                   // fuzzy
+		{Label: "shop", Title: "Example.com Shop"},               // title
+		{Label: "home", Hosts: kfdb.Strings{"mail.example.com"}}, // partial host
+		{Label: "work", Hosts: kfdb.Strings{"example.com"}},      // host
+		{Label: "example.com"},                                   // label
+		{Label: "none", Hosts: kfdb.Strings{"example.org"}},      // no match
+	}
+	tests := []struct {
+		min  kflib.MatchQuality
+		want []string
+	}{
+		{kflib.MatchNone, nil},
+		{kflib.MatchLabel, []string{"example.com"}},
+		{kflib.MatchHost, []string{"example.com", "work"}},
+		{kflib.MatchTitle, []string{"example.com", "work", "home", "shop"}},
+		{kflib.MatchSubstring, []string{"example.com", "work", "home", "shop", "misc"}},
+		{kflib.MatchFuzzy, []string{"example.com", "work", "home", "shop", "misc", "exmaple.com"}},
+	}
+	for _, tc := range tests {
+		var got []string
+		for _, fr := range kflib.FindRecordsMin(recs, "tag@example.com", tc.min) {
+			got = append(got, fr.Record.Label)
+		}
+		if diff := gocmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("FindRecordsMin(%v) (-got, +want):\n%s", tc.min, diff)
+		}
+	}
+	if diff := gocmp.Diff(kflib.FindRecords(recs, "example.com"), kflib.FindRecordsMin(recs, "example.com", kflib.MatchFuzzy)); diff != "" {
+		t.Errorf("FindRecords differs from FindRecordsMin(MatchFuzzy) (-got, +want):\n%s", diff)
+	}
+}
+
+func TestSuggestRecords(t *testing.T) {
+	db := &kfdb.DB{Records: []*kfdb.Record{
+		{Label: "github"},
+		{Label: "gitlab"},
+		{Label: "bank", Title: "First National Bank"},
+		{Label: "old-github", Hosts: kfdb.Strings{"githuub.com"}, Archived: true},
+	}}
+	labels := func(rs []*kfdb.Record) (out []string) {
+		for _, r := range rs {
+			out = append(out, r.Label)
+		}
+		return
+	}
+	tests := []struct {
+		query string
+		n     int
+		want  []string
+	}{
+		{"gtihbu", 3, []string{"github", "old-github"}},
+		{"gitlub", 3, []string{"github", "gitlab", "old-github"}},
+		{"gitlub", 1, []string{"github"}},
+		{"tag@natoinal", 3, []string{"bank"}},
+		{"zebrafish", 3, nil},
+	}
+	for _, tc := range tests {
+		got := labels(kflib.SuggestRecords(db.Records, tc.query, tc.n))
+		if diff := gocmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("SuggestRecords(%q, %d) (-got, +want):\n%s", tc.query, tc.n, diff)
+		}
+	}
+
+	// A failed lookup mentions the suggestions, but not archived records.
+	_, err := kflib.FindRecord(db, "gtihbu", false)
+	if err == nil || !strings.Contains(err.Error(), "did you mean github?") {
+		t.Errorf("FindRecord: got %v, want suggestion of github", err)
+	}
+}
+
+func TestMatchAlias(t *testing.T) {
+	r := &kfdb.Record{
+		Label:   "mail",
+		Hosts:   kfdb.Strings{"mail.example.com"},
+		Aliases: kfdb.Strings{"webmail.example.org", "intranet"},
+	}
+	tests := []struct {
+		query string
+		want  kflib.MatchQuality
+	}{
+		{"mail.example.com", kflib.MatchHost},
+		{"webmail.example.org", kflib.MatchHost},
+		{"intranet", kflib.MatchHost}, // dotless alias
+		{"example.org", kflib.MatchHostPartial},
+		{"webmail", kflib.MatchSubstring},
+		{"intrnet", kflib.MatchFuzzy},
+		{"webmial", kflib.MatchFuzzy},
+		{"other.example.net", kflib.MatchNone},
+	}
+	for _, tc := range tests {
+		if got := kflib.MatchRecord(tc.query, r); got != tc.want {
+			t.Errorf("MatchRecord(%q): got %v, want %v", tc.query, got, tc.want)
+		}
+	}
+
+	// Aliases are also considered for suggestions.
+	if got := kflib.SuggestRecords([]*kfdb.Record{r}, "intrannet", 3); len(got) != 1 {
+		t.Errorf("SuggestRecords: got %d records, want 1", len(got))
+	}
+}
+
+func TestPickRecord(t *testing.T) {
+	db := &kfdb.DB{Records: []*kfdb.Record{
+		{Label: "mail-home", Title: "Mail"},
+		{Label: "mail-work", Title: "Mail"},
+		{Label: "mailer", Title: "Mailer"},
+	}}
+	_, err := kflib.FindRecord(db, "mail", false)
+	var merr *kflib.MultipleMatchError
+	if !errors.As(err, &merr) {
+		t.Fatalf("FindRecord: got %v, want *MultipleMatchError", err)
+	} else if len(merr.Found) != 3 {
+		t.Fatalf("FindRecord: got %d candidates, want 3", len(merr.Found))
+	}
+
+	tests := []struct {
+		input string
+		want  string // label, or "" for cancel
+	}{
+		{"\r", "mail-home"},
+		{"\x1b[B\x1b[B\r", "mailer"},
+		{"\x1b[A\r", "mailer"}, // wraps around
+		{"jjk\n", "mail-work"},
+		{"x2", "mail-work"}, // unknown keys are ignored
+		{"j\x1b", ""},
+		{"q", ""},
+	}
+	for _, tc := range tests {
+		var out strings.Builder
+		got, err := kflib.PickRecordFrom(strings.NewReader(tc.input), &out, merr.Found)
+		if tc.want == "" {
+			if !errors.Is(err, kflib.ErrUserReject) {
+				t.Errorf("Pick %q: got (%v, %v), want ErrUserReject", tc.input, got.Record, err)
+			}
+		} else if err != nil {
+			t.Errorf("Pick %q: unexpected error: %v", tc.input, err)
+		} else if got.Record.Label != tc.want {
+			t.Errorf("Pick %q: got %q, want %q", tc.input, got.Record.Label, tc.want)
+		}
+	}
+
+	// Input that ends without a choice reports an error.
+	if got, err := kflib.PickRecordFrom(strings.NewReader("j"), io.Discard, merr.Found); err == nil {
+		t.Errorf("Pick: got %v, want error", got.Record)
+	}
+}
+
+func TestRecordField(t *testing.T) {
+	db := &kfdb.DB{Defaults: &kfdb.Defaults{Hashpass: &kfdb.Hashpass{SecretKey: "secret"}}}
+	r := &kfdb.Record{
+		Label:    "test",
+		Username: "alice",
+		Hosts:    kfdb.Strings{"example.com"},
+		Addrs:    kfdb.Strings{"alice@example.com", "bob@example.com"},
+		OTP:      &otpauth.URL{Type: "totp", RawSecret: "MFRGGZDFMZTWQ2LK"},
+		Details: []*kfdb.Detail{
+			{Label: "PIN", Value: "1234", Hidden: true},
+			{Label: "account", Value: "5678"},
+			{Label: "dup", Value: "a"},
+			{Label: "DUP", Value: "b"},
+		},
+	}
+	tests := []struct {
+		name   string
+		want   string
+		secret bool
+	}{
+		{"username", "alice", false},
+		{"email", "alice@example.com", false},
+		{"addr:1", "bob@example.com", false},
+		{"host", "example.com", false},
+		{"detail:pin", "1234", true},
+		{"detail:Account", "5678", false},
+		{"password", kflib.HashedChars(0, kflib.AllChars, "secret", "example.com", ""), true},
+	}
+	for _, tc := range tests {
+		got, secret, err := kflib.RecordField(db, r, "", tc.name)
+		if err != nil {
+			t.Errorf("RecordField %q: unexpected error: %v", tc.name, err)
+		} else if got != tc.want || secret != tc.secret {
+			t.Errorf("RecordField %q: got (%q, %v), want (%q, %v)", tc.name, got, secret, tc.want, tc.secret)
+		}
+	}
+	if otp, secret, err := kflib.RecordField(db, r, "", "otp"); err != nil || len(otp) != 6 || !secret {
+		t.Errorf("RecordField otp: got (%q, %v, %v), want 6-digit secret", otp, secret, err)
+	}
+
+	for _, bad := range []string{"bogus", "title", "notes", "addr:2", "addr:x", "detail:nonesuch", "detail:dup"} {
+		if got, _, err := kflib.RecordField(db, r, "", bad); err == nil {
+			t.Errorf("RecordField %q: got %q, want error", bad, got)
+		}
+	}
+}
+
+func TestAttach(t *testing.T) {
+	rec := &kfdb.Record{Label: "test"}
+
+	if replaced, err := kflib.Attach(rec, "license.txt", []byte("v1")); err != nil || replaced {
+		t.Fatalf("Attach: got (%v, %v), want (false, nil)", replaced, err)
+	}
+	if replaced, err := kflib.Attach(rec, "license.txt", []byte("v2")); err != nil || !replaced {
+		t.Fatalf("Attach: got (%v, %v), want (true, nil)", replaced, err)
+	}
+	if _, err := kflib.Attach(rec, "id_ed25519", []byte("\x00\x01binary")); err != nil {
+		t.Fatalf("Attach: unexpected error: %v", err)
+	}
+	want := []*kfdb.Attachment{
+		{Name: "license.txt", Type: "text/plain; charset=utf-8", Content: []byte("v2")},
+		{Name: "id_ed25519", Type: "application/octet-stream", Content: []byte("\x00\x01binary")},
+	}
+	if diff := gocmp.Diff(rec.Attachments, want); diff != "" {
+		t.Errorf("Attachments (-got, +want):\n%s", diff)
+	}
+
+	big := make([]byte, kflib.MaxAttachmentSize+1)
+	if _, err := kflib.Attach(rec, "big", big); err == nil {
+		t.Error("Attach oversize: got nil, want error")
+	}
+	if _, err := kflib.Attach(rec, "", []byte("x")); err == nil {
+		t.Error("Attach empty name: got nil, want error")
+	}
+	if len(rec.Attachments) != 2 {
+		t.Errorf("Got %d attachments, want 2", len(rec.Attachments))
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	mtest.Swap(t, kflib.VerifierCost, 4) // bcrypt.MinCost, for speed
+
+	rec := &kfdb.Record{Label: "test", Password: "stored"}
+	if _, err := kflib.CheckVerifier(rec, "stored"); err == nil {
+		t.Error("CheckVerifier: got nil, want error for missing verifier")
+	}
+	if err := kflib.SetVerifier(rec, ""); err == nil {
+		t.Error("SetVerifier: got nil, want error for empty password")
+	}
+	if err := kflib.SetVerifier(rec, strings.Repeat("x", 73)); err == nil {
+		t.Error("SetVerifier: got nil, want error for long password")
+	}
+
+	const secret = "correct horse battery staple"
+	if err := kflib.SetVerifier(rec, secret); err != nil {
+		t.Fatalf("SetVerifier: unexpected error: %v", err)
+	}
+	if strings.Contains(rec.PasswordHash, secret) {
+		t.Errorf("Verifier %q contains the password", rec.PasswordHash)
+	}
+	if rec.Password != "stored" {
+		t.Errorf("Password: got %q, want unchanged", rec.Password)
+	}
+	for _, tc := range []struct {
+		pw   string
+		want bool
+	}{
+		{secret, true},
+		{"stored", false},
+		{"", false},
+		{secret + " ", false},
+	} {
+		got, err := kflib.CheckVerifier(rec, tc.pw)
+		if err != nil {
+			t.Errorf("CheckVerifier(%q): unexpected error: %v", tc.pw, err)
+		} else if got != tc.want {
+			t.Errorf("CheckVerifier(%q): got %v, want %v", tc.pw, got, tc.want)
+		}
+	}
+
+	if cp, err := kflib.CloneRecord(rec, "copy"); err != nil {
+		t.Errorf("CloneRecord: unexpected error: %v", err)
+	} else if cp.PasswordHash != "" {
+		t.Errorf("CloneRecord: verifier was copied: %q", cp.PasswordHash)
+	}
+
+	rec.PasswordHash = "bogus"
+	if _, err := kflib.CheckVerifier(rec, secret); err == nil {
+		t.Error("CheckVerifier: got nil, want error for malformed verifier")
+	}
+	if errs := kflib.ValidateDB(&kfdb.DB{Records: []*kfdb.Record{rec}}); len(errs) != 1 {
+		t.Errorf("ValidateDB: got %v, want 1 error", errs)
+	}
+}
+
+func TestEffectiveHashpass(t *testing.T) {
+	db := &kfdb.DB{
+		Defaults: &kfdb.Defaults{Hashpass: &kfdb.Hashpass{SecretKey: "key", Length: 12, Punct: new(bool)}},
+		Records: []*kfdb.Record{
+			{Label: "host", Hosts: kfdb.Strings{"example.com"}},
+			{Label: "seed", Hashpass: &kfdb.Hashpass{Seed: "xyzzy", Length: 4, Alphabet: kfdb.Strings{"digit"}}},
+			{Label: "none"},
+		},
+	}
+	tests := []struct {
+		label, tag string
+		want       *kflib.HashpassSettings
+	}{
+		{"host", "", &kflib.HashpassSettings{
+			Seed: "example.com", Length: 12, Alphabet: (kflib.AllChars &^ kflib.Symbols).Alphabet(),
+		}},
+		{"host", "work", &kflib.HashpassSettings{
+			Seed: "example.com", Tag: "work", Length: 12, Alphabet: (kflib.AllChars &^ kflib.Symbols).Alphabet(),
+		}},
+		{"seed", "", &kflib.HashpassSettings{Seed: "xyzzy", Length: 8, Alphabet: "0123456789"}},
+	}
+	for _, tc := range tests {
+		rec := db.Records[slices.IndexFunc(db.Records, func(r *kfdb.Record) bool { return r.Label == tc.label })]
+		got, err := kflib.EffectiveHashpass(db, rec, tc.tag)
+		if err != nil {
+			t.Errorf("EffectiveHashpass(%q, %q): unexpected error: %v", tc.label, tc.tag, err)
+			continue
+		}
+		if diff := gocmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("EffectiveHashpass(%q, %q) (-got, +want):\n%s", tc.label, tc.tag, diff)
+		}
+
+		// The settings must reproduce the generated password.
+		want, err := kflib.GenerateHashpass(db, rec, tc.tag)
+		if err != nil {
+			t.Fatalf("GenerateHashpass: unexpected error: %v", err)
+		}
+		pw, err := kflib.HashedCharsAlphabet(got.Length, got.Alphabet, "key", got.Seed, got.Tag)
+		if err != nil {
+			t.Fatalf("HashedCharsAlphabet: unexpected error: %v", err)
+		}
+		if pw != want {
+			t.Errorf("Password from settings: got %q, want %q", pw, want)
+		}
+	}
+
+	if got, err := kflib.EffectiveHashpass(db, db.Records[2], ""); err == nil {
+		t.Errorf("EffectiveHashpass(none): got %+v, want error", got)
+	}
+}
+
+func TestInsertAddressTag(t *testing.T) {
+	tests := []struct {
+		addr, tag, want string
+	}{
+		{"user@example.com", "shop", "user+shop@example.com"},
+		{"user+old@example.com", "new", "user+new@example.com"},
+		{"user+old@example.com", "", "user@example.com"},
+		{"user@example.com", "", "user@example.com"},
+		{`"odd@local"@example.com`, "x", `"odd@local"+x@example.com`},
+	}
+	for _, tc := range tests {
+		got, err := kflib.InsertAddressTag(tc.addr, tc.tag)
+		if err != nil {
+			t.Errorf("InsertAddressTag(%q, %q): unexpected error: %v", tc.addr, tc.tag, err)
+		} else if got != tc.want {
+			t.Errorf("InsertAddressTag(%q, %q): got %q, want %q", tc.addr, tc.tag, got, tc.want)
+		}
+	}
+	for _, bad := range [][2]string{
+		{"", "x"},
+		{"@example.com", "x"},
+		{"user@", "x"},
+		{"user", "x"},
+		{"user@example.com", "a+b"},
+		{"user@example.com", "a b"},
+		{"user@example.com", "a@b"},
+	} {
+		if got, err := kflib.InsertAddressTag(bad[0], bad[1]); err == nil {
+			t.Errorf("InsertAddressTag(%q, %q): got %q, want error", bad[0], bad[1], got)
+		}
+	}
+
+	db := &kfdb.DB{}
+	rec := &kfdb.Record{Label: "test"}
+	if got, err := kflib.TaggedAddress(db, "x"); err == nil {
+		t.Errorf("TaggedAddress: got %q, want error without a default", got)
+	}
+	if got, err := kflib.RecordAddress(db, rec); err == nil {
+		t.Errorf("RecordAddress: got %q, want error without an address", got)
+	}
+
+	db.Defaults = &kfdb.Defaults{EMail: "me@example.org"}
+	if got, err := kflib.TaggedAddress(db, "site"); err != nil || got != "me+site@example.org" {
+		t.Errorf("TaggedAddress: got %q, %v; want me+site@example.org", got, err)
+	}
+	if got, err := kflib.RecordAddress(db, rec); err != nil || got != "me@example.org" {
+		t.Errorf("RecordAddress (default): got %q, %v; want me@example.org", got, err)
+	}
+	rec.Addrs = kfdb.Strings{"other@example.net"}
+	if got, err := kflib.RecordAddress(db, rec); err != nil || got != "other@example.net" {
+		t.Errorf("RecordAddress (record): got %q, %v; want other@example.net", got, err)
+	}
+}
@@ -0,0 +1,93 @@
+package kflib
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/creachadair/atomicfile"
+	"github.com/creachadair/keyfish/kfstore"
+	"github.com/creachadair/mds/mbits"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrNoPFileKey is reported by ReadPFile when the passphrase file is encrypted
+// but no key was provided to decrypt it.
+var ErrNoPFileKey = errors.New("passphrase file is encrypted, but no key is available")
+
+// pfileData is the content of an encrypted passphrase file.
+type pfileData struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// ReadPFile reads a database passphrase from the file at path.  The file may
+// contain the passphrase in plain text, in which case leading and trailing
+// whitespace are removed, or it may be a store written by WritePFile, in which
+// case key is used to decrypt it.  If the file is encrypted and key == "",
+// ReadPFile reports ErrNoPFileKey.
+func ReadPFile(path, key string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !isStore(data) {
+		return strings.TrimSpace(string(data)), nil
+	} else if key == "" {
+		return "", ErrNoPFileKey
+	}
+	s, err := kfstore.Open[pfileData](bytes.NewReader(data), pfileKey(key))
+	if err != nil {
+		return "", fmt.Errorf("open passphrase file: %w", err)
+	}
+	return s.DB().Passphrase, nil
+}
+
+// WritePFile writes passphrase to the file at path, encrypted with an access
+// key derived from key, in a format that can be read by ReadPFile.
+func WritePFile(path, passphrase, key string) error {
+	if key == "" {
+		return errors.New("empty passphrase file key")
+	}
+	salt := make([]byte, kfstore.AccessKeyLen)
+	if _, err := crand.Read(salt); err != nil {
+		return fmt.Errorf("generate key salt: %w", err)
+	}
+	akey := pfileKey(key)(salt)
+	s, err := kfstore.New(akey, salt, &pfileData{Passphrase: passphrase})
+	mbits.Zero(akey)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Tx(path, 0600, func(f *atomicfile.File) error {
+		_, err := s.WriteTo(f)
+		return err
+	})
+}
+
+// isStore reports whether data appears to be an encoded kfstore, as indicated
+// by its format label.
+func isStore(data []byte) bool {
+	var probe struct {
+		Format string `json:"format"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.Format == kfstore.Format
+}
+
+// pfileKey returns a function that derives a passphrase file access key from
+// key and a salt.
+func pfileKey(key string) kfstore.KeyFunc {
+	return func(salt []byte) []byte {
+		h := hkdf.New(sha256.New, []byte(key), salt, []byte("keyfish pfile"))
+		out := make([]byte, kfstore.AccessKeyLen)
+		if _, err := io.ReadFull(h, out); err != nil {
+			panic(fmt.Sprintf("derive key: %v", err))
+		}
+		return out
+	}
+}
@@ -0,0 +1,96 @@
+package kflib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/creachadair/mds/value"
+	"golang.org/x/term"
+)
+
+// maxPick is the maximum number of candidates offered by PickRecord.
+const maxPick = 9
+
+// PickRecord presents the candidate records in found as a menu, and lets the
+// user choose one with the arrow keys (or j and k) and Enter, or by typing its
+// number. Only the first few candidates are offered, so found should be in
+// order of decreasing match quality, as returned by FindRecords. Pressing q,
+// Escape, or Ctrl-C cancels the selection, and PickRecord reports
+// ErrUserReject.
+//
+// The menu is written to stderr, and input is read from stdin, which must be
+// a terminal.
+func PickRecord(found []FoundRecord) (FoundRecord, error) {
+	fd := int(os.Stdin.Fd())
+	oldst, err := term.MakeRaw(fd)
+	if err != nil {
+		return FoundRecord{}, err
+	}
+	defer term.Restore(fd, oldst)
+	return pickRecord(os.Stdin, os.Stderr, found)
+}
+
+// pickRecord implements PickRecord, reading keystrokes from r and drawing the
+// menu to w. It assumes the terminal is already in raw mode.
+func pickRecord(r io.Reader, w io.Writer, found []FoundRecord) (FoundRecord, error) {
+	var more int
+	if len(found) > maxPick {
+		found, more = found[:maxPick], len(found)-maxPick
+	}
+	cur := 0
+	draw := func() {
+		for i, fr := range found {
+			title := fr.Record.Title
+			if fr.Record.Label == "" || title == fr.Record.Label {
+				title = ""
+			}
+			fmt.Fprintf(w, "\r\x1b[K%s %d. %s%s\r\n", value.Cond(i == cur, "▶", " "),
+				i+1, value.Cond(fr.Record.Label == "", fr.Record.Title, fr.Record.Label),
+				value.Cond(title == "", "", " — "+title))
+		}
+		if more != 0 {
+			fmt.Fprintf(w, "\r\x1b[K  (%d more not shown)\r\n", more)
+		}
+	}
+	redraw := func() {
+		fmt.Fprintf(w, "\x1b[%dA", len(found)+value.Cond(more != 0, 1, 0))
+		draw()
+	}
+	fmt.Fprint(w, "▷ Choose a record (↑/↓ and Enter, or q to cancel):\r\n")
+	draw()
+
+	buf := make([]byte, 64)
+	for {
+		nr, err := r.Read(buf)
+		if nr == 0 && err != nil {
+			return FoundRecord{}, err
+		}
+		for keys := buf[:nr]; len(keys) != 0; {
+			// An arrow key arrives as a three-byte escape sequence.
+			key := string(keys[:1])
+			if len(keys) >= 3 && strings.HasPrefix(string(keys), "\x1b[") {
+				key = string(keys[:3])
+			}
+			keys = keys[len(key):]
+
+			switch key {
+			case "\x1b[A", "k":
+				cur = (cur + len(found) - 1) % len(found)
+			case "\x1b[B", "j":
+				cur = (cur + 1) % len(found)
+			case "\r", "\n":
+				return found[cur], nil
+			case "q", "\x1b", "\x03":
+				return FoundRecord{}, ErrUserReject
+			default:
+				if c := key[0]; c >= '1' && int(c-'0') <= len(found) {
+					return found[c-'1'], nil
+				}
+				continue // ignore other keys
+			}
+			redraw()
+		}
+	}
+}
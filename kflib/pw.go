@@ -1,13 +1,19 @@
 package kflib
 
 import (
+	"cmp"
 	crand "crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/bits"
+	"os"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	_ "embed"
 
@@ -24,17 +30,57 @@ var (
 	wordList string
 
 	words       []string
-	bitsPerWord int
 	wordListLen uint64
 )
 
 func init() {
-	words = strings.Split(strings.TrimSpace(wordList), "\n")
-	if len(words) < 256 {
-		panic(fmt.Sprintf("word list has only %d elements", len(words)))
+	if err := LoadWordList(""); err != nil {
+		panic(err)
+	}
+}
+
+// LoadWordList replaces the word list used by RandomWords with the contents of
+// the specified file. The file must contain one word per line; blank lines are
+// ignored. If a line contains multiple fields separated by whitespace, only the
+// last is used, so that word lists in diceware format ("11111 word") may be
+// used directly. If path == "", the built-in word list is restored.
+//
+// The list must contain at least 256 distinct words.
+//
+// LoadWordList is not safe for concurrent use with RandomWords.
+func LoadWordList(path string) error {
+	if path == "" {
+		return setWordList(strings.Split(strings.TrimSpace(wordList), "\n"))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read word list: %w", err)
+	}
+	var list []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if fs := strings.Fields(line); len(fs) != 0 {
+			list = append(list, fs[len(fs)-1])
+		}
+	}
+	return setWordList(list)
+}
+
+// setWordList checks that list is a valid word list, and if so installs it as
+// the active word list.
+func setWordList(list []string) error {
+	if len(list) < 256 {
+		return fmt.Errorf("word list has only %d elements", len(list))
 	}
-	bitsPerWord = int(math.Ceil(math.Log2(float64(len(words))))) // round up
+	seen := make(map[string]bool, len(list))
+	for _, w := range list {
+		if seen[w] {
+			return fmt.Errorf("word list has duplicate entry %q", w)
+		}
+		seen[w] = true
+	}
+	words = list
 	wordListLen = uint64(len(words))
+	return nil
 }
 
 // Charset is a bit mask specifying which letters to use in a character-based
@@ -58,50 +104,335 @@ const (
 // RandomChars creates a new randomly-generated password of the given length
 // and using the specified character types. A minimum length of 8 is enforced.
 func RandomChars(length int, charset Charset) string {
-	length = max(length, 8)
+	return RandomCharsFrom(crand.Reader, length, charset)
+}
+
+// RandomCharsFrom is as RandomChars, but reads randomness from rng instead of
+// from crypto/rand. The caller is responsible for the quality of rng.
+func RandomCharsFrom(rng io.Reader, length int, charset Charset) string {
+	length = max(length, minHashLength)
 	out := make([]byte, length)
-	fillRandom(out, expandCharset(charset), crand.Reader)
+	fillRandom(out, expandCharset(charset), rng)
 	return string(out)
 }
 
+// RandomCharsCustom is as RandomChars, but if symbols != "" and charset
+// includes Symbols, symbols replaces the default set of punctuation.  It
+// reports an error if symbols is not a valid symbol set (see CheckSymbols).
+func RandomCharsCustom(length int, charset Charset, symbols string) (string, error) {
+	return RandomCharsCustomFrom(crand.Reader, length, charset, symbols)
+}
+
+// RandomCharsCustomFrom is as RandomCharsCustom, but reads randomness from rng
+// instead of from crypto/rand.
+func RandomCharsCustomFrom(rng io.Reader, length int, charset Charset, symbols string) (string, error) {
+	chars, err := expandCharsetCustom(charset, symbols)
+	if err != nil {
+		return "", err
+	}
+	length = max(length, minHashLength)
+	out := make([]byte, length)
+	fillRandom(out, chars, rng)
+	return string(out), nil
+}
+
+// RandomCharsEnsure is as RandomCharsCustom, but guarantees that the result
+// contains at least one character from each of the character types selected
+// by charset.  This is useful for sites whose password rules require, for
+// example, "at least one digit".
+//
+// One character from each selected type is chosen and placed at a random
+// position, the remaining positions are filled uniformly from the combined
+// alphabet. Because passwords lacking one of the types cannot be generated,
+// the entropy of the result is slightly less than for RandomChars with the
+// same settings. The reduction is small: For a length-12 password of letters
+// and digits it is less than 0.2 bits.
+func RandomCharsEnsure(length int, charset Charset, symbols string) (string, error) {
+	return RandomCharsEnsureFrom(crand.Reader, length, charset, symbols)
+}
+
+// RandomCharsEnsureFrom is as RandomCharsEnsure, but reads randomness from rng
+// instead of from crypto/rand.
+func RandomCharsEnsureFrom(rng io.Reader, length int, charset Charset, symbols string) (string, error) {
+	chars, err := expandCharsetCustom(charset, symbols)
+	if err != nil {
+		return "", err
+	}
+	length = max(length, minHashLength)
+	out := make([]byte, length)
+	fillRandom(out, chars, rng)
+
+	// Replace a prefix of the output with one character of each type, then
+	// shuffle so the required characters do not have fixed positions.
+	classes := []string{pwLetters}
+	if charset&Digits != 0 {
+		classes = append(classes, pwDigits)
+	}
+	if charset&Symbols != 0 {
+		classes = append(classes, cmp.Or(symbols, pwSymbols))
+	}
+	for i, class := range classes {
+		fillRandom(out[i:i+1], class, rng)
+	}
+	shuffle(out, rng)
+	return string(out), nil
+}
+
+// minHashLength is the minimum length of a password generated by HashedChars
+// and HashedCharsAlphabet.
+const minHashLength = 8
+
 // HashedChars creates a new HKDF password of the given length using the
 // specified character types. A minimum length of 8 is enforced.
 //
 // The passphrase is a strong secret passphrase. The seed is not secret, but
 // must be fixed for a given context. The salt is optional, if non-empty it is
 // mixed in to the HKDF as additional context.
+//
+// The output for given inputs is a compatibility contract, and must not change
+// between versions. Golden values are pinned by the tests.
 func HashedChars(length int, charset Charset, passphrase, seed, salt string) string {
 	rng := hkdf.New(sha256.New, []byte(passphrase), []byte(seed), []byte(salt))
-	length = max(length, 8)
+	length = max(length, minHashLength)
 	out := make([]byte, length)
-	fillRandom(out, expandCharset(charset), rng)
+	fillHashed(out, expandCharset(charset), rng)
 	return string(out)
 }
 
+// HashedCharsCustom is as HashedChars, but if symbols != "" and charset
+// includes Symbols, symbols replaces the default set of punctuation.  It
+// reports an error if symbols is not a valid symbol set (see CheckSymbols).
+func HashedCharsCustom(length int, charset Charset, symbols, passphrase, seed, salt string) (string, error) {
+	chars, err := expandCharsetCustom(charset, symbols)
+	if err != nil {
+		return "", err
+	}
+	rng := hkdf.New(sha256.New, []byte(passphrase), []byte(seed), []byte(salt))
+	length = max(length, minHashLength)
+	out := make([]byte, length)
+	fillHashed(out, chars, rng)
+	return string(out), nil
+}
+
+// HashedCharsAlphabet is as HashedChars, but chooses characters from the
+// given alphabet, as returned by ParseAlphabet. The order of the alphabet
+// affects the output. For any charset c,
+//
+//	HashedCharsAlphabet(n, c.Alphabet(), ...) == HashedChars(n, c, ...)
+//
+// It reports an error if the alphabet has fewer than 2 characters.
+func HashedCharsAlphabet(length int, alphabet, passphrase, seed, salt string) (string, error) {
+	if len(alphabet) < 2 {
+		return "", fmt.Errorf("alphabet %q is too small", alphabet)
+	}
+	rng := hkdf.New(sha256.New, []byte(passphrase), []byte(seed), []byte(salt))
+	length = max(length, minHashLength)
+	out := make([]byte, length)
+	fillHashed(out, alphabet, rng)
+	return string(out), nil
+}
+
+// Alphabet returns the characters denoted by c, in the order used to generate
+// passwords.
+func (c Charset) Alphabet() string { return expandCharset(c) }
+
+// alphabetClasses are the named character classes accepted by ParseAlphabet.
+var alphabetClasses = map[string]string{
+	"upper":  pwLetters[:26],
+	"lower":  pwLetters[26:],
+	"letter": pwLetters,
+	"digit":  pwDigits,
+	"punct":  pwSymbols,
+}
+
+// ParseAlphabet returns the alphabet described by specs. Each spec is either
+// the name of a character class, or "chars:" followed by literal characters.
+// The classes are:
+//
+//	upper   -- capital ASCII letters
+//	lower   -- lowercase ASCII letters
+//	letter  -- capital and lowercase ASCII letters (upper, then lower)
+//	digit   -- ASCII decimal digits
+//	punct   -- the default punctuation symbols
+//
+// The resulting alphabet contains the characters of each spec, in order, with
+// duplicates removed. Literal characters must be printable ASCII other than
+// space. ParseAlphabet reports an error if a spec is not understood, or if
+// the result has fewer than 2 characters.
+func ParseAlphabet(specs []string) (string, error) {
+	var seen [128]bool
+	var sb strings.Builder
+	for _, spec := range specs {
+		chars, ok := alphabetClasses[spec]
+		if !ok {
+			chars, ok = strings.CutPrefix(spec, "chars:")
+			if !ok {
+				return "", fmt.Errorf("unknown alphabet spec %q", spec)
+			}
+		}
+		for i := range len(chars) {
+			c := chars[i]
+			if c <= ' ' || c > '~' {
+				return "", fmt.Errorf("invalid character %q in alphabet spec %q", c, spec)
+			} else if !seen[c] {
+				seen[c] = true
+				sb.WriteByte(c)
+			}
+		}
+	}
+	if sb.Len() < 2 {
+		return "", fmt.Errorf("alphabet %q is too small", sb.String())
+	}
+	return sb.String(), nil
+}
+
+// CheckSymbols reports whether symbols is a valid custom symbol set.  A valid
+// symbol set is non-empty, and consists only of distinct printable ASCII
+// characters other than letters, digits, and space.
+func CheckSymbols(symbols string) error {
+	if symbols == "" {
+		return errors.New("empty symbol set")
+	}
+	var seen [128]bool
+	for i := range len(symbols) {
+		c := symbols[i]
+		switch {
+		case c <= ' ' || c > '~':
+			return fmt.Errorf("invalid symbol %q at offset %d", c, i)
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			return fmt.Errorf("letter or digit %q at offset %d is not a symbol", c, i)
+		case seen[c]:
+			return fmt.Errorf("duplicate symbol %q at offset %d", c, i)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
 // RandomWords creates a new randomly-generated password comprising the
 // specified number of wordlist entries. The words are separated by the
 // specified joiner.  A minimum of 3 words is enforced.
 func RandomWords(numWords int, joiner string) string {
+	return RandomWordsWith(numWords, joiner, WordOptions{})
+}
+
+// WordOptions are optional settings for RandomWordsWith.
+type WordOptions struct {
+	// Digits, if positive, is the number of random decimal digits to append
+	// to the password as a separate group after the words.
+	Digits int
+
+	// TitleCase, if true, capitalizes the first letter of one randomly-chosen
+	// word of the password.
+	TitleCase bool
+}
+
+// RandomWordsWith is as RandomWords, but applies the given options to the
+// generated password. With zero options, it is equivalent to RandomWords.
+func RandomWordsWith(numWords int, joiner string, opts WordOptions) string {
+	return RandomWordsFrom(crand.Reader, numWords, joiner, opts)
+}
+
+// RandomWordsFrom is as RandomWordsWith, but reads randomness from rng instead
+// of from crypto/rand.
+func RandomWordsFrom(rng io.Reader, numWords int, joiner string, opts WordOptions) string {
 	numWords = max(numWords, 3)
 	out := make([]string, numWords)
-	var bits uint64 // entropy bits
-	var nb int      // unconsumed entropy count
+	src := &bitSource{rng: rng}
 	for i := range numWords {
-		if nb < bitsPerWord {
-			bits, nb = randomUint64(crand.Reader), 64
-		}
-		out[i] = words[int(bits%wordListLen)]
-		bits /= wordListLen
-		nb -= bitsPerWord
+		out[i] = words[src.uniform(wordListLen)]
+	}
+	if opts.TitleCase {
+		i := src.uniform(uint64(numWords))
+		r, n := utf8.DecodeRuneInString(out[i])
+		out[i] = string(unicode.ToTitle(r)) + out[i][n:]
+	}
+	if opts.Digits > 0 {
+		ds := make([]byte, opts.Digits)
+		fillRandom(ds, pwDigits, rng)
+		out = append(out, string(ds))
 	}
 	return strings.Join(out, joiner)
 }
 
+// RandomPronounceable creates a new randomly-generated password of the given
+// length comprising alternating lowercase consonants and vowels, so that the
+// result can be read aloud as a sequence of syllables. A minimum length of 12
+// is enforced, since each character carries less entropy than for RandomChars.
+//
+// Each consonant-vowel pair carries log2(18*5) ≈ 6.49 bits of entropy, or
+// approximately 3.25 bits per character.
+func RandomPronounceable(length int) string {
+	return RandomPronounceableFrom(crand.Reader, length)
+}
+
+// RandomPronounceableFrom is as RandomPronounceable, but reads randomness from
+// rng instead of from crypto/rand.
+func RandomPronounceableFrom(rng io.Reader, length int) string {
+	length = max(length, 12)
+	out := make([]byte, length)
+	fillRandomAlt(out, []string{pwConsonants, pwVowels}, rng)
+	return string(out)
+}
+
+// CharsEntropy returns an estimate of the entropy in bits of a password
+// generated by RandomChars with the given length and character types.  The
+// minimum length enforced by RandomChars is taken into account.
+func CharsEntropy(length int, charset Charset) float64 {
+	length = max(length, minHashLength)
+	return float64(length) * math.Log2(float64(len(expandCharset(charset))))
+}
+
+// CharsEntropyCustom is as CharsEntropy, for a password generated by
+// RandomCharsCustom with the given symbol set.
+func CharsEntropyCustom(length int, charset Charset, symbols string) (float64, error) {
+	chars, err := expandCharsetCustom(charset, symbols)
+	if err != nil {
+		return 0, err
+	}
+	length = max(length, minHashLength)
+	return float64(length) * math.Log2(float64(len(chars))), nil
+}
+
+// WordsEntropy returns an estimate of the entropy in bits of a password
+// generated by RandomWords with the given number of words. The minimum number
+// of words enforced by RandomWords is taken into account.
+func WordsEntropy(numWords int) float64 {
+	return WordsEntropyWith(numWords, WordOptions{})
+}
+
+// WordsEntropyWith returns an estimate of the entropy in bits of a password
+// generated by RandomWordsWith with the given number of words and options.
+func WordsEntropyWith(numWords int, opts WordOptions) float64 {
+	numWords = max(numWords, 3)
+	bits := float64(numWords) * math.Log2(float64(len(words)))
+	if opts.TitleCase {
+		bits += math.Log2(float64(numWords))
+	}
+	if opts.Digits > 0 {
+		bits += float64(opts.Digits) * math.Log2(float64(len(pwDigits)))
+	}
+	return bits
+}
+
+// PronounceableEntropy returns an estimate of the entropy in bits of a
+// password generated by RandomPronounceable with the given length. The minimum
+// length enforced by RandomPronounceable is taken into account.
+func PronounceableEntropy(length int) float64 {
+	length = max(length, 12)
+	nc := (length + 1) / 2 // consonants are in even positions
+	return float64(nc)*math.Log2(float64(len(pwConsonants))) +
+		float64(length-nc)*math.Log2(float64(len(pwVowels)))
+}
+
 const (
 	pwLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz" // 52 letters
 	pwDigits  = "0123456789"                                           // 10 digits
 	pwSymbols = `!#$%&()*+,-./:;<=>?@[]^_{|}~`                         // 28 symbols
 
+	pwConsonants = "bcdfghjklmnprstvwz" // 18 consonants
+	pwVowels     = "aeiou"              // 5 vowels
+
 	// This list of symbols is based on
 	// https://owasp.org/www-community/password-special-characters.
 	// Removed: space, single quote, double quote, backquote, backslash
@@ -109,6 +440,11 @@ const (
 	// The number of entropy bits to charge for each character.  This is an
 	// overestimate safe to use regardless which subset of alphabets are
 	// selected. If you change the alphabets, update this constant.
+	//
+	// Custom symbol sets are limited by CheckSymbols to printable ASCII, so
+	// the largest possible alphabet is log2(52 + 10 + 32) = 6.555, which still
+	// rounds up to 7.  Do not reduce this value: It would change the output
+	// of existing hashed passwords.
 	bitsPerChar = 7 // log2(52 + 10 + 28) = 6.492, round up to 7
 )
 
@@ -124,6 +460,28 @@ func randomUint64(rng io.Reader) uint64 {
 // fillRandom populates out with a random password on the given alphabet using
 // rng as the source of randomness.
 func fillRandom(out []byte, chars string, rng io.Reader) {
+	fillRandomAlt(out, []string{chars}, rng)
+}
+
+// fillRandomAlt populates out with a random password using rng as the source
+// of randomness. The alphabets are used in rotation, so that position i of
+// out is drawn from alphabets[i%len(alphabets)].
+func fillRandomAlt(out []byte, alphabets []string, rng io.Reader) {
+	src := &bitSource{rng: rng}
+	for i := range out {
+		chars := alphabets[i%len(alphabets)]
+		out[i] = chars[src.uniform(uint64(len(chars)))]
+	}
+}
+
+// fillHashed populates out with a password on the given alphabet using rng as
+// the source of randomness.
+//
+// Unlike fillRandom, the choice of each character is made by reducing the
+// entropy modulo the size of the alphabet, which is very slightly biased when
+// the alphabet size is not a power of two. This is used for hashed passwords,
+// whose output must remain stable for existing configurations.
+func fillHashed(out []byte, chars string, rng io.Reader) {
 	clen := uint64(len(chars))
 
 	var bits uint64 // entropy bits
@@ -138,6 +496,48 @@ func fillRandom(out []byte, chars string, rng io.Reader) {
 	}
 }
 
+// shuffle permutes the contents of out uniformly using rng as the source of
+// randomness.
+func shuffle(out []byte, rng io.Reader) {
+	src := &bitSource{rng: rng}
+	for i := len(out) - 1; i > 0; i-- {
+		j := src.uniform(uint64(i + 1))
+		out[i], out[j] = out[j], out[i]
+	}
+}
+
+// A bitSource doles out random bits read from an underlying reader.
+type bitSource struct {
+	rng  io.Reader
+	bits uint64 // unconsumed entropy bits
+	nb   int    // number of unconsumed bits
+}
+
+// next returns a random value of width bits, 0 ≤ width ≤ 64.
+func (b *bitSource) next(width int) uint64 {
+	if width == 0 {
+		return 0
+	} else if b.nb < width {
+		b.bits, b.nb = randomUint64(b.rng), 64
+	}
+	v := b.bits & (1<<width - 1)
+	b.bits >>= width % 64
+	b.nb -= width
+	return v
+}
+
+// uniform returns a uniformly-chosen random value in the range 0 ≤ v < n.
+// It uses rejection sampling, discarding values of the drawn width that are
+// out of range, so that the result is not biased when n is not a power of 2.
+func (b *bitSource) uniform(n uint64) uint64 {
+	width := bits.Len64(n - 1)
+	for {
+		if v := b.next(width); v < n {
+			return v
+		}
+	}
+}
+
 // expandCharset returns the alphabet described by c.
 func expandCharset(c Charset) string {
 	chars := pwLetters
@@ -149,3 +549,18 @@ func expandCharset(c Charset) string {
 	}
 	return chars
 }
+
+// expandCharsetCustom returns the alphabet described by c, with symbols
+// replacing the default punctuation if it is non-empty.
+func expandCharsetCustom(c Charset, symbols string) (string, error) {
+	if symbols == "" {
+		return expandCharset(c), nil
+	} else if err := CheckSymbols(symbols); err != nil {
+		return "", err
+	}
+	chars := expandCharset(c &^ Symbols)
+	if c&Symbols != 0 {
+		chars += symbols
+	}
+	return chars, nil
+}
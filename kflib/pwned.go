@@ -0,0 +1,62 @@
+package kflib
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// pwnedRangeURL is the base URL of the HaveIBeenPwned password range API.
+const pwnedRangeURL = "https://api.pwnedpasswords.com/range/"
+
+// CheckPwnedPassword reports the number of times pw has been seen in data
+// breaches known to the HaveIBeenPwned service. A count of zero means pw was
+// not found.
+//
+// This function performs a network request. To preserve privacy it uses the
+// k-anonymity range API: only the first 5 hex digits of the SHA-1 digest of
+// pw are sent, and the remainder of the digest is matched locally. Neither
+// the password nor its complete digest ever leave the machine.
+func CheckPwnedPassword(ctx context.Context, pw string) (count int, err error) {
+	sum := sha1.Sum([]byte(pw))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pwnedRangeURL+prefix, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Add-Padding", "true") // obscure the response size
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("check password: %w", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("check password: unexpected status %s", rsp.Status)
+	}
+
+	// Each line of the response has the form "SUFFIX:COUNT". Padding entries
+	// have a count of zero, so they do not need special treatment.
+	sc := bufio.NewScanner(rsp.Body)
+	for sc.Scan() {
+		hash, num, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if !ok || !strings.EqualFold(hash, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("check password: invalid count %q", num)
+		}
+		return n, nil
+	}
+	if err := sc.Err(); err != nil {
+		return 0, fmt.Errorf("check password: %w", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,141 @@
+package kflib
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/keyfish/kfdb"
+	"github.com/creachadair/mds/value"
+	"github.com/creachadair/otp/otpauth"
+)
+
+// ValidateDB checks the contents of db for structural problems, and returns an
+// error for each problem found. It returns nil if db is valid.
+//
+// The checks include:
+//
+//   - Each record has a label or a title, and labels are unique.
+//   - Record UIDs, where present, are unique.
+//   - OTP configurations have a valid type and secret.
+//   - Each detail has a label.
+//   - Hosts and aliases are plain hostnames, not URLs.
+//   - Records with a hashpass config have a seed (explicit, or from a host),
+//     and a valid alphabet if one is specified.
+//   - Password verifiers, where present, are well-formed bcrypt hashes.
+//   - Each detail of a record template has a label.
+//   - The default e-mail address, if set, has the form local@domain.
+func ValidateDB(db *kfdb.DB) []error {
+	var errs []error
+	seen := make(map[string]int)    // label → record index
+	seenUID := make(map[string]int) // UID → record index
+	for i, r := range db.Records {
+		bad := func(msg string, args ...any) {
+			name := cmp.Or(r.Label, r.Title, "unlabeled")
+			errs = append(errs, fmt.Errorf("record %d (%s): %s", i+1, name, fmt.Sprintf(msg, args...)))
+		}
+
+		if r.Label == "" && r.Title == "" {
+			bad("missing label and title")
+		} else if r.Label != "" {
+			if j, ok := seen[r.Label]; ok {
+				bad("duplicate label (also record %d)", j+1)
+			} else {
+				seen[r.Label] = i
+			}
+		}
+		if r.UID != "" {
+			if j, ok := seenUID[r.UID]; ok {
+				bad("duplicate UID (also record %d)", j+1)
+			} else {
+				seenUID[r.UID] = i
+			}
+		}
+		if r.OTP != nil {
+			if err := checkOTP(r.OTP); err != nil {
+				bad("invalid OTP config: %v", err)
+			}
+		}
+		for j, d := range r.Details {
+			if d.Label == "" {
+				bad("detail %d has no label", j+1)
+			}
+		}
+		seenAtt := make(map[string]bool)
+		for j, a := range r.Attachments {
+			if err := checkAttachment(a.Name, a.Content); err != nil {
+				bad("attachment %d: %v", j+1, err)
+			} else if seenAtt[a.Name] {
+				bad("duplicate attachment %q", a.Name)
+			}
+			seenAtt[a.Name] = true
+		}
+		for _, h := range r.Hosts {
+			if err := checkHost(h); err != nil {
+				bad("invalid host %q: %v", h, err)
+			}
+		}
+		for _, h := range r.Aliases {
+			if err := checkHost(h); err != nil {
+				bad("invalid alias %q: %v", h, err)
+			}
+		}
+		if r.PasswordHash != "" {
+			if err := checkVerifier(r.PasswordHash); err != nil {
+				bad("invalid password verifier: %v", err)
+			}
+		}
+		if r.Hashpass != nil && r.Hashpass.Seed == "" && len(r.Hosts) == 0 {
+			bad("hashpass config has no seed and no hosts")
+		}
+		if r.Hashpass != nil && len(r.Hashpass.Alphabet) != 0 {
+			if _, err := ParseAlphabet(r.Hashpass.Alphabet); err != nil {
+				bad("invalid hashpass alphabet: %v", err)
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(value.At(db.Defaults).Templates)) {
+		for j, d := range value.At(db.Defaults.Templates[name]).Details {
+			if d.Label == "" {
+				errs = append(errs, fmt.Errorf("template %q: detail %d has no label", name, j+1))
+			}
+		}
+	}
+	if base := value.At(db.Defaults).EMail; base != "" {
+		if _, err := InsertAddressTag(base, ""); err != nil {
+			errs = append(errs, fmt.Errorf("defaults: %w", err))
+		}
+	}
+	return errs
+}
+
+// checkOTP reports whether u is a usable OTP configuration.
+func checkOTP(u *otpauth.URL) error {
+	switch u.Type {
+	case "totp", "hotp":
+	default:
+		return fmt.Errorf("unknown type %q", u.Type)
+	}
+	if u.RawSecret == "" {
+		return errors.New("missing secret")
+	} else if _, err := u.Secret(); err != nil {
+		return fmt.Errorf("invalid secret: %w", err)
+	}
+	return nil
+}
+
+// checkHost reports whether h looks like a plain hostname.
+func checkHost(h string) error {
+	switch {
+	case h == "":
+		return errors.New("empty host")
+	case strings.Contains(h, "://"):
+		return errors.New("host is a URL")
+	case strings.ContainsAny(h, " \t\r\n/?#@"):
+		return errors.New("host contains invalid characters")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package kflib
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/creachadair/keyfish/kfdb"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// verifierCost is the bcrypt cost used for password verifiers. It is a
+// variable so that tests can use a cheaper cost.
+var verifierCost = bcrypt.DefaultCost
+
+// SetVerifier stores a bcrypt hash of pw as the password verifier of rec,
+// replacing any previous verifier. The password itself is not stored.
+// SetVerifier reports an error if pw is empty, or longer than bcrypt allows
+// (72 bytes).
+func SetVerifier(rec *kfdb.Record, pw string) error {
+	if pw == "" {
+		return errors.New("empty password")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), verifierCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	rec.PasswordHash = string(hash)
+	return nil
+}
+
+// CheckVerifier reports whether pw matches the password verifier of rec. It
+// reports an error if rec has no verifier, or the verifier is malformed.
+func CheckVerifier(rec *kfdb.Record, pw string) (bool, error) {
+	if rec.PasswordHash == "" {
+		return false, fmt.Errorf("record %q has no password verifier", rec.Label)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(rec.PasswordHash), []byte(pw))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("invalid password verifier: %w", err)
+	}
+	return true, nil
+}
+
+// checkVerifier reports whether hash is a well-formed password verifier.
+func checkVerifier(hash string) error {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err
+}
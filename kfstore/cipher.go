@@ -21,11 +21,10 @@ const Format = "ks1"
 // encryption key.
 type KeyFunc func(salt []byte) []byte
 
-// AccessKey returns a KeyFunc that ignores its argument and returns the
-// specified string as the key. It is a convenience wrapper for passing
-// pre-generated key.
+// AccessKey returns a KeyFunc that ignores its argument and returns a copy of
+// the specified key. It is a convenience wrapper for passing pre-generated key.
 func AccessKey[S ~string | ~[]byte](key S) KeyFunc {
-	return func(ignored []byte) []byte { return []byte(key) }
+	return func(ignored []byte) []byte { return bytes.Clone([]byte(key)) }
 }
 
 func decryptWithKey(key, data, extra []byte) ([]byte, error) {
@@ -64,9 +63,37 @@ func generateAndEncryptKey(accessKey []byte) (plain, encrypted []byte, _ error)
 	return pkey, ekey, nil
 }
 
-func compressData(data []byte) []byte {
+// Compression levels for [Store.SetCompression]. Levels from BestSpeed to
+// BestCompression select the zlib compression level. NoCompression stores the
+// data without compression.
+const (
+	NoCompression      = zlib.NoCompression
+	BestSpeed          = zlib.BestSpeed
+	BestCompression    = zlib.BestCompression
+	DefaultCompression = zlib.DefaultCompression
+	HuffmanOnly        = zlib.HuffmanOnly
+)
+
+// extraData returns the AEAD extra data for the data packet. Uncompressed
+// data are marked in the extra data, so that the mark is authenticated.
+func extraData(format string, uncompressed bool) []byte {
+	if uncompressed {
+		return []byte(format + "/raw")
+	}
+	return []byte(format)
+}
+
+// compressData compresses data with zlib at the given level.  If level is
+// NoCompression, data are returned unmodified.
+func compressData(data []byte, level int) []byte {
+	if level == NoCompression {
+		return data
+	}
 	var buf bytes.Buffer
-	w := zlib.NewWriter(&buf)
+	w, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		panic(fmt.Sprintf("zlib writer: %v", err))
+	}
 	w.Write(data)
 	if err := w.Close(); err != nil {
 		panic(fmt.Sprintf("zlib close: %v", err))
@@ -74,15 +101,16 @@ func compressData(data []byte) []byte {
 	return buf.Bytes()
 }
 
-func decompressData(data []byte) []byte {
+// decompressData reverses compressData. If uncompressed is true, data are
+// returned unmodified.
+func decompressData(data []byte, uncompressed bool) ([]byte, error) {
+	if uncompressed {
+		return data, nil
+	}
 	rc, err := zlib.NewReader(bytes.NewReader(data))
 	if err != nil {
-		panic(fmt.Sprintf("zlib reader: %v", err))
+		return nil, err
 	}
 	defer rc.Close()
-	dec, err := io.ReadAll(rc)
-	if err != nil {
-		panic(fmt.Sprintf("zlib read: %v", err))
-	}
-	return dec
+	return io.ReadAll(rc)
 }
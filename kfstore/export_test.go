@@ -0,0 +1,5 @@
+package kfstore
+
+// ZeroBuffer exposes the function used to zero plaintext and key buffers to
+// tests.
+var ZeroBuffer = &zeroBuffer
@@ -15,6 +15,8 @@
 //
 // The data value is zlib-compressed and encrypted with the data key using the
 // AEAD construction over chacha20poly1305 with the format as extra data.
+// If the store was written without compression, the object also contains
+// "uncompressed": true, and the extra data are the format followed by "/raw".
 //
 // The data key is a cryptographically randomly generated key, encrypted with a
 // user-provided access key using the AEAD construction over chacha20poly1305.
@@ -24,17 +26,25 @@
 package kfstore
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/creachadair/mds/mbits"
+	"github.com/creachadair/mds/value"
 )
 
 // A Store is an encrypted store containing a user-provide DB value.
 // The concrete type of DB must be JSON-marshalable.
 //
+// The methods of a Store are safe for concurrent use by multiple goroutines,
+// but the database returned by DB is not protected: A caller that modifies
+// it while other goroutines use the store must do so inside Update, and
+// concurrent readers should use Snapshot or WriteTo instead of DB.
+//
 // The contents of a store are encoded as a JSON object, inside which the
 // database is encrypted with chacha20poly1305 using the AEAD construction and
 // a randomly-generated data key. The data key is itself encrypted (using the
@@ -44,11 +54,17 @@ type Store[DB any] struct {
 	dataKeyEncrypted []byte // encrypted data key (used when writing updates)
 	dataKeyPlain     []byte // plaintext data key (in-memory only)
 	accessKeySalt    []byte // access key generation salt (optional)
-	db               *DB    // the unencrypted database
+	level            int    // compression level (see SetCompression)
+
+	μ  sync.RWMutex
+	db *DB // the unencrypted database
 }
 
 // New creates a new store using accessKey to encrypt the store key.
 //
+// The store does not retain accessKey, so the caller may zero it once New has
+// returned.
+//
 // If the accessKey was generated using a key-derivation function, the salt
 // value for the KDF may be passed as keySalt, and it will be stored in plain
 // text alongside the data. This value is made available to the caller when the
@@ -71,19 +87,32 @@ func New[DB any](accessKey, keySalt []byte, init *DB) (*Store[DB], error) {
 		dataKeyPlain:     plain,
 		dataKeyEncrypted: encrypted,
 		accessKeySalt:    keySalt,
+		level:            DefaultCompression,
 		db:               init,
 	}, nil
 }
 
+// MaxStoreSize is the maximum size in bytes of the encoded store that Open
+// will read. Larger inputs are rejected without reading them completely.
+var MaxStoreSize int64 = 64 << 20
+
+// ErrStoreTooLarge is reported by Open if its input exceeds MaxStoreSize.
+var ErrStoreTooLarge = errors.New("store too large")
+
 // Open opens a Store from the contents of r. Open calls accessKey with the
 // stored key derivation salt (which may be empty) to obtain the access key,
-// which is used to decrypt the stored data.
+// which is used to decrypt the stored data. Open does not modify or retain the
+// slice returned by accessKey, so the caller may zero it once Open returns.
+// If the input is longer than MaxStoreSize bytes, Open reports an error
+// wrapping ErrStoreTooLarge.
 func Open[DB any](r io.Reader, accessKey KeyFunc) (*Store[DB], error) {
 	// Consume the entire input so there cannot be extra junk at the end of the
 	// encoding when stored in a file.
-	raw, err := io.ReadAll(r)
+	raw, err := io.ReadAll(io.LimitReader(r, MaxStoreSize+1))
 	if err != nil {
 		return nil, fmt.Errorf("read input: %w", err)
+	} else if int64(len(raw)) > MaxStoreSize {
+		return nil, fmt.Errorf("%w (more than %d bytes)", ErrStoreTooLarge, MaxStoreSize)
 	}
 
 	// Decode the wrapper {"format":"ks1","dataKey":<dk>,"data":<data>,"keySalt":<salt>}
@@ -93,27 +122,36 @@ func Open[DB any](r io.Reader, accessKey KeyFunc) (*Store[DB], error) {
 		return nil, fmt.Errorf("decode input: %w", err)
 	}
 
-	// Generate the access key...
-	akey := accessKey(s.KeySalt)
+	// Generate the access key. We work on a copy, so that we can zero it
+	// without disturbing the caller's memory.
+	akey := bytes.Clone(accessKey(s.KeySalt))
 
-	// Decrypt the data key with the access key.
+	// Decrypt the data key with the access key, which is not needed after.
 	dataKey, err := decryptWithKey(akey, s.DataKey, nil)
+	zeroBuffer(akey)
 	if err != nil {
 		return nil, fmt.Errorf("decrypt data key: %w", err)
 	}
 
 	// Decrypt the data payload with the data key, and verify that the format
 	// version matches what we encrypted with.
-	data, err := decryptWithKey(dataKey, s.Data, []byte(s.Format))
+	data, err := decryptWithKey(dataKey, s.Data, extraData(s.Format, s.Uncompressed))
 	if err != nil {
 		mbits.Zero(dataKey)
 		return nil, fmt.Errorf("decrypt data: %w", err)
 	}
+	plain, err := decompressData(data, s.Uncompressed)
+	if err != nil {
+		mbits.Zero(data)
+		mbits.Zero(dataKey)
+		return nil, fmt.Errorf("decompress data: %w", err)
+	}
 
 	// Decode the database and discard the raw plaintext.
 	var db DB
-	err = json.Unmarshal(decompressData(data), &db)
+	err = json.Unmarshal(plain, &db)
 	mbits.Zero(data)
+	mbits.Zero(plain)
 	if err != nil {
 		mbits.Zero(dataKey)
 		return nil, fmt.Errorf("decode database: %w", err)
@@ -123,6 +161,7 @@ func Open[DB any](r io.Reader, accessKey KeyFunc) (*Store[DB], error) {
 		dataKeyEncrypted: s.DataKey,
 		dataKeyPlain:     dataKey,
 		accessKeySalt:    s.KeySalt,
+		level:            value.Cond(s.Uncompressed, NoCompression, DefaultCompression),
 		db:               &db,
 	}, nil
 }
@@ -133,30 +172,48 @@ func (s *Store[DB]) WriteTo(w io.Writer) (int64, error) {
 		return 0, errors.New("invalid store value")
 	}
 
+	s.μ.RLock()
 	data, err := json.Marshal(s.db)
+	level := s.level
+	s.μ.RUnlock()
 	if err != nil {
 		return 0, fmt.Errorf("encode database: %w", err)
 	}
-	encData, err := encryptWithKey(s.dataKeyPlain, compressData(data), []byte(Format))
+	defer zeroBuffer(data)
+
+	raw := level == NoCompression
+	comp := compressData(data, level)
+	if !raw {
+		defer zeroBuffer(comp)
+	}
+	encData, err := encryptWithKey(s.dataKeyPlain, comp, extraData(Format, raw))
 	if err != nil {
 		return 0, fmt.Errorf("encrypt data: %w", err)
 	}
 	pkt, err := json.Marshal(storeJSON{
-		Format:  Format,
-		DataKey: s.dataKeyEncrypted, // N.B. do not persist the plaintext
-		Data:    encData,
-		KeySalt: s.accessKeySalt,
+		Format:       Format,
+		DataKey:      s.dataKeyEncrypted, // N.B. do not persist the plaintext
+		Data:         encData,
+		KeySalt:      s.accessKeySalt,
+		Uncompressed: raw,
 	})
 	if err != nil {
-		mbits.Zero(data)
 		return 0, fmt.Errorf("encode output: %w", err)
 	}
 	nw, err := w.Write(pkt)
 	return int64(nw), err
 }
 
+// zeroBuffer zeroes a buffer of plaintext or key material when it is no
+// longer needed.  It is a variable so that tests can check that such buffers
+// are cleared.
+var zeroBuffer = mbits.Zero
+
 // DB returns the database associated with s. The result is never nil.
 // If s == nil or points to an invalid Store, DB panics.
+//
+// DB itself is safe to call concurrently, but access to the database it
+// returns is not synchronized; see Snapshot and Update.
 func (s *Store[DB]) DB() *DB {
 	if s.db == nil {
 		panic("uninitialized store")
@@ -164,6 +221,64 @@ func (s *Store[DB]) DB() *DB {
 	return s.db
 }
 
+// Snapshot returns a deep copy of the database associated with s, which the
+// caller may use freely while other goroutines update the store.
+// If s == nil or points to an invalid Store, Snapshot panics.
+func (s *Store[DB]) Snapshot() (*DB, error) {
+	if s.db == nil {
+		panic("uninitialized store")
+	}
+	s.μ.RLock()
+	data, err := json.Marshal(s.db)
+	s.μ.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("encode database: %w", err)
+	}
+	defer mbits.Zero(data)
+
+	cp := new(DB)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("decode database: %w", err)
+	}
+	return cp, nil
+}
+
+// Update calls f with the database associated with s, while holding
+// exclusive access to it. Concurrent calls to WriteTo and Snapshot wait
+// for f to return. The database must not be retained after f returns.
+// If s == nil or points to an invalid Store, Update panics.
+func (s *Store[DB]) Update(f func(*DB)) {
+	if s.db == nil {
+		panic("uninitialized store")
+	}
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	f(s.db)
+}
+
+// SetCompression sets the compression level used when s is written.  The
+// level is one of the zlib levels from BestSpeed to BestCompression, or
+// DefaultCompression, HuffmanOnly, or NoCompression to store the data without
+// compression. A new store uses DefaultCompression. A store opened from data
+// written without compression uses NoCompression, and otherwise the default.
+func (s *Store[DB]) SetCompression(level int) error {
+	if level < HuffmanOnly || level > BestCompression {
+		return fmt.Errorf("invalid compression level %d", level)
+	}
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	s.level = level
+	return nil
+}
+
+// Compression reports the compression level used when s is written (see
+// SetCompression).
+func (s *Store[DB]) Compression() int {
+	s.μ.Lock()
+	defer s.μ.Unlock()
+	return s.level
+}
+
 // storeJSON is the JSON structure used to persist a Store.
 type storeJSON struct {
 	Format  string `json:"format"`            // currently kfstore.Format (ks1)
@@ -171,5 +286,8 @@ type storeJSON struct {
 	Data    []byte `json:"data"`              // encrypted with D(accessKey, dataKey)
 	KeySalt []byte `json:"keySalt,omitempty"` // access key derivation salt (optional)
 
-	// The data are compressed with zlib prior to encryption.
+	// The data are compressed with zlib prior to encryption, unless this is
+	// true. If so, the extra data for the encryption are marked, so that the
+	// flag is authenticated along with the data.
+	Uncompressed bool `json:"uncompressed,omitempty"`
 }
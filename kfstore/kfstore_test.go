@@ -3,13 +3,18 @@ package kfstore_test
 import (
 	"bytes"
 	crand "crypto/rand"
+	"errors"
 	"io"
 	mrand "math/rand"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/creachadair/keyfish/kfstore"
+	"github.com/creachadair/mds/mbits"
 	"github.com/creachadair/mds/mtest"
+	"github.com/creachadair/mds/value"
 	gocmp "github.com/google/go-cmp/cmp"
 )
 
@@ -109,3 +114,238 @@ func TestStore(t *testing.T) {
 		zero.DB()
 	}, "zero.DB() should panic")
 }
+
+func TestConcurrentAccess(t *testing.T) {
+	const testKey = "00000000000000000000000000000000"
+
+	s, err := kfstore.New[testDB]([]byte(testKey), nil, nil)
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+
+	// Run with -race to check that updates do not race with readers.
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := range 100 {
+			s.Update(func(db *testDB) { db.V = strings.Repeat("x", i) })
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			if _, err := s.WriteTo(io.Discard); err != nil {
+				t.Errorf("WriteTo: unexpected error: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			db, err := s.Snapshot()
+			if err != nil {
+				t.Errorf("Snapshot: unexpected error: %v", err)
+			} else if strings.Trim(db.V, "x") != "" {
+				t.Errorf("Snapshot: got %q, want only x", db.V)
+			}
+		}
+	}()
+	wg.Wait()
+
+	// A snapshot is independent of the store.
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: unexpected error: %v", err)
+	}
+	snap.V = "changed"
+	if got := s.DB().V; got == "changed" {
+		t.Error("Modifying a snapshot changed the store")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	const testKey = "00000000000000000000000000000000"
+	testValue := strings.Repeat("all work and no play makes jack a dull boy ", 50)
+
+	write := func(t *testing.T, s *kfstore.Store[testDB]) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if _, err := s.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo: unexpected error: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	sizes := make(map[int]int)
+	for level := kfstore.HuffmanOnly; level <= kfstore.BestCompression; level++ {
+		s, err := kfstore.New[testDB]([]byte(testKey), nil, &testDB{V: testValue})
+		if err != nil {
+			t.Fatalf("New: unexpected error: %v", err)
+		}
+		if err := s.SetCompression(level); err != nil {
+			t.Fatalf("SetCompression(%d): unexpected error: %v", level, err)
+		}
+		pkt := write(t, s)
+		sizes[level] = len(pkt)
+
+		raw := bytes.Contains(pkt, []byte(`"uncompressed":true`))
+		if raw != (level == kfstore.NoCompression) {
+			t.Errorf("Level %d: uncompressed=%v, want %v", level, raw, level == kfstore.NoCompression)
+		}
+		s2, err := kfstore.Open[testDB](bytes.NewReader(pkt), kfstore.AccessKey(testKey))
+		if err != nil {
+			t.Fatalf("Level %d: Open: unexpected error: %v", level, err)
+		}
+		if got := s2.DB().V; got != testValue {
+			t.Errorf("Level %d: got %q, want %q", level, got, testValue)
+		}
+
+		// The uncompressed setting persists across a reopen.
+		if again := bytes.Contains(write(t, s2), []byte(`"uncompressed":true`)); again != raw {
+			t.Errorf("Level %d: rewrite uncompressed=%v, want %v", level, again, raw)
+		}
+	}
+	if sizes[kfstore.NoCompression] <= sizes[kfstore.BestCompression] {
+		t.Errorf("Uncompressed size %d <= compressed size %d",
+			sizes[kfstore.NoCompression], sizes[kfstore.BestCompression])
+	}
+
+	t.Run("BadLevel", func(t *testing.T) {
+		s, err := kfstore.New[testDB]([]byte(testKey), nil, nil)
+		if err != nil {
+			t.Fatalf("New: unexpected error: %v", err)
+		}
+		if err := s.SetCompression(10); err == nil {
+			t.Error("SetCompression(10): got nil, want error")
+		}
+	})
+
+	t.Run("TamperFlag", func(t *testing.T) {
+		s, err := kfstore.New[testDB]([]byte(testKey), nil, &testDB{V: testValue})
+		if err != nil {
+			t.Fatalf("New: unexpected error: %v", err)
+		}
+		s.SetCompression(kfstore.NoCompression)
+		bad := bytes.Replace(write(t, s), []byte(`,"uncompressed":true`), nil, 1)
+		if s2, err := kfstore.Open[testDB](bytes.NewReader(bad), kfstore.AccessKey(testKey)); err == nil {
+			t.Errorf("Open with flag removed: got %v, want error", s2.DB())
+		}
+	})
+}
+
+func TestWriteZeroesPlaintext(t *testing.T) {
+	const testKey = "00000000000000000000000000000000"
+	const testValue = "a secret worth keeping"
+
+	for _, level := range []int{kfstore.DefaultCompression, kfstore.NoCompression} {
+		s, err := kfstore.New[testDB]([]byte(testKey), nil, &testDB{V: testValue})
+		if err != nil {
+			t.Fatalf("New: unexpected error: %v", err)
+		}
+		s.SetCompression(level)
+
+		var bufs [][]byte
+		var sawPlain bool
+		mtest.Swap(t, kfstore.ZeroBuffer, func(b []byte) int {
+			bufs = append(bufs, b)
+			sawPlain = sawPlain || bytes.Contains(b, []byte(testValue))
+			return mbits.Zero(b)
+		})
+		if _, err := s.WriteTo(io.Discard); err != nil {
+			t.Fatalf("WriteTo: unexpected error: %v", err)
+		}
+
+		want := value.Cond(level == kfstore.NoCompression, 1, 2)
+		if len(bufs) != want {
+			t.Errorf("Level %d: zeroed %d buffers, want %d", level, len(bufs), want)
+		}
+		if !sawPlain {
+			t.Errorf("Level %d: the plaintext buffer was not zeroed", level)
+		}
+		for i, b := range bufs {
+			if slices.ContainsFunc(b, func(c byte) bool { return c != 0 }) {
+				t.Errorf("Level %d: buffer %d not zeroed: %q", level, i, b)
+			}
+		}
+	}
+}
+
+func TestMaxStoreSize(t *testing.T) {
+	const testKey = "00000000000000000000000000000000"
+
+	s, err := kfstore.New[testDB]([]byte(testKey), nil, &testDB{V: "hello"})
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: unexpected error: %v", err)
+	}
+
+	// A store exactly at the limit is accepted.
+	mtest.Swap(t, &kfstore.MaxStoreSize, int64(buf.Len()))
+	if _, err := kfstore.Open[testDB](bytes.NewReader(buf.Bytes()), kfstore.AccessKey(testKey)); err != nil {
+		t.Errorf("Open at limit: unexpected error: %v", err)
+	}
+
+	// A store over the limit is rejected.
+	mtest.Swap(t, &kfstore.MaxStoreSize, int64(buf.Len()-1))
+	_, err = kfstore.Open[testDB](bytes.NewReader(buf.Bytes()), kfstore.AccessKey(testKey))
+	if !errors.Is(err, kfstore.ErrStoreTooLarge) {
+		t.Errorf("Open over limit: got %v, want %v", err, kfstore.ErrStoreTooLarge)
+	}
+}
+
+func TestAccessKeyZeroed(t *testing.T) {
+	const testKey = "00000000000000000000000000000000"
+
+	s, err := kfstore.New[testDB]([]byte(testKey), nil, &testDB{V: "hello"})
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: unexpected error: %v", err)
+	}
+
+	// Open must not modify the key returned by the KeyFunc, which may be
+	// shared with the caller.
+	var keys [][]byte
+	keyFunc := func([]byte) []byte {
+		k := []byte(testKey)
+		keys = append(keys, k)
+		return k
+	}
+	if _, err := kfstore.Open[testDB](bytes.NewReader(buf.Bytes()), keyFunc); err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("KeyFunc called %d times, want 1", len(keys))
+	}
+	if k := keys[0]; string(k) != testKey {
+		t.Errorf("Access key modified by Open: %q", k)
+	}
+
+	// Open zeroes its own copy of the access key once it is used.
+	var zeroed [][]byte
+	mtest.Swap(t, kfstore.ZeroBuffer, func(b []byte) int {
+		zeroed = append(zeroed, bytes.Clone(b))
+		return mbits.Zero(b)
+	})
+	if _, err := kfstore.Open[testDB](bytes.NewReader(buf.Bytes()), keyFunc); err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	if !slices.ContainsFunc(zeroed, func(b []byte) bool { return string(b) == testKey }) {
+		t.Error("Open did not zero its copy of the access key")
+	}
+
+	// AccessKey must not let Open zero the caller's key.
+	ownKey := []byte(testKey)
+	if _, err := kfstore.Open[testDB](bytes.NewReader(buf.Bytes()), kfstore.AccessKey(ownKey)); err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	if string(ownKey) != testKey {
+		t.Errorf("Caller's key was modified: %q", ownKey)
+	}
+}